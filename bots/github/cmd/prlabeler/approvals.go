@@ -0,0 +1,254 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v76/github"
+	"k8s.io/klog/v2"
+)
+
+// Marker of the comment recording the approved PR head and the approvals
+// granted to it. Markers of older releases carry no labels.
+var approvalMarker = regexp.MustCompile(`<!-- prlabeler approved: ([0-9a-f]+)(?: labels=(\S+))? -->`)
+
+// Prow commands withdrawing comment based approvals
+var cancelCommands = map[string]string{
+	"lgtm":     "/lgtm cancel",
+	"approved": "/approve cancel",
+}
+
+func approvedMarker(sha string, labels []string) string {
+	return fmt.Sprintf("<!-- prlabeler approved: %v labels=%v -->", sha, strings.Join(labels, ","))
+}
+
+func revocationMarker(sha string) string {
+	return fmt.Sprintf("<!-- prlabeler revoked: %v -->", sha)
+}
+
+// approvalsOf returns the approval labels among the labels.
+func approvalsOf(labels []string) []string {
+	approvals := []string{}
+	for _, label := range labels {
+		if approvalLabels[label] && !slices.Contains(approvals, label) {
+			approvals = append(approvals, label)
+		}
+	}
+	sort.Strings(approvals)
+	return approvals
+}
+
+// presentApprovals returns approval labels of the PR.
+func presentApprovals(pr *github.PullRequest) []string {
+	present := []string{}
+	for _, label := range pr.Labels {
+		present = append(present, label.GetName())
+	}
+	return approvalsOf(present)
+}
+
+// grantedApprovals returns approval labels of the PR granted by prlabeler.
+// Approvals recorded by older releases do not list the granted labels, all
+// present approvals are taken as granted then.
+func grantedApprovals(pr *github.PullRequest, decision *prDecision) []string {
+	present := presentApprovals(pr)
+	if decision.ApprovedLabels == nil {
+		return present
+	}
+	return slices.DeleteFunc(present, func(label string) bool { return !slices.Contains(decision.ApprovedLabels, label) })
+}
+
+// approvedSHA returns the PR head recorded at the last approval, the
+// approvals granted to it and the comment recording it.
+func approvedSHA(comments []*github.IssueComment) (string, []string, *github.IssueComment) {
+	for i := len(comments) - 1; i >= 0; i-- {
+		if match := approvalMarker.FindStringSubmatch(comments[i].GetBody()); match != nil {
+			var labels []string
+			if match[2] != "" {
+				labels = strings.Split(match[2], ",")
+			}
+			return match[1], labels, comments[i]
+		}
+	}
+	return "", nil, nil
+}
+
+// recordApproval records the approved PR head and the granted approvals so
+// the approvals can be revoked once the PR changes. A single comment is kept
+// and edited.
+func recordApproval(ctx context.Context, client *github.Client, owner, repo string, prNum int, pr *github.PullRequest, granted []string) error {
+	sha := pr.GetHead().GetSHA()
+	comments, err := getPRComments(ctx, client, owner, repo, prNum)
+	if err != nil {
+		return err
+	}
+	recorded, recordedLabels, comment := approvedSHA(comments)
+	if recorded == sha {
+		merged := approvalsOf(append(slices.Clone(recordedLabels), granted...))
+		if slices.Equal(merged, recordedLabels) {
+			return nil
+		}
+		granted = merged
+	}
+
+	body := fmt.Sprintf("prlabeler approved this PR at %v. Approvals are revoked when the PR changes and no longer passes validation.\n\n%v", sha, approvedMarker(sha, granted))
+	klog.InfoS("Recording the approved head", "number", prNum, "sha", sha, "labels", granted)
+	if comment != nil {
+		_, _, err = client.Issues.EditComment(ctx, owner, repo, comment.GetID(), &github.IssueComment{Body: github.String(body)})
+		return err
+	}
+	_, _, err = client.Issues.CreateComment(ctx, owner, repo, prNum, &github.IssueComment{Body: github.String(body)})
+	return err
+}
+
+// recordGrantedApprovals records the approvals granted to the PR head, in
+// the PR state or in a PR comment for stateless runs, and announces them.
+func recordGrantedApprovals(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest, state *prState, granted []string) error {
+	granted = approvalsOf(granted)
+	if len(granted) == 0 {
+		return nil
+	}
+	sha := pr.GetHead().GetSHA()
+	if state == nil {
+		if err := recordApproval(ctx, client, owner, repo, pr.GetNumber(), pr, granted); err != nil {
+			return err
+		}
+	}
+	notifications.notify(eventApproved, pr, sha)
+	return updatePRState(ctx, client, pr, func(state *prState) {
+		if state.ApprovedSHA == sha {
+			granted = approvalsOf(append(slices.Clone(state.ApprovedLabels), granted...))
+		}
+		state.ApprovedSHA = sha
+		state.ApprovedLabels = granted
+	})
+}
+
+// validationFailures explains why the PR does not pass validation, nil when
+// it does.
+func validationFailures(decision *prDecision) []string {
+	failures := slices.Clone(decision.TrustViolations)
+	passes := false
+	for _, rule := range decision.Rules {
+		switch {
+		case rule.applies():
+			passes = true
+		case rule.Matched && len(rule.Reasons) > 0:
+			for _, reason := range rule.Reasons {
+				failures = append(failures, fmt.Sprintf("%v: %v", rule.Name, reason))
+			}
+		case rule.Matched && rule.OffendingFile != "":
+			failures = append(failures, fmt.Sprintf("%v: %v is not allowed", rule.Name, rule.OffendingFile))
+		case rule.Matched:
+			failures = append(failures, fmt.Sprintf("%v: %v failed", rule.Name, rule.Validator))
+		}
+	}
+	if passes && len(decision.TrustViolations) == 0 {
+		return nil
+	}
+	if len(failures) == 0 {
+		failures = append(failures, "the PR no longer matches any rule")
+	}
+	return failures
+}
+
+// revokeStaleApproval withdraws approvals prlabeler granted to an earlier PR
+// head when the PR changed since and no longer passes validation. Approvals
+// given through comments are canceled through Prow, the others are removed.
+// Approvals applied by humans are kept. The revocation is explained once per
+// PR head. Outside of active hours only approval labels are removed, nothing
+// is commented.
+func revokeStaleApproval(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest, decision *prDecision, actions prActions) error {
+	sha := pr.GetHead().GetSHA()
+	if decision.ApprovedSHA == "" || decision.ApprovedSHA == sha {
+		return nil
+	}
+	present := grantedApprovals(pr, decision)
+	if len(present) == 0 {
+		return nil
+	}
+	failures := validationFailures(decision)
+	if failures == nil {
+		return nil
+	}
+	prNum := pr.GetNumber()
+
+	if state := decision.State; state != nil {
+		if state.RevokedSHA == sha {
+			klog.InfoS("Approval already revoked", "number", prNum, "sha", sha)
+			return nil
+		}
+	} else {
+		comments, err := getPRComments(ctx, client, owner, repo, prNum)
+		if err != nil {
+			return err
+		}
+		for _, comment := range comments {
+			if strings.Contains(comment.GetBody(), revocationMarker(sha)) {
+				klog.InfoS("Approval already revoked", "number", prNum, "sha", sha)
+				return nil
+			}
+		}
+	}
+
+	commentBased := make(map[string]bool)
+	for _, rule := range decision.Rules {
+		for label := range rule.Label2Comments {
+			commentBased[label] = true
+		}
+	}
+	klog.InfoS("Revoking stale approval", "number", prNum, "approved", decision.ApprovedSHA, "head", sha, "labels", present, "reasons", failures)
+	removed := []string{}
+	for _, label := range present {
+		if commentBased[label] {
+			if !actions.Comment {
+				klog.InfoS("Outside of active hours, postponing the cancellation", "number", prNum, "label", label)
+				continue
+			}
+			if _, _, err := client.Issues.CreateComment(ctx, owner, repo, prNum, &github.IssueComment{Body: github.String(cancelCommands[label])}); err != nil {
+				return fmt.Errorf("error canceling %v: %v", label, err)
+			}
+			removed = append(removed, label)
+			continue
+		}
+		if _, err := client.Issues.RemoveLabelForIssue(ctx, owner, repo, prNum, label); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error removing %v label: %v", label, err)
+		}
+		removed = append(removed, label)
+	}
+	if len(removed) > 0 {
+		notifications.notify(eventRevoked, pr, sha, failures...)
+	}
+	if !actions.Comment {
+		klog.InfoS("Outside of active hours, skipping the revocation comment", "number", prNum)
+		return nil
+	}
+
+	message := fmt.Sprintf("prlabeler approved %v, the PR has changed since (now at %v) and no longer passes validation:\n\n- %v\n\nRevoking %v.", decision.ApprovedSHA, sha, strings.Join(failures, "\n- "), strings.Join(present, " and "))
+	if _, _, err := client.Issues.CreateComment(ctx, owner, repo, prNum, &github.IssueComment{
+		Body: github.String(message + "\n\n" + revocationMarker(sha)),
+	}); err != nil {
+		return err
+	}
+	return updatePRState(ctx, client, pr, func(state *prState) { state.RevokedSHA = sha })
+}
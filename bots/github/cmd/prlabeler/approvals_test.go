@@ -0,0 +1,180 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v76/github"
+)
+
+func TestValidationFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		violations []string
+		rules      []ruleTrace
+		failures   []string
+	}{
+		{
+			name:  "a rule applies",
+			rules: []ruleTrace{failingRule, passingRule},
+		},
+		{
+			name:       "trust violations",
+			violations: []string{"commit 1234567 is authored by someone"},
+			rules:      []ruleTrace{passingRule},
+			failures:   []string{"commit 1234567 is authored by someone"},
+		},
+		{
+			name:     "offending file",
+			rules:    []ruleTrace{failingRule},
+			failures: []string{"konflux references update: .tekton/images-mirror-set.yaml is not allowed"},
+		},
+		{
+			name:     "reasons and failed validators",
+			rules:    []ruleTrace{reasonsRule, {Name: "bundle update", Validator: "validateBundle", Matched: true}},
+			failures: []string{"go module update: main.go is not allowed", "bundle update: validateBundle failed"},
+		},
+		{
+			name:     "no rule matches",
+			rules:    []ruleTrace{otherRule},
+			failures: []string{"the PR no longer matches any rule"},
+		},
+	}
+	for _, test := range tests {
+		failures := validationFailures(&prDecision{TrustViolations: test.violations, Rules: test.rules})
+		if !reflect.DeepEqual(failures, test.failures) {
+			t.Errorf("%v: expected %q, got %q", test.name, test.failures, failures)
+		}
+	}
+}
+
+func TestApprovedSHA(t *testing.T) {
+	comments := []*github.IssueComment{
+		{Body: github.String("prlabeler approved this PR at abc.\n\n<!-- prlabeler approved: abc -->")},
+		{Body: github.String("/lgtm")},
+	}
+	// Markers of older releases carry no labels
+	if sha, labels, _ := approvedSHA(comments); sha != "abc" || labels != nil {
+		t.Errorf("unexpected approval %v %q", sha, labels)
+	}
+	comments = append(comments, &github.IssueComment{Body: github.String(approvedMarker("def", []string{"approved", "lgtm"}))})
+	if sha, labels, _ := approvedSHA(comments); sha != "def" || !reflect.DeepEqual(labels, []string{"approved", "lgtm"}) {
+		t.Errorf("unexpected approval %v %q", sha, labels)
+	}
+}
+
+func TestRecordGrantedApprovals(t *testing.T) {
+	ctx := context.Background()
+	setClock(t, time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC))
+	issues, client := newFakeIssues(t)
+	pr := testPR(1, "abc")
+
+	if err := recordGrantedApprovals(ctx, client, "org", "repo", pr, nil, []string{"needs-ok-to-test"}); err != nil {
+		t.Fatal(err)
+	}
+	if writes := issues.takeWrites(); len(writes) != 0 {
+		t.Fatalf("expected nothing recorded without approvals, got %q", writes)
+	}
+	for _, granted := range [][]string{{"lgtm"}, {"lgtm"}, {"approved"}} {
+		if err := recordGrantedApprovals(ctx, client, "org", "repo", pr, nil, granted); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if writes := issues.takeWrites(); len(writes) != 2 {
+		t.Fatalf("expected the approval to be recorded and extended once, got %q", writes)
+	}
+	if sha, labels, _ := approvedSHA(issues.commentsOf(1)); sha != "abc" || !reflect.DeepEqual(labels, []string{"approved", "lgtm"}) {
+		t.Errorf("unexpected approval %v %q", sha, labels)
+	}
+}
+
+func TestRevokeStaleApprovalIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	setClock(t, time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC))
+	issues, client := newFakeIssues(t)
+	// prlabeler approved abc through /lgtm, approved was applied by a human
+	issues.addComment(1, approvedMarker("abc", []string{"lgtm"}), nil)
+	pr := testPR(1, "def")
+	pr.Labels = []*github.Label{{Name: github.String("approved")}, {Name: github.String("lgtm")}}
+	rule := failingRule
+	rule.Label2Comments = map[string]string{"lgtm": "/lgtm"}
+	decision := &prDecision{Rules: []ruleTrace{rule}}
+	decision.ApprovedSHA, decision.ApprovedLabels, _ = approvedSHA(issues.commentsOf(1))
+	actions := prActions{Approve: true, Comment: true}
+
+	if err := revokeStaleApproval(ctx, client, "org", "repo", pr, decision, actions); err != nil {
+		t.Fatal(err)
+	}
+	if writes := issues.takeWrites(); len(writes) != 2 {
+		t.Fatalf("expected lgtm to be canceled and the revocation explained, got %q", writes)
+	}
+	comments := issues.commentsOf(1)
+	if body := comments[1].GetBody(); body != "/lgtm cancel" {
+		t.Errorf("expected lgtm to be canceled, got %q", body)
+	}
+	if body := comments[2].GetBody(); !strings.Contains(body, "Revoking lgtm.") || !strings.Contains(body, revocationMarker("def")) {
+		t.Errorf("unexpected revocation comment %q", body)
+	}
+
+	if err := revokeStaleApproval(ctx, client, "org", "repo", pr, decision, actions); err != nil {
+		t.Fatal(err)
+	}
+	if writes := issues.takeWrites(); len(writes) != 0 {
+		t.Fatalf("expected no writes on the second run, got %q", writes)
+	}
+}
+
+func TestRevokeStaleApprovalOutsideActiveHours(t *testing.T) {
+	ctx := context.Background()
+	setClock(t, time.Date(2025, 3, 3, 22, 0, 0, 0, time.UTC))
+	setStateFile(t)
+	issues, client := newFakeIssues(t)
+	pr := testPR(1, "def")
+	pr.Labels = []*github.Label{{Name: github.String("approved")}, {Name: github.String("lgtm")}}
+	if err := updatePRState(ctx, client, pr, func(state *prState) {
+		state.ApprovedSHA = "abc"
+		state.ApprovedLabels = []string{"approved", "lgtm"}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadPRState(ctx, client, pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := failingRule
+	rule.Labels = []string{"approved"}
+	rule.Label2Comments = map[string]string{"lgtm": "/lgtm"}
+	decision := &prDecision{Rules: []ruleTrace{rule}, ApprovedSHA: state.ApprovedSHA, ApprovedLabels: state.ApprovedLabels, State: state}
+
+	if err := revokeStaleApproval(ctx, client, "org", "repo", pr, decision, prActions{Approve: true}); err != nil {
+		t.Fatal(err)
+	}
+	if writes := issues.takeWrites(); !reflect.DeepEqual(writes, []string{"DELETE /repos/org/repo/issues/1/labels/approved"}) {
+		t.Fatalf("expected only the approved label to be removed, got %q", writes)
+	}
+	if state, err = loadPRState(ctx, client, pr); err != nil {
+		t.Fatal(err)
+	}
+	if state.RevokedSHA != "" {
+		t.Errorf("expected the revocation to wait for the explanation, got revoked %v", state.RevokedSHA)
+	}
+}
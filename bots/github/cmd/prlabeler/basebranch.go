@@ -0,0 +1,125 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/go-github/v76/github"
+)
+
+// Latest statuses of base branches fetched during the current run, keyed by
+// owner/repo@branch
+var baseBranchStatuses = make(map[string]map[string]*github.RepoStatus)
+
+// Built-in mapping of Konflux pull request pipelines to the push pipelines
+// of the base branch.
+var builtinBaseBranchContexts = BaseBranchContexts{
+	Rewrites: []ContextRewrite{
+		{Pattern: `^(Red Hat Konflux / .+)-on-pull-request$`, Replacement: "${1}-on-push"},
+	},
+}
+
+// baseBranchContext returns the context of the base branch job
+// corresponding to the PR context.
+func baseBranchContext(cfg *PRLabelerConfig, context string) string {
+	contexts := cfg.Spec.BaseBranchContexts
+	if contexts == nil {
+		contexts = &builtinBaseBranchContexts
+	}
+	if mapped, exists := contexts.Contexts[context]; exists {
+		return mapped
+	}
+	for _, rewrite := range contexts.Rewrites {
+		re, err := regexp.Compile(rewrite.Pattern)
+		if err != nil {
+			continue
+		}
+		if re.MatchString(context) {
+			return re.ReplaceAllString(context, rewrite.Replacement)
+		}
+	}
+	return context
+}
+
+// getBaseBranchStatuses returns the latest status of each context of the
+// base branch head.
+func getBaseBranchStatuses(ctx context.Context, client *github.Client, owner, repo, branch string) (map[string]*github.RepoStatus, error) {
+	key := fmt.Sprintf("%v/%v@%v", owner, repo, branch)
+	if statuses, exists := baseBranchStatuses[key]; exists {
+		return statuses, nil
+	}
+	statuses, err := listStatuses(ctx, client, owner, repo, branch)
+	if err != nil {
+		return nil, fmt.Errorf("Could not list statuses of the %v base branch: %v", branch, err)
+	}
+	latest := make(map[string]*github.RepoStatus)
+	for _, status := range statuses {
+		if _, exists := latest[status.GetContext()]; !exists {
+			latest[status.GetContext()] = status
+		}
+	}
+	baseBranchStatuses[key] = latest
+	return latest, nil
+}
+
+// baseBranchBroken tells whether the context is failing on the base branch as
+// well, or pending there for longer than a retest would wait for.
+func baseBranchBroken(status *github.RepoStatus, now time.Time) (bool, string) {
+	switch status.GetState() {
+	case "failure", "error":
+		return true, status.GetState()
+	case "pending":
+		if updatedAt := status.UpdatedAt.GetTime(); updatedAt != nil && updatedAt.Add(retestInterval).Before(now) {
+			return true, fmt.Sprintf("pending since %v", updatedAt)
+		}
+	}
+	return false, ""
+}
+
+// classifyBaseBranch holds off retests and overrides of contexts that are
+// broken on the base branch too. Once the base branch is green the contexts
+// are retested and overridden again.
+func classifyBaseBranch(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest, traces []statusTrace, now time.Time) error {
+	var statuses map[string]*github.RepoStatus
+	branch := pr.GetBase().GetRef()
+	for i := range traces {
+		trace := &traces[i]
+		if trace.Class != statusPendingTooLong && trace.Class != statusOverrideEligible {
+			continue
+		}
+		if statuses == nil {
+			var err error
+			if statuses, err = getBaseBranchStatuses(ctx, client, owner, repo, branch); err != nil {
+				return err
+			}
+		}
+		baseContext := baseBranchContext(config, trace.Context)
+		status, exists := statuses[baseContext]
+		if !exists {
+			continue
+		}
+		if broken, state := baseBranchBroken(status, now); broken {
+			trace.Class = statusBaseBranchBroken
+			trace.Reason = fmt.Sprintf("base branch broken, %v is %v on %v", baseContext, state, branch)
+		}
+	}
+	return nil
+}
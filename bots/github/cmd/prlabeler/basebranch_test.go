@@ -0,0 +1,90 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBaseBranchContext(t *testing.T) {
+	previous := config
+	defer func() { config = previous }()
+
+	config = &PRLabelerConfig{}
+	if context := baseBranchContext(config, "Red Hat Konflux / operator-on-pull-request"); context != "Red Hat Konflux / operator-on-push" {
+		t.Errorf("unexpected built-in mapping of the Konflux pipeline: %v", context)
+	}
+	if context := baseBranchContext(config, "ci/prow/unit"); context != "ci/prow/unit" {
+		t.Errorf("expected unmapped contexts to keep their name, got %v", context)
+	}
+
+	config.Spec.BaseBranchContexts = &BaseBranchContexts{
+		Contexts: map[string]string{"ci/prow/e2e-aws-operator": "ci/prow/e2e-aws-operator-periodic"},
+		Rewrites: []ContextRewrite{{Pattern: `^ci/prow/(.+)$`, Replacement: "ci/postsubmit/${1}"}},
+	}
+	for context, expected := range map[string]string{
+		"ci/prow/e2e-aws-operator": "ci/prow/e2e-aws-operator-periodic",
+		"ci/prow/unit":             "ci/postsubmit/unit",
+		// The configured mapping replaces the built-in one
+		"Red Hat Konflux / operator-on-pull-request": "Red Hat Konflux / operator-on-pull-request",
+	} {
+		if mapped := baseBranchContext(config, context); mapped != expected {
+			t.Errorf("expected %v to map to %v, got %v", context, expected, mapped)
+		}
+	}
+}
+
+func TestClassifyBaseBranch(t *testing.T) {
+	previous := config
+	defer func() { config = previous }()
+	config = &PRLabelerConfig{}
+	t.Cleanup(func() { clear(baseBranchStatuses) })
+
+	now := time.Date(2025, 3, 3, 12, 0, 0, 0, time.UTC)
+	// The push pipeline fails on the base branch, its status is on the
+	// second page
+	client := testGitHubClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/org/repo/commits/main/statuses" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%v%v?page=2&per_page=100>; rel="next"`, r.Host, r.URL.Path))
+			fmt.Fprint(w, `[{"context": "ci/prow/unit", "state": "success", "updated_at": "2025-03-03T10:00:00Z"}]`)
+			return
+		}
+		fmt.Fprint(w, `[{"context": "Red Hat Konflux / operator-on-push", "state": "failure", "updated_at": "2025-03-03T09:00:00Z"}]`)
+	}))
+
+	traces := []statusTrace{
+		{Context: "Red Hat Konflux / operator-on-pull-request", State: "pending", Class: statusPendingTooLong},
+		{Context: "ci/prow/unit", State: "failure", Class: statusOverrideEligible},
+	}
+	if err := classifyBaseBranch(context.Background(), client, "org", "repo", testPR(1, "abc"), traces, now); err != nil {
+		t.Fatal(err)
+	}
+	if traces[0].Class != statusBaseBranchBroken || traces[0].Reason != "base branch broken, Red Hat Konflux / operator-on-push is failure on main" {
+		t.Errorf("expected the Konflux pipeline to be held off, got %v (%v)", traces[0].Class, traces[0].Reason)
+	}
+	if traces[1].Class != statusOverrideEligible {
+		t.Errorf("expected the unit job to stay override-eligible, got %v (%v)", traces[1].Class, traces[1].Reason)
+	}
+}
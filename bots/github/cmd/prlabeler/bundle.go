@@ -0,0 +1,218 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v76/github"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	bundleDockerfile = "bundle.Dockerfile"
+	// Manifests directory of bundles not copying it explicitly
+	bundleManifestsDir = "manifests"
+)
+
+type csvContainer struct {
+	Name  string `yaml:"name"`
+	Image string `yaml:"image"`
+}
+
+// clusterServiceVersion holds the parts of a CSV relevant for the
+// consistency check.
+type clusterServiceVersion struct {
+	Spec struct {
+		RelatedImages []struct {
+			Name  string `yaml:"name"`
+			Image string `yaml:"image"`
+		} `yaml:"relatedImages"`
+		Install struct {
+			Spec struct {
+				Deployments []struct {
+					Name string `yaml:"name"`
+					Spec struct {
+						Template struct {
+							Spec struct {
+								Containers     []csvContainer `yaml:"containers"`
+								InitContainers []csvContainer `yaml:"initContainers"`
+							} `yaml:"spec"`
+						} `yaml:"template"`
+					} `yaml:"spec"`
+				} `yaml:"deployments"`
+			} `yaml:"spec"`
+		} `yaml:"install"`
+	} `yaml:"spec"`
+}
+
+// dockerfileInstructions joins continued lines and drops comments.
+func dockerfileInstructions(content string) []string {
+	instructions := []string{}
+	current := ""
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if continued, found := strings.CutSuffix(line, "\\"); found {
+			current += continued + " "
+			continue
+		}
+		current += line
+		if current != "" {
+			instructions = append(instructions, current)
+		}
+		current = ""
+	}
+	if current != "" {
+		instructions = append(instructions, current)
+	}
+	return instructions
+}
+
+// parseBundleDockerfile returns digest pinned images referenced by LABEL
+// instructions and the directory copied into /manifests/.
+func parseBundleDockerfile(content string) ([]imageDigest, string) {
+	images := []imageDigest{}
+	manifestsDir := bundleManifestsDir
+	for _, instruction := range dockerfileInstructions(content) {
+		fields := strings.Fields(instruction)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "LABEL":
+			images = append(images, parseImageDigests(instruction)...)
+		case "COPY":
+			if len(fields) == 3 && strings.TrimSuffix(fields[2], "/") == "/manifests" {
+				manifestsDir = strings.TrimSuffix(fields[1], "/")
+			}
+		}
+	}
+	return images, manifestsDir
+}
+
+// imageRepository identifies an image regardless of its digest.
+func imageRepository(image imageDigest) string {
+	return image.Registry + "/" + image.Repository
+}
+
+// validateBundleConsistency checks every image in bundle.Dockerfile labels
+// is listed in the CSV relatedImages and that container images of the CSV
+// deployments are listed in relatedImages and match the labels.
+func validateBundleConsistency(dockerfileImages []imageDigest, csv *clusterServiceVersion) []string {
+	reasons := []string{}
+
+	related := make(map[string]bool)
+	for _, relatedImage := range csv.Spec.RelatedImages {
+		for _, image := range parseImageDigests(relatedImage.Image) {
+			related[image.String()] = true
+		}
+	}
+	labeled := make(map[string][]string)
+	for _, image := range dockerfileImages {
+		if !related[image.String()] {
+			reasons = append(reasons, fmt.Sprintf("%v from %v labels is not listed in the CSV relatedImages", image, bundleDockerfile))
+		}
+		labeled[imageRepository(image)] = append(labeled[imageRepository(image)], image.String())
+	}
+
+	for _, deployment := range csv.Spec.Install.Spec.Deployments {
+		containers := slices.Concat(deployment.Spec.Template.Spec.InitContainers, deployment.Spec.Template.Spec.Containers)
+		for _, container := range containers {
+			for _, image := range parseImageDigests(container.Image) {
+				if !related[image.String()] {
+					reasons = append(reasons, fmt.Sprintf("container %v of deployment %v uses %v which is not listed in the CSV relatedImages", container.Name, deployment.Name, image))
+				}
+				if digests, exists := labeled[imageRepository(image)]; exists && !slices.Contains(digests, image.String()) {
+					reasons = append(reasons, fmt.Sprintf("container %v of deployment %v uses %v while %v labels reference %v", container.Name, deployment.Name, image, bundleDockerfile, strings.Join(digests, ", ")))
+				}
+			}
+		}
+	}
+	return reasons
+}
+
+// getBundleCSV loads the ClusterServiceVersion from the bundle manifests
+// directory at the given git reference.
+func getBundleCSV(ctx context.Context, client *github.Client, owner, repo, dir, ref string) (*clusterServiceVersion, string, error) {
+	_, entries, _, err := client.Repositories.GetContents(ctx, owner, repo, dir, &github.RepositoryContentGetOptions{Ref: ref})
+	if err != nil {
+		return nil, "", fmt.Errorf("error listing %v at %v: %w", dir, ref, err)
+	}
+	for _, entry := range entries {
+		if entry.GetType() != "file" || !strings.HasSuffix(entry.GetName(), ".clusterserviceversion.yaml") {
+			continue
+		}
+		filename := path.Join(dir, entry.GetName())
+		content, err := getFileContent(ctx, client, owner, repo, filename, ref)
+		if err != nil {
+			return nil, "", err
+		}
+		csv := &clusterServiceVersion{}
+		if err := yaml.Unmarshal([]byte(content), csv); err != nil {
+			return nil, "", fmt.Errorf("unable to parse %v: %v", filename, err)
+		}
+		return csv, filename, nil
+	}
+	return nil, "", nil
+}
+
+// evaluateBundleConsistency validates the bundle manifests at the PR head
+// against bundle.Dockerfile. Inconsistencies invalidate the rule and are
+// reported on the PR.
+func evaluateBundleConsistency(ctx context.Context, client *github.Client, pr *github.PullRequest, rule *ruleTrace) error {
+	if !rule.applies() {
+		return nil
+	}
+
+	owner := pr.GetHead().GetRepo().GetOwner().GetLogin()
+	repo := pr.GetHead().GetRepo().GetName()
+	headSHA := pr.GetHead().GetSHA()
+	dockerfile, err := getFileContent(ctx, client, owner, repo, bundleDockerfile, headSHA)
+	if err != nil {
+		return err
+	}
+	images, manifestsDir := parseBundleDockerfile(dockerfile)
+
+	reasons := []string{}
+	csv, csvFile, err := getBundleCSV(ctx, client, owner, repo, manifestsDir, headSHA)
+	switch {
+	case isNotFound(err):
+		reasons = append(reasons, fmt.Sprintf("bundle manifests directory %v does not exist", manifestsDir))
+	case err != nil:
+		return err
+	case csv == nil:
+		reasons = append(reasons, fmt.Sprintf("no ClusterServiceVersion found in %v", manifestsDir))
+	default:
+		reasons = validateBundleConsistency(images, csv)
+	}
+
+	if len(reasons) == 0 {
+		rule.Reasons = append(rule.Reasons, fmt.Sprintf("%v is consistent with %v", csvFile, bundleDockerfile))
+		return nil
+	}
+	rule.Valid = false
+	rule.Reasons = append(rule.Reasons, reasons...)
+	rule.Report = fmt.Sprintf("The bundle manifests are not consistent with %v, the PR is not approved automatically:\n\n- %v", bundleDockerfile, strings.Join(reasons, "\n- "))
+	return nil
+}
@@ -0,0 +1,308 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redacted = "REDACTED"
+
+// Response headers kept in cassettes. The rest (cookies, rate limits of the
+// recording token, ...) is dropped.
+var cassetteHeaders = []string{"Content-Type", "Link", "Retry-After", "Location"}
+
+// Tokens handed out in response bodies (GitHub App installations, registry
+// token services)
+var responseToken = regexp.MustCompile(`("(?:token|access_token)"\s*:\s*")[^"]*(")`)
+
+var (
+	// Transport of all clients when replaying a cassette
+	replayer *replayTransport
+	// Recorder shared by all clients
+	cassetteRecorder *recorder
+	// Secrets redacted from recorded interactions and from requests matched
+	// against replayed ones
+	cassetteSecrets = &secretSet{}
+)
+
+// openCassette opens the cassette to replay (--replay) or record (--record)
+// if any. The replayed run sees the recorded time.
+func openCassette() error {
+	var err error
+	switch {
+	case replayFilename != "":
+		if replayer, err = newReplayTransport(replayFilename); err != nil {
+			return err
+		}
+		runClock = replayer
+	case recordFilename != "":
+		if cassetteRecorder, err = newRecorder(recordFilename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cassetteTransport returns the transport of an HTTP client. All clients go
+// through it so a run is recorded or replayed as a whole. The base transport
+// is not used when replaying, replayed runs are offline.
+func cassetteTransport(base http.RoundTripper) http.RoundTripper {
+	switch {
+	case replayer != nil:
+		return replayer
+	case cassetteRecorder != nil:
+		return cassetteRecorder.transport(base)
+	}
+	return base
+}
+
+// secretSet holds secrets known to the run, e.g. credentials seen in
+// Authorization headers or webhook URLs.
+type secretSet struct {
+	mutex   sync.Mutex
+	secrets []string
+}
+
+func (s *secretSet) add(secret string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if secret != "" && !slices.Contains(s.secrets, secret) {
+		s.secrets = append(s.secrets, secret)
+	}
+}
+
+func (s *secretSet) redact(value string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, secret := range s.secrets {
+		value = strings.ReplaceAll(value, secret, redacted)
+	}
+	return value
+}
+
+// interaction is a single recorded HTTP request and its response. Cassettes
+// store one interaction per line and double as test fixtures.
+type interaction struct {
+	Time        time.Time `json:"time"`
+	Method      string    `json:"method"`
+	URL         string    `json:"url"`
+	RequestBody string    `json:"requestBody,omitempty"`
+	// Credentials of the request, always redacted
+	Authorization string      `json:"authorization,omitempty"`
+	Status        int         `json:"status"`
+	Header        http.Header `json:"header,omitempty"`
+	Body          string      `json:"body"`
+}
+
+func (i *interaction) key() string {
+	return i.Method + " " + i.URL + " " + i.RequestBody
+}
+
+// readRequestBody reads the request body and replaces it so the request can
+// still be sent.
+func readRequestBody(req *http.Request) (string, error) {
+	if req.Body == nil {
+		return "", nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return string(body), nil
+}
+
+// recorder saves requests and responses of all its transports to a single
+// cassette file. Credentials of the requests, whatever their source, and
+// tokens in responses are redacted.
+type recorder struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func newRecorder(filename string) (*recorder, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cassette: %v", err)
+	}
+	return &recorder{
+		file:    file,
+		encoder: json.NewEncoder(file),
+	}, nil
+}
+
+func (r *recorder) record(recorded *interaction) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.encoder.Encode(recorded)
+}
+
+// transport returns a transport recording the traffic of the base transport.
+func (r *recorder) transport(base http.RoundTripper) http.RoundTripper {
+	return &recordTransport{recorder: r, base: base}
+}
+
+type recordTransport struct {
+	recorder *recorder
+	base     http.RoundTripper
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	started := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	// Credentials are set either by the client or by the base transport
+	// (e.g. oauth2), so they are learned from the request actually sent.
+	// Both "Bearer TOKEN" and the TOKEN alone are redacted wherever they
+	// appear.
+	authorization := req.Header.Get("Authorization")
+	if resp.Request != nil && resp.Request.Header.Get("Authorization") != "" {
+		authorization = resp.Request.Header.Get("Authorization")
+	}
+	if authorization != "" {
+		cassetteSecrets.add(authorization)
+		if _, credential, found := strings.Cut(authorization, " "); found {
+			cassetteSecrets.add(credential)
+		}
+		authorization = redacted
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	recorded := &interaction{
+		Time:          started,
+		Method:        req.Method,
+		URL:           cassetteSecrets.redact(req.URL.String()),
+		RequestBody:   cassetteSecrets.redact(requestBody),
+		Authorization: authorization,
+		Status:        resp.StatusCode,
+		Header:        make(http.Header),
+		Body:          cassetteSecrets.redact(responseToken.ReplaceAllString(string(body), "${1}"+redacted+"${2}")),
+	}
+	for _, header := range cassetteHeaders {
+		if values := resp.Header.Values(header); len(values) > 0 {
+			recorded.Header[header] = values
+		}
+	}
+
+	if err := t.recorder.record(recorded); err != nil {
+		return nil, fmt.Errorf("unable to record %v %v: %v", req.Method, req.URL, err)
+	}
+	return resp, nil
+}
+
+// replayTransport answers requests from a cassette without any network
+// access. Repeated requests are answered in the recorded order, the last
+// response is reused once they run out. It also serves as the clock of the
+// replayed run so time dependent decisions come out the same.
+type replayTransport struct {
+	mutex        sync.Mutex
+	interactions map[string][]*interaction
+	now          time.Time
+}
+
+func newReplayTransport(filename string) (*replayTransport, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open cassette: %v", err)
+	}
+	defer file.Close()
+
+	transport := &replayTransport{interactions: make(map[string][]*interaction)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		recorded := &interaction{}
+		if err := json.Unmarshal(scanner.Bytes(), recorded); err != nil {
+			return nil, fmt.Errorf("unable to parse cassette %v line %v: %v", filename, line, err)
+		}
+		if transport.now.IsZero() {
+			transport.now = recorded.Time
+		}
+		transport.interactions[recorded.key()] = append(transport.interactions[recorded.key()], recorded)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read cassette %v: %v", filename, err)
+	}
+	return transport, nil
+}
+
+func (t *replayTransport) Now() time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.now
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	// Secrets known to the replayed run are redacted as they were recorded
+	key := (&interaction{Method: req.Method, URL: cassetteSecrets.redact(req.URL.String()), RequestBody: cassetteSecrets.redact(requestBody)}).key()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	queue := t.interactions[key]
+	if len(queue) == 0 {
+		return nil, fmt.Errorf("no recorded interaction for %v %v", req.Method, req.URL)
+	}
+	recorded := queue[0]
+	if len(queue) > 1 {
+		t.interactions[key] = queue[1:]
+	}
+	t.now = recorded.Time
+
+	header := recorded.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode: recorded.Status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(recorded.Body)),
+		Request:    req,
+	}, nil
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useCassette replays the cassette for the duration of the test.
+func useCassette(t *testing.T, filename string) {
+	t.Helper()
+	previousClock, previousReplay := runClock, replayFilename
+	replayFilename = filename
+	if err := openCassette(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		runClock, replayFilename, replayer = previousClock, previousReplay, nil
+		clear(githubClients)
+		clear(baseBranchStatuses)
+	})
+}
+
+func TestReplayStatusTraces(t *testing.T) {
+	useCassette(t, "testdata/status-traces.jsonl")
+	ctx := context.Background()
+	client, err := newGitHubClient(ctx, "org", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr := testPR(7, "abc123")
+	policy := &BranchPolicy{OverridableContexts: []string{"ci/prow/unit"}}
+	traces, err := getStatusTraces(ctx, client, "org", "repo", 7, pr, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The Konflux job has been pending for 6 hours at the recorded time, but it
+	// was retested an hour ago. The unit job failed on infrastructure and
+	// passes on the base branch, so it can be overridden.
+	expected := map[string]statusClass{
+		"Red Hat Konflux / operator-on-pull-request": statusRecentlyRetested,
+		"ci/prow/unit":   statusOverrideEligible,
+		"ci/prow/images": statusIgnored,
+	}
+	if len(traces) != len(expected) {
+		t.Fatalf("expected %v traces, got %+v", len(expected), traces)
+	}
+	for _, trace := range traces {
+		if trace.Class != expected[trace.Context] {
+			t.Errorf("expected %v to be %q, got %q (%v)", trace.Context, expected[trace.Context], trace.Class, trace.Reason)
+		}
+	}
+	if reason := traces[1].Reason; !strings.HasPrefix(reason, string(failureInfrastructure)) {
+		t.Errorf("expected the unit failure to be classified as an infrastructure failure, got %q", reason)
+	}
+}
+
+func TestRecordRedactsCredentials(t *testing.T) {
+	const token = "ghs_secrettoken"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token": "ghs_installationtoken", "echo": %q}`, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	previousSecrets := cassetteSecrets
+	cassetteSecrets = &secretSet{}
+	defer func() { cassetteSecrets = previousSecrets }()
+	filename := filepath.Join(t.TempDir(), "cassette.jsonl")
+	recorder, err := newRecorder(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The token is set by the base transport, the way oauth2 sets it
+	client := &http.Client{Transport: recorder.transport(&tokenTransport{token: token})}
+	resp, err := client.Get(server.URL + "/installation?access=" + token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	recorder.file.Close()
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{token, "ghs_installationtoken"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("the cassette contains %q: %s", secret, data)
+		}
+	}
+	if !strings.Contains(string(data), `"authorization":"REDACTED"`) {
+		t.Errorf("the cassette does not record the redacted credentials: %s", data)
+	}
+
+	// Requests of a replayed run are redacted the same way
+	replay, err := newReplayTransport(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/installation?access="+token, nil)
+	if _, err := replay.RoundTrip(req); err != nil {
+		t.Errorf("unable to replay the recorded request: %v", err)
+	}
+}
+
+type tokenTransport struct {
+	token string
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return http.DefaultTransport.RoundTrip(req)
+}
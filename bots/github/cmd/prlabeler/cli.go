@@ -0,0 +1,209 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"k8s.io/klog/v2"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+var (
+	// Output format of all the commands
+	outputFormat = outputText
+	verbosity    int
+)
+
+// addGlobalFlags adds flags shared by all the commands.
+func addGlobalFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&configFilename, "config", configFilename, "Path to the prlabeler configuration file")
+	fs.StringVarP(&outputFormat, "output", "o", outputFormat, "Output format, text or json")
+	fs.IntVarP(&verbosity, "verbosity", "v", verbosity, "Log verbosity, 2 logs every status and test to retry")
+}
+
+// applyGlobalFlags validates the global flags and sets the log verbosity.
+func applyGlobalFlags() {
+	if outputFormat != outputText && outputFormat != outputJSON {
+		klog.Errorf("unknown output format %q, expected %v or %v", outputFormat, outputText, outputJSON)
+		os.Exit(1)
+	}
+	klogFlags := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(klogFlags)
+	if err := klogFlags.Set("v", strconv.Itoa(verbosity)); err != nil {
+		klog.Error(err)
+		os.Exit(1)
+	}
+}
+
+// writeOutput prints the value as JSON, or as text through the printer.
+func writeOutput(value any, printText func(io.Writer)) {
+	if outputFormat == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(value); err != nil {
+			klog.Error(err)
+			os.Exit(1)
+		}
+		return
+	}
+	printText(os.Stdout)
+}
+
+// command is a node of the command tree, its name may consist of several
+// words (e.g. labels sync).
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string)
+}
+
+func commandTree() []command {
+	return []command{
+		{"reconcile", "reconcile --repository ORGANIZATION/REPOSITORY [flags]", "Reconcile open PRs of the repositories once (the default without a command)", reconcileMain},
+		{"explain", "explain OWNER/REPO#NUMBER [flags]", "Print the decision trace of a PR without changing it", explainMain},
+		{"report", "report --repository ORGANIZATION/REPOSITORY [flags]", "Print the dashboard of open PRs without changing them", reportMain},
+		{"labels sync", "labels sync --config FILE --repository ORGANIZATION/REPOSITORY [flags]", "Sync labels declared in the configuration file", labelsSyncMain},
+		{"validate-config", "validate-config [FILE] [flags]", "Check the configuration file", validateConfigMain},
+		{"serve", "serve --repository ORGANIZATION/REPOSITORY [flags]", "Reconcile periodically and serve health probes", serveMain},
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: prlabeler COMMAND [flags]\n\nCommands:\n")
+	for _, cmd := range commandTree() {
+		fmt.Fprintf(w, "  %-16v %v\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nGlobal flags:\n")
+	fs := pflag.NewFlagSet("global", pflag.ContinueOnError)
+	addGlobalFlags(fs)
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	fmt.Fprintf(w, "\nRun 'prlabeler COMMAND --help' for flags of a command.\n")
+}
+
+// runCommand dispatches the arguments to a command. Arguments starting with
+// a flag reconcile, as before commands were introduced.
+func runCommand(args []string) {
+	for _, cmd := range commandTree() {
+		words := strings.Fields(cmd.name)
+		if len(args) >= len(words) && slices.Equal(args[:len(words)], words) {
+			cmd.run(args[len(words):])
+			return
+		}
+	}
+	switch {
+	case len(args) > 0 && (args[0] == "help" || args[0] == "-h" || args[0] == "--help"):
+		printUsage(os.Stdout)
+	case len(args) == 0 || strings.HasPrefix(args[0], "-"):
+		reconcileMain(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", strings.Join(args, " "))
+		printUsage(os.Stderr)
+		os.Exit(1)
+	}
+}
+
+// commandFlagSet creates flags of a command including the global ones.
+func commandFlagSet(name string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(name, pflag.ExitOnError)
+	addGlobalFlags(fs)
+	for _, cmd := range commandTree() {
+		if cmd.name == name {
+			fs.Usage = func() {
+				fmt.Fprintf(os.Stderr, "%v\n\nUsage: prlabeler %v\n", cmd.summary, cmd.usage)
+				fs.PrintDefaults()
+			}
+		}
+	}
+	return fs
+}
+
+// reconcileMain reconciles all the repositories once.
+func reconcileMain(args []string) {
+	fs := commandFlagSet("reconcile")
+	addReconcileFlags(fs)
+	fs.Parse(args)
+
+	applyGlobalFlags()
+	validateFlags()
+	applyConfig()
+
+	summary := reconcile(context.Background())
+	writeOutput(summary, summary.report)
+	if summary.failed() {
+		os.Exit(1)
+	}
+}
+
+// configValidation is the outcome of validate-config.
+type configValidation struct {
+	Config string `json:"config"`
+	Valid  bool   `json:"valid"`
+	Error  string `json:"error,omitempty"`
+}
+
+// validateConfigMain checks the configuration file given either as the
+// argument or through --config.
+func validateConfigMain(args []string) {
+	fs := commandFlagSet("validate-config")
+	fs.Parse(args)
+	applyGlobalFlags()
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if fs.NArg() == 1 {
+		configFilename = fs.Arg(0)
+	}
+	if configFilename == "" {
+		klog.Error("config is required")
+		os.Exit(1)
+	}
+
+	result := configValidation{Config: configFilename, Valid: true}
+	if _, err := loadConfig(configFilename); err != nil {
+		result.Valid, result.Error = false, err.Error()
+	} else if err := checkConfigFields(configFilename); err != nil {
+		result.Valid, result.Error = false, err.Error()
+	}
+	writeOutput(result, func(w io.Writer) {
+		if result.Valid {
+			fmt.Fprintf(w, "%v is valid\n", result.Config)
+		} else {
+			fmt.Fprintf(w, "%v is not valid: %v\n", result.Config, result.Error)
+		}
+	})
+	if !result.Valid {
+		os.Exit(1)
+	}
+}
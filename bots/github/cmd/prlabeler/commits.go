@@ -0,0 +1,104 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/v76/github"
+)
+
+func getPRCommits(ctx context.Context, client *github.Client, owner, repo string, prNum int) ([]*github.RepositoryCommit, error) {
+	allCommits := []*github.RepositoryCommit{}
+	listOpts := &github.ListOptions{PerPage: 100}
+
+	for {
+		commits, resp, err := client.PullRequests.ListCommits(ctx, owner, repo, prNum, listOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error listing commits for PR #%d: %v", prNum, err)
+		}
+
+		allCommits = append(allCommits, commits...)
+
+		if resp.NextPage == 0 {
+			break
+		}
+		listOpts.Page = resp.NextPage
+	}
+
+	return allCommits, nil
+}
+
+// commitAuthorshipViolations lists reasons why the PR can not be trusted as
+// coming from the bot only. Every commit has to be authored and committed by
+// the bot with a signature verified by GitHub, and the PR must not come from
+// a fork.
+func commitAuthorshipViolations(pr *github.PullRequest, commits []*github.RepositoryCommit, bot string) []string {
+	violations := []string{}
+
+	headRepo := pr.GetHead().GetRepo().GetFullName()
+	baseRepo := pr.GetBase().GetRepo().GetFullName()
+	if headRepo == "" || headRepo != baseRepo {
+		violations = append(violations, fmt.Sprintf("head repository %q differs from base repository %q", headRepo, baseRepo))
+	}
+
+	if len(commits) == 0 {
+		violations = append(violations, "PR has no commits")
+	}
+
+	for _, commit := range commits {
+		sha := commit.GetSHA()
+		if len(sha) > 12 {
+			sha = sha[:12]
+		}
+		if author := commit.GetAuthor().GetLogin(); author != bot {
+			violations = append(violations, fmt.Sprintf("commit %v authored by %q", sha, author))
+		}
+		if committer := commit.GetCommitter().GetLogin(); committer != bot {
+			violations = append(violations, fmt.Sprintf("commit %v committed by %q", sha, committer))
+		}
+		if verification := commit.GetCommit().GetVerification(); !verification.GetVerified() {
+			violations = append(violations, fmt.Sprintf("commit %v signature not verified (%v)", sha, verification.GetReason()))
+		}
+	}
+
+	return violations
+}
+
+// approvalLabels are the labels (and their comment counterparts) that are
+// withheld from PRs that fail the authorship check.
+var approvalLabels = map[string]bool{
+	"lgtm":     true,
+	"approved": true,
+}
+
+func withoutApprovals(labels []string, label2comments map[string]string) ([]string, map[string]string) {
+	filteredLabels := []string{}
+	for _, label := range labels {
+		if !approvalLabels[label] {
+			filteredLabels = append(filteredLabels, label)
+		}
+	}
+	filteredComments := make(map[string]string)
+	for label, comment := range label2comments {
+		if !approvalLabels[label] {
+			filteredComments[label] = comment
+		}
+	}
+	return filteredLabels, filteredComments
+}
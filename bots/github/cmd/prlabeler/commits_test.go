@@ -0,0 +1,99 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/v76/github"
+)
+
+const testBot = "dependabot[bot]"
+
+func testCommit(sha, author, committer string, verified bool, reason string) *github.RepositoryCommit {
+	return &github.RepositoryCommit{
+		SHA:       github.String(sha),
+		Author:    &github.User{Login: github.String(author)},
+		Committer: &github.User{Login: github.String(committer)},
+		Commit: &github.Commit{
+			Verification: &github.SignatureVerification{Verified: github.Bool(verified), Reason: github.String(reason)},
+		},
+	}
+}
+
+func TestCommitAuthorshipViolations(t *testing.T) {
+	repository := func(fullName string) *github.PullRequestBranch {
+		return &github.PullRequestBranch{Repo: &github.Repository{FullName: github.String(fullName)}}
+	}
+	botCommit := testCommit("0123456789abcdef", testBot, testBot, true, "valid")
+
+	tests := []struct {
+		name       string
+		head       string
+		commits    []*github.RepositoryCommit
+		violations []string
+	}{
+		{
+			name:       "bot commits only",
+			head:       "org/repo",
+			commits:    []*github.RepositoryCommit{botCommit},
+			violations: []string{},
+		},
+		{
+			name:       "no commits",
+			head:       "org/repo",
+			violations: []string{"PR has no commits"},
+		},
+		{
+			name:       "foreign author",
+			head:       "org/repo",
+			commits:    []*github.RepositoryCommit{botCommit, testCommit("fedcba9876543210", "someone", testBot, true, "valid")},
+			violations: []string{`commit fedcba987654 authored by "someone"`},
+		},
+		{
+			name:       "foreign committer",
+			head:       "org/repo",
+			commits:    []*github.RepositoryCommit{testCommit("fedcba9876543210", testBot, "web-flow", true, "valid")},
+			violations: []string{`commit fedcba987654 committed by "web-flow"`},
+		},
+		{
+			name:       "unverified signature",
+			head:       "org/repo",
+			commits:    []*github.RepositoryCommit{testCommit("fedcba9876543210", testBot, testBot, false, "unsigned")},
+			violations: []string{"commit fedcba987654 signature not verified (unsigned)"},
+		},
+		{
+			name:       "fork",
+			head:       "someone/repo",
+			commits:    []*github.RepositoryCommit{botCommit},
+			violations: []string{`head repository "someone/repo" differs from base repository "org/repo"`},
+		},
+		{
+			name:       "deleted head repository",
+			commits:    []*github.RepositoryCommit{botCommit},
+			violations: []string{`head repository "" differs from base repository "org/repo"`},
+		},
+	}
+	for _, test := range tests {
+		pr := &github.PullRequest{Base: repository("org/repo"), Head: repository(test.head)}
+		violations := commitAuthorshipViolations(pr, test.commits, testBot)
+		if !reflect.DeepEqual(violations, test.violations) {
+			t.Errorf("%v: expected %q, got %q", test.name, test.violations, violations)
+		}
+	}
+}
@@ -0,0 +1,574 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type PRLabelerConfig struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Spec       Spec   `yaml:"spec"`
+}
+
+// Spec contains the main configuration of the PR labeler.
+type Spec struct {
+	Schedule Schedule `yaml:"schedule"`
+	// Policies selected by the PR base branch, the first match wins.
+	// Replace the built-in branch policies when set.
+	BranchPolicies []BranchPolicy `yaml:"branchPolicies"`
+	// Policy for branches no branch policy matches. Replaces the built-in
+	// default policy when set.
+	DefaultBranchPolicy *BranchPolicy `yaml:"defaultBranchPolicy"`
+	// Canonical set of labels of all the managed repositories
+	Labels []LabelSpec `yaml:"labels"`
+	// Checks of images referenced by konflux reference and bundle PRs
+	ImageVerification ImageVerification `yaml:"imageVerification"`
+	// Escalation of stalled bot PRs, disabled when not set
+	Escalation *Escalation `yaml:"escalation"`
+	// Actions on bot PRs failing validation, they are only logged when not
+	// set
+	Rejection *Rejection `yaml:"rejection"`
+	// Classification of failed Prow jobs before overriding them. Replaces
+	// the built-in classification when set.
+	FailureClassification *FailureClassification `yaml:"failureClassification"`
+	// Contexts of the base branch jobs checked for PR contexts before
+	// retesting or overriding them. Replaces the built-in mapping when set.
+	BaseBranchContexts *BaseBranchContexts `yaml:"baseBranchContexts"`
+	// GitHub credentials per organization, the first match wins.
+	// Organizations no credential matches use --token-file or GITHUB_TOKEN.
+	Credentials []Credential `yaml:"credentials"`
+	// GitHub Enterprise Server instances hosting organizations or
+	// repositories, the first match wins. The rest is on github.com.
+	Servers []GitHubServer `yaml:"servers"`
+	// Rules evaluated for PRs of allowed authors in addition to the
+	// built-in ones
+	Rules []RuleSpec `yaml:"rules"`
+	// Chat and email notifications, disabled when not set
+	Notifications *Notifications `yaml:"notifications"`
+	// PR state kept between runs (retests, posted commands, approved heads
+	// and failures), prlabeler is stateless when not set
+	State *StateStore `yaml:"state"`
+}
+
+// StateStore selects where PR state is kept. State of closed PRs is
+// dropped.
+type StateStore struct {
+	// file, configmap or markers (hidden markers of a PR comment)
+	Backend string `yaml:"backend"`
+	// JSON file of the file backend
+	File      string        `yaml:"file"`
+	ConfigMap *ConfigMapRef `yaml:"configMap"`
+}
+
+// ConfigMapRef refers to a ConfigMap, created when missing.
+type ConfigMapRef struct {
+	// Namespace of the pod by default
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+}
+
+// Notifications announce prlabeler's activity and PRs that need humans.
+// Every PR is announced once per event and state (e.g. the PR head).
+type Notifications struct {
+	// File keeping announced states and queued digests across runs, they
+	// are kept in memory when not set
+	StateFile string              `yaml:"stateFile"`
+	Sinks     []NotificationSink  `yaml:"sinks"`
+	Routes    []NotificationRoute `yaml:"routes"`
+	// Time of day (schedule timezone) digests are sent at, 09:00 by default
+	DigestAt string `yaml:"digestAt"`
+	// text/template templates per event (override, approved, rejected,
+	// closed, revoked, escalated) and of the digest, replacing the built-in
+	// ones
+	Templates map[string]string `yaml:"templates"`
+}
+
+// NotificationSink is either a Slack compatible incoming webhook or an SMTP
+// server.
+type NotificationSink struct {
+	Name  string            `yaml:"name"`
+	Slack *SlackWebhook     `yaml:"slack"`
+	SMTP  *SMTPNotification `yaml:"smtp"`
+}
+
+// SlackWebhook posts messages to an incoming webhook.
+type SlackWebhook struct {
+	// File with the webhook URL, it is a secret
+	WebhookURLFile string `yaml:"webhookURLFile"`
+}
+
+// SMTPNotification sends emails, the first line of a message is the subject.
+type SMTPNotification struct {
+	// Server in the host:port form
+	Address string   `yaml:"address"`
+	From    string   `yaml:"from"`
+	To      []string `yaml:"to"`
+	// PLAIN authentication, the server is accessed anonymously when not set
+	UsernameFile string `yaml:"usernameFile"`
+	PasswordFile string `yaml:"passwordFile"`
+}
+
+// NotificationRoute sends events of repositories to sinks, either
+// immediately or in the daily digest.
+type NotificationRoute struct {
+	// Events, all when empty
+	Events []string `yaml:"events"`
+	// Repositories in the organization/repository form (globs), all when
+	// empty
+	Repositories []string `yaml:"repositories"`
+	Sinks        []string `yaml:"sinks"`
+	Digest       bool     `yaml:"digest"`
+}
+
+// RuleSpec reconciles PRs matching the authors and the title when they pass
+// the validator.
+type RuleSpec struct {
+	Name string `yaml:"name"`
+	// Authors (globs), all allowed authors when empty
+	Authors []string `yaml:"authors"`
+	// Regex the PR title has to match, any title when empty
+	Title     string        `yaml:"title"`
+	Validator ValidatorSpec `yaml:"validator"`
+}
+
+// ValidatorSpec declares exactly one validator.
+type ValidatorSpec struct {
+	// Pass when all, respectively any, of the validators pass
+	All []ValidatorSpec `yaml:"all"`
+	Any []ValidatorSpec `yaml:"any"`
+	// Changed files have to match an allowed glob and no denied one
+	Files *FileGlobs `yaml:"files"`
+	// Upper bounds of changed files and changed (added and deleted) lines
+	MaxFiles int `yaml:"maxFiles"`
+	MaxLines int `yaml:"maxLines"`
+	// Changed lines may differ in sha256 image digests only
+	OnlyDigests bool `yaml:"onlyDigests"`
+	// Changed lines have to be Tekton task bundle references pinned by a
+	// digest
+	TektonBundleRefs bool `yaml:"tektonBundleRefs"`
+	// Commits have to be authored and pushed by the PR author
+	CommitAuthorship bool `yaml:"commitAuthorship"`
+	// Contexts that have to succeed on the PR head
+	RequiredStatuses []string `yaml:"requiredStatuses"`
+}
+
+// FileGlobs allows and denies files, a trailing /** matches a whole
+// directory.
+type FileGlobs struct {
+	Allowed []string `yaml:"allowed"`
+	Denied  []string `yaml:"denied"`
+}
+
+// GitHubServer is a GitHub API other than api.github.com, e.g. a GitHub
+// Enterprise Server instance or a test server.
+type GitHubServer struct {
+	// Organizations (globs)
+	Organizations []string `yaml:"organizations"`
+	// Repositories in the organization/repository form (globs)
+	Repositories []string `yaml:"repositories"`
+	// REST API root, e.g. https://github.example.com/api/v3/
+	BaseURL string `yaml:"baseURL"`
+	// Upload API root, the base URL by default
+	UploadURL string `yaml:"uploadURL"`
+	// PEM bundle of CAs trusted in addition to the system ones
+	CAFile string `yaml:"caFile"`
+}
+
+// Credential is either a token file or a GitHub App installation.
+type Credential struct {
+	// Organizations (globs), all when empty
+	Organizations []string `yaml:"organizations"`
+	// File with a token, read again whenever it changes
+	TokenFile string     `yaml:"tokenFile"`
+	App       *GitHubApp `yaml:"app"`
+}
+
+// GitHubApp authenticates as an installation of a GitHub App. Installation
+// tokens are renewed before they expire.
+type GitHubApp struct {
+	AppID int64 `yaml:"appID"`
+	// Looked up by the organization when not set
+	InstallationID int64  `yaml:"installationID"`
+	PrivateKeyFile string `yaml:"privateKeyFile"`
+}
+
+// FailureClassification classifies failed Prow jobs from their artifacts.
+// Only infrastructure failures and failures of known flaky tests are
+// overridden.
+type FailureClassification struct {
+	// Contexts classified before being overridden
+	Contexts []string `yaml:"contexts"`
+	// Rewrites of status target URLs to job artifact locations
+	ArtifactURLRewrites []URLRewrite `yaml:"artifactURLRewrites"`
+	// JUnit files relative to the job artifacts, {test} stands for the
+	// context without the ci/prow/ prefix. The built-in ones are used when
+	// empty.
+	JUnitPaths []string `yaml:"junitPaths"`
+	// Regexes of build log lines caused by the infrastructure (cluster
+	// install, quota, image pull)
+	InfrastructurePatterns []string `yaml:"infrastructurePatterns"`
+	// Regexes of known flaky test names
+	FlakyTests []string `yaml:"flakyTests"`
+}
+
+// BaseBranchContexts maps PR contexts to contexts of the jobs running on the
+// base branch, which are usually named differently (e.g. Konflux
+// *-on-pull-request and *-on-push pipelines). PR contexts neither mapped nor
+// rewritten are looked up under the same name.
+type BaseBranchContexts struct {
+	// Base branch context per PR context
+	Contexts map[string]string `yaml:"contexts"`
+	// Rewrites of the other PR contexts, the first matching one applies
+	Rewrites []ContextRewrite `yaml:"rewrites"`
+}
+
+// ContextRewrite renames contexts matching a regex, the replacement can
+// refer to its groups (${1}).
+type ContextRewrite struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+// URLRewrite replaces a URL prefix.
+type URLRewrite struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// Rejection acts on bot PRs matching a rule but failing its validation.
+// Every action is taken once per PR.
+type Rejection struct {
+	// Post a single comment listing the offending files and the reasons,
+	// kept up to date as the PR changes
+	Comment bool `yaml:"comment"`
+	// Label added to rejected PRs, e.g. needs-human-review
+	Label string `yaml:"label"`
+	// Close PRs rejected for longer, measured from the first rejection as
+	// recorded in the PR state or the rejection comment. Zero never closes.
+	CloseAfter time.Duration `yaml:"closeAfter"`
+}
+
+// Escalation escalates bot PRs that have not been green (all contexts
+// succeeded) for a long time, or since they were opened. Stages fire in
+// order, each only once. Stages with a zero threshold are disabled.
+type Escalation struct {
+	// Label added in the first stage (needs-attention by default)
+	Label      string        `yaml:"label"`
+	LabelAfter time.Duration `yaml:"labelAfter"`
+	// Review is requested from the approvers of the root OWNERS file
+	ReviewAfter time.Duration `yaml:"reviewAfter"`
+	// A tracking issue summarizing the failing contexts is opened
+	IssueAfter  time.Duration `yaml:"issueAfter"`
+	IssueLabels []string      `yaml:"issueLabels"`
+}
+
+// ImageVerification configures checks of image digests newly referenced by
+// PRs. Approval is refused when an image does not exist or is not signed.
+type ImageVerification struct {
+	Enabled bool `yaml:"enabled"`
+	// Require a cosign signature (the sha256-<digest>.sig tag) of every image
+	RequireSignature bool `yaml:"requireSignature"`
+	// Registry credentials, registries not listed are accessed anonymously
+	Registries []RegistryAuth `yaml:"registries"`
+}
+
+// RegistryAuth configures access to a single registry.
+type RegistryAuth struct {
+	// Registry host, e.g. quay.io or localhost:5000
+	Host string `yaml:"host"`
+	// File with a bearer token sent with every request
+	TokenFile string `yaml:"tokenFile"`
+	// Credentials used with basic auth or to obtain a token from the
+	// registry token service
+	Username     string `yaml:"username"`
+	PasswordFile string `yaml:"passwordFile"`
+	// Use plain HTTP (e.g. a local registry)
+	Insecure bool `yaml:"insecure"`
+}
+
+// LabelSpec declares a repository label.
+type LabelSpec struct {
+	Name string `yaml:"name"`
+	// Hex RGB color without the leading #
+	Color       string `yaml:"color"`
+	Description string `yaml:"description"`
+}
+
+// BranchPolicy defines how PRs against a class of base branches are reconciled.
+type BranchPolicy struct {
+	Name string `yaml:"name"`
+	// Base branches (globs, e.g. release-4.*)
+	Branches []string `yaml:"branches"`
+	// Labels added directly
+	Labels []string `yaml:"labels"`
+	// Labels produced through comment commands (label -> comment)
+	CommentLabels map[string]string `yaml:"commentLabels"`
+	// Contexts that can be overridden when failing
+	OverridableContexts []string `yaml:"overridableContexts"`
+	// Contexts that have to succeed before the PR is approved. They are
+	// never overridden.
+	RequiredContexts []string `yaml:"requiredContexts"`
+}
+
+// Schedule restricts when prlabeler acts on PRs.
+type Schedule struct {
+	// IANA time zone the dates and hours are interpreted in (UTC by default)
+	Timezone string `yaml:"timezone"`
+	// Windows during which PRs are only reported and never approved
+	Freezes []FreezeWindow `yaml:"freezes"`
+	// Hours during which comment generating actions (e.g. retests) are allowed
+	ActiveHours *ActiveHours `yaml:"activeHours"`
+}
+
+// FreezeWindow is a named change freeze.
+type FreezeWindow struct {
+	Name string `yaml:"name"`
+	// Repositories in the organization/repository form (globs), all when empty
+	Repositories []string `yaml:"repositories"`
+	// Base branches (globs), all when empty
+	Branches []string `yaml:"branches"`
+	// Either dates (2006-01-02, the end date inclusive) or RFC3339 times
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	// One of weekly, monthly or yearly. Empty for a one time window.
+	Recurrence string `yaml:"recurrence"`
+}
+
+// ActiveHours is a daily time range, e.g. 08:00-18:00.
+type ActiveHours struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	// Weekdays (Mon, Tue, ...), all days when empty
+	Days []string `yaml:"days"`
+}
+
+var labelColor = regexp.MustCompile(`^[0-9a-fA-F]{6}$`)
+
+// config is the configuration of the current run. Defaults apply when no
+// configuration file is provided.
+var config = &PRLabelerConfig{}
+
+func loadConfig(filename string) (*PRLabelerConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &PRLabelerConfig{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("unable to parse %v: %v", filename, err)
+	}
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration %v: %v", filename, err)
+	}
+	return cfg, nil
+}
+
+// checkConfigFields reports fields of the configuration file prlabeler does
+// not know, e.g. typos that loadConfig silently ignores.
+func checkConfigFields(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&PRLabelerConfig{}); err != nil && err != io.EOF {
+		return fmt.Errorf("unable to parse %v: %v", filename, err)
+	}
+	return nil
+}
+
+func validateConfig(cfg *PRLabelerConfig) error {
+	if _, err := cfg.Spec.Schedule.location(); err != nil {
+		return fmt.Errorf("unknown timezone %q: %v", cfg.Spec.Schedule.Timezone, err)
+	}
+	for _, freeze := range cfg.Spec.Schedule.Freezes {
+		if freeze.Name == "" {
+			return fmt.Errorf("freeze window name has to be specified")
+		}
+		if _, _, err := freeze.bounds(time.UTC); err != nil {
+			return fmt.Errorf("freeze window %q: %v", freeze.Name, err)
+		}
+		switch freeze.Recurrence {
+		case "", recurrenceWeekly, recurrenceMonthly, recurrenceYearly:
+		default:
+			return fmt.Errorf("freeze window %q: unknown recurrence %q", freeze.Name, freeze.Recurrence)
+		}
+	}
+	for _, policy := range cfg.Spec.BranchPolicies {
+		if policy.Name == "" {
+			return fmt.Errorf("branch policy name has to be specified")
+		}
+		if len(policy.Branches) == 0 {
+			return fmt.Errorf("branch policy %q: at least one branch has to be specified", policy.Name)
+		}
+		for _, branch := range policy.Branches {
+			if _, err := path.Match(branch, ""); err != nil {
+				return fmt.Errorf("branch policy %q: invalid branch pattern %q", policy.Name, branch)
+			}
+		}
+	}
+	labels := make(map[string]bool)
+	for _, label := range cfg.Spec.Labels {
+		if label.Name == "" {
+			return fmt.Errorf("label name has to be specified")
+		}
+		if labels[strings.ToLower(label.Name)] {
+			return fmt.Errorf("label %q declared more than once", label.Name)
+		}
+		labels[strings.ToLower(label.Name)] = true
+		if !labelColor.MatchString(label.Color) {
+			return fmt.Errorf("label %q: color %q is not a 6 digit hex RGB", label.Name, label.Color)
+		}
+	}
+	if escalation := cfg.Spec.Escalation; escalation != nil {
+		if escalation.LabelAfter < 0 || escalation.ReviewAfter < 0 || escalation.IssueAfter < 0 {
+			return fmt.Errorf("escalation thresholds can not be negative")
+		}
+	}
+	if rejection := cfg.Spec.Rejection; rejection != nil {
+		if rejection.CloseAfter < 0 {
+			return fmt.Errorf("rejection: closeAfter can not be negative")
+		}
+		if rejection.CloseAfter > 0 && !rejection.Comment && cfg.Spec.State == nil {
+			return fmt.Errorf("rejection: closeAfter requires comment or state, the rejection is timed from either")
+		}
+	}
+	if classification := cfg.Spec.FailureClassification; classification != nil {
+		for _, pattern := range slices.Concat(classification.InfrastructurePatterns, classification.FlakyTests) {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("failure classification: invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	if contexts := cfg.Spec.BaseBranchContexts; contexts != nil {
+		for _, rewrite := range contexts.Rewrites {
+			if _, err := regexp.Compile(rewrite.Pattern); err != nil {
+				return fmt.Errorf("base branch contexts: invalid pattern %q: %v", rewrite.Pattern, err)
+			}
+		}
+	}
+	for _, registry := range cfg.Spec.ImageVerification.Registries {
+		if registry.Host == "" {
+			return fmt.Errorf("registry host has to be specified")
+		}
+		if registry.TokenFile != "" && registry.Username != "" {
+			return fmt.Errorf("registry %q: tokenFile and username are mutually exclusive", registry.Host)
+		}
+		if (registry.Username == "") != (registry.PasswordFile == "") {
+			return fmt.Errorf("registry %q: username and passwordFile have to be specified together", registry.Host)
+		}
+	}
+	for i, credential := range cfg.Spec.Credentials {
+		if (credential.TokenFile == "") == (credential.App == nil) {
+			return fmt.Errorf("credential #%v: exactly one of tokenFile and app has to be specified", i+1)
+		}
+		if app := credential.App; app != nil && (app.AppID == 0 || app.PrivateKeyFile == "") {
+			return fmt.Errorf("credential #%v: app requires appID and privateKeyFile", i+1)
+		}
+		for _, organization := range credential.Organizations {
+			if _, err := path.Match(organization, ""); err != nil {
+				return fmt.Errorf("credential #%v: invalid organization pattern %q", i+1, organization)
+			}
+		}
+	}
+	for _, server := range cfg.Spec.Servers {
+		if len(server.Organizations) == 0 && len(server.Repositories) == 0 {
+			return fmt.Errorf("server %q: at least one organization or repository has to be specified", server.BaseURL)
+		}
+		apiURLs := []string{server.BaseURL}
+		if server.UploadURL != "" {
+			apiURLs = append(apiURLs, server.UploadURL)
+		}
+		for _, apiURL := range apiURLs {
+			if parsed, err := url.Parse(apiURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return fmt.Errorf("server %q: %q is not an http(s) URL", server.BaseURL, apiURL)
+			}
+		}
+		for _, pattern := range slices.Concat(server.Organizations, server.Repositories) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("server %q: invalid pattern %q", server.BaseURL, pattern)
+			}
+		}
+	}
+	if notifications := cfg.Spec.Notifications; notifications != nil {
+		if err := validateNotifications(notifications); err != nil {
+			return fmt.Errorf("notifications: %v", err)
+		}
+	}
+	if state := cfg.Spec.State; state != nil {
+		switch state.Backend {
+		case stateBackendFile:
+			if state.File == "" {
+				return fmt.Errorf("state: the file backend requires file")
+			}
+		case stateBackendConfigMap:
+			if state.ConfigMap == nil || state.ConfigMap.Name == "" {
+				return fmt.Errorf("state: the configmap backend requires configMap.name")
+			}
+		case stateBackendMarkers:
+		default:
+			return fmt.Errorf("state: unknown backend %q, expected one of %v, %v, %v", state.Backend, stateBackendFile, stateBackendConfigMap, stateBackendMarkers)
+		}
+	}
+	names := make(map[string]bool)
+	for _, rule := range cfg.Spec.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule name has to be specified")
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule %q is defined more than once", rule.Name)
+		}
+		names[rule.Name] = true
+		for _, author := range rule.Authors {
+			if _, err := path.Match(author, ""); err != nil {
+				return fmt.Errorf("rule %q: invalid author pattern %q", rule.Name, author)
+			}
+		}
+		if _, err := regexp.Compile(rule.Title); err != nil {
+			return fmt.Errorf("rule %q: invalid title %q: %v", rule.Name, rule.Title, err)
+		}
+		if _, err := rule.Validator.build(); err != nil {
+			return fmt.Errorf("rule %q: %v", rule.Name, err)
+		}
+	}
+	if hours := cfg.Spec.Schedule.ActiveHours; hours != nil {
+		if _, _, err := hours.bounds(); err != nil {
+			return fmt.Errorf("active hours: %v", err)
+		}
+		for _, day := range hours.Days {
+			if _, exists := weekdays[day]; !exists {
+				return fmt.Errorf("active hours: unknown day %q", day)
+			}
+		}
+	}
+	return nil
+}
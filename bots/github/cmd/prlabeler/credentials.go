@@ -0,0 +1,295 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v76/github"
+	"golang.org/x/oauth2"
+	"k8s.io/klog/v2"
+)
+
+// fileTokenSource reads a token from a file and reads it again whenever the
+// file changes (e.g. a rotated Kubernetes secret).
+type fileTokenSource struct {
+	filename string
+
+	mutex   sync.Mutex
+	modTime time.Time
+	token   *oauth2.Token
+}
+
+func (s *fileTokenSource) Token() (*oauth2.Token, error) {
+	info, err := os.Stat(s.filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token: %v", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.token != nil && info.ModTime().Equal(s.modTime) {
+		return s.token, nil
+	}
+	token, err := readSecret(s.filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token: %v", err)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("token file %v is empty", s.filename)
+	}
+	if s.token != nil {
+		klog.InfoS("Token file changed, using the new token", "file", s.filename)
+	}
+	s.token = &oauth2.Token{AccessToken: token}
+	s.modTime = info.ModTime()
+	return s.token, nil
+}
+
+func base64URL(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// appJWT creates the JWT a GitHub App authenticates with.
+func appJWT(appID int64, privateKeyFile string, now time.Time) (string, error) {
+	data, err := os.ReadFile(privateKeyFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the private key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", fmt.Errorf("private key %v is not PEM encoded", privateKeyFile)
+	}
+	var key *rsa.PrivateKey
+	if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return "", fmt.Errorf("unable to parse the private key %v: %v", privateKeyFile, err)
+		}
+		var ok bool
+		if key, ok = parsed.(*rsa.PrivateKey); !ok {
+			return "", fmt.Errorf("private key %v is not an RSA key", privateKeyFile)
+		}
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		// Allow for clock drift
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": fmt.Sprint(appID),
+	})
+	unsigned := base64URL(header) + "." + base64URL(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64URL(signature), nil
+}
+
+// appTransport authenticates requests as a GitHub App.
+type appTransport struct {
+	base http.RoundTripper
+	app  *GitHubApp
+}
+
+func (t *appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	jwt, err := appJWT(t.app.AppID, t.app.PrivateKeyFile, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	return t.base.RoundTrip(req)
+}
+
+// installationTokenSource creates installation tokens of a GitHub App.
+type installationTokenSource struct {
+	ctx            context.Context
+	app            *github.Client
+	installationID int64
+}
+
+func (s *installationTokenSource) Token() (*oauth2.Token, error) {
+	token, _, err := s.app.Apps.CreateInstallationToken(s.ctx, s.installationID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating a token of installation %v: %v", s.installationID, err)
+	}
+	// Renew the token before it expires
+	return &oauth2.Token{AccessToken: token.GetToken(), Expiry: token.GetExpiresAt().Add(-5 * time.Minute)}, nil
+}
+
+// credentialFor returns the first credential covering the organization.
+func credentialFor(cfg *PRLabelerConfig, organization string) *Credential {
+	for i, credential := range cfg.Spec.Credentials {
+		if len(credential.Organizations) == 0 || matchAnyGlob(credential.Organizations, organization) {
+			return &cfg.Spec.Credentials[i]
+		}
+	}
+	return nil
+}
+
+// appTokenSource returns a token source of the App installation in the
+// organization and the App's bot login.
+func appTokenSource(ctx context.Context, app *GitHubApp, organization string, server *GitHubServer, base http.RoundTripper) (oauth2.TokenSource, string, error) {
+	appClient, err := server.newClient(&http.Client{Transport: &appTransport{base: base, app: app}})
+	if err != nil {
+		return nil, "", err
+	}
+	installationID := app.InstallationID
+	if installationID == 0 {
+		installation, _, err := appClient.Apps.FindOrganizationInstallation(ctx, organization)
+		if err != nil {
+			return nil, "", fmt.Errorf("error looking up installation of app %v in %v: %v", app.AppID, organization, err)
+		}
+		installationID = installation.GetID()
+	}
+	appInfo, _, err := appClient.Apps.Get(ctx, "")
+	if err != nil {
+		return nil, "", fmt.Errorf("error getting app %v: %v", app.AppID, err)
+	}
+	source := &installationTokenSource{ctx: ctx, app: appClient, installationID: installationID}
+	return oauth2.ReuseTokenSource(nil, source), appInfo.GetSlug() + "[bot]", nil
+}
+
+// tokenSource returns the token source of the organization: a configured
+// credential, the --token-file flag or the GITHUB_TOKEN environment variable.
+// The bot login is returned for GitHub Apps.
+func tokenSource(ctx context.Context, organization string, server *GitHubServer, base http.RoundTripper) (oauth2.TokenSource, string, error) {
+	credential := credentialFor(config, organization)
+	switch {
+	case credential != nil && credential.App != nil:
+		return appTokenSource(ctx, credential.App, organization, server, base)
+	case credential != nil:
+		return &fileTokenSource{filename: credential.TokenFile}, "", nil
+	case tokenFilename != "":
+		return &fileTokenSource{filename: tokenFilename}, "", nil
+	}
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" {
+		return nil, "", fmt.Errorf("no credentials for %v: neither a credential, --token-file nor the GITHUB_TOKEN environment variable is set", organization)
+	}
+	return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}), "", nil
+}
+
+// Clients per organization and server
+var githubClients = make(map[string]*github.Client)
+
+// newGitHubClient returns the client of the repository, using the server
+// hosting it and the credentials of its organization.
+func newGitHubClient(ctx context.Context, organization, repository string) (*github.Client, error) {
+	server := serverFor(config, organization, repository)
+	key := organization + "@" + server.apiURL()
+	if client, exists := githubClients[key]; exists {
+		return client, nil
+	}
+
+	var transport http.RoundTripper
+	login := ""
+	// Replayed runs are offline and need no credentials
+	if replayer == nil {
+		base, err := server.transport()
+		if err != nil {
+			return nil, err
+		}
+		var source oauth2.TokenSource
+		if source, login, err = tokenSource(ctx, organization, server, base); err != nil {
+			return nil, err
+		}
+		// The token is requested for every request so rotated tokens are picked up
+		transport = &retryTransport{base: &oauth2.Transport{Source: source, Base: base}}
+	}
+	client, err := server.newClient(&http.Client{Transport: cassetteTransport(transport)})
+	if err != nil {
+		return nil, err
+	}
+	if login != "" {
+		botLogins[client] = login
+	}
+	githubClients[key] = client
+	return client, nil
+}
+
+// writePermissions are repository permissions sufficient for labeling and
+// commenting.
+var writePermissions = []string{"admin", "maintain", "push", "triage"}
+
+// checkRepositoryAccess checks the token of every repository can label and
+// comment and returns the repositories that can be processed. The others are
+// reported in the summary.
+func checkRepositoryAccess(ctx context.Context, summary *runSummary) []string {
+	accessible := []string{}
+	checkedScopes := make(map[*github.Client]bool)
+	for _, repo := range repositories {
+		organization, repository, _ := strings.Cut(repo, "/")
+		client, err := newGitHubClient(ctx, organization, repository)
+		if err != nil {
+			summary.add(repo, 0, err)
+			continue
+		}
+		info, resp, err := client.Repositories.Get(ctx, organization, repository)
+		if err != nil {
+			summary.add(repo, 0, fmt.Errorf("error checking access to %v: %v", repo, err))
+			continue
+		}
+
+		if !checkedScopes[client] {
+			checkedScopes[client] = true
+			// Only classic personal access tokens report their scopes
+			if header := resp.Header.Get("X-OAuth-Scopes"); header != "" {
+				scopes := strings.Split(strings.ReplaceAll(header, " ", ""), ",")
+				klog.InfoS("Token scopes", "organization", organization, "scopes", scopes)
+				if !slices.Contains(scopes, "repo") && !slices.Contains(scopes, "public_repo") {
+					klog.Warningf("Token of %v has neither the repo nor the public_repo scope", organization)
+				}
+			}
+		}
+
+		permissions := info.GetPermissions()
+		if permissions == nil {
+			klog.InfoS("Unable to determine permissions, assuming write access", "repository", repo)
+			accessible = append(accessible, repo)
+			continue
+		}
+		writable := false
+		for _, permission := range writePermissions {
+			writable = writable || permissions[permission]
+		}
+		if !writable {
+			summary.add(repo, 0, fmt.Errorf("token can not write to %v", repo))
+			continue
+		}
+		accessible = append(accessible, repo)
+	}
+	return accessible
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v76/github"
+	"k8s.io/klog/v2"
+)
+
+const (
+	retryAttempts = 4
+	retryBackoff  = 2 * time.Second
+	// Longest Retry-After a request waits for before giving up
+	retryMaxWait = time.Minute
+)
+
+// runFailure is a failure of a repository (PR is 0) or of a single PR.
+type runFailure struct {
+	Repository string
+	PR         int
+	Err        error
+}
+
+func (f runFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Repository string `json:"repository"`
+		PR         int    `json:"pr,omitempty"`
+		Error      string `json:"error"`
+	}{f.Repository, f.PR, f.Err.Error()})
+}
+
+// runSummary collects failures of a run so a single failure does not stop
+// processing of the remaining repositories and PRs.
+type runSummary struct {
+	Failures []runFailure `json:"failures"`
+}
+
+func (s *runSummary) add(repository string, pr int, err error) {
+	if err == nil {
+		return
+	}
+	klog.ErrorS(err, "Failure", "repository", repository, "number", pr)
+	s.Failures = append(s.Failures, runFailure{Repository: repository, PR: pr, Err: err})
+}
+
+func (s *runSummary) failed() bool {
+	return len(s.Failures) > 0
+}
+
+func (s *runSummary) report(w io.Writer) {
+	if !s.failed() {
+		fmt.Fprintf(w, "Run finished without failures\n")
+		return
+	}
+	fmt.Fprintf(w, "Run finished with %v failure(s):\n", len(s.Failures))
+	for _, failure := range s.Failures {
+		if failure.PR == 0 {
+			fmt.Fprintf(w, "  %v: %v\n", failure.Repository, failure.Err)
+		} else {
+			fmt.Fprintf(w, "  %v#%v: %v\n", failure.Repository, failure.PR, failure.Err)
+		}
+	}
+}
+
+// retryTransport retries idempotent requests failing with a transient error
+// (network errors, 5xx, secondary rate limits) with an exponential back-off.
+// Non-idempotent requests (e.g. posting comments) are never retried.
+type retryTransport struct {
+	base http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.base.RoundTrip(req)
+	}
+
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt == retryAttempts || !retryable(resp, err) {
+			return resp, err
+		}
+
+		wait := backoff
+		if resp != nil {
+			if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+				if seconds, err := strconv.Atoi(retryAfter); err == nil {
+					wait = time.Duration(seconds) * time.Second
+				}
+			}
+			resp.Body.Close()
+		}
+		if wait > retryMaxWait {
+			return nil, fmt.Errorf("%v %v: retry after %v exceeds %v", req.Method, req.URL, wait, retryMaxWait)
+		}
+		klog.V(2).InfoS("Retrying request", "method", req.Method, "url", req.URL.String(), "attempt", attempt, "wait", wait, "err", err)
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout, http.StatusInternalServerError:
+		return true
+	case http.StatusForbidden:
+		// Secondary rate limits come with a Retry-After header
+		return resp.Header.Get("Retry-After") != ""
+	}
+	return false
+}
+
+// transient tells whether a GitHub client error is worth retrying.
+func transient(err error) bool {
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) {
+		return errResp.Response != nil && retryable(errResp.Response, nil)
+	}
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		return true
+	}
+	return retryable(nil, err)
+}
+
+// withRetry retries an idempotent operation that is not covered by
+// retryTransport (e.g. GraphQL queries sent as POST).
+func withRetry(ctx context.Context, operation func() error) error {
+	backoff := retryBackoff
+	var err error
+	for attempt := 1; attempt <= retryAttempts; attempt++ {
+		if err = operation(); err == nil {
+			return nil
+		}
+		if attempt == retryAttempts || !transient(err) {
+			break
+		}
+		klog.V(2).InfoS("Retrying operation", "attempt", attempt, "wait", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return err
+}
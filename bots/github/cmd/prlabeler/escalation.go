@@ -0,0 +1,216 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v76/github"
+	"k8s.io/klog/v2"
+)
+
+const (
+	escalationStageLabel  = "label"
+	escalationStageReview = "review"
+	escalationStageIssue  = "issue"
+
+	defaultEscalationLabel = "needs-attention"
+)
+
+// escalationMarker marks the PR comment recording a fired stage.
+func escalationMarker(stage string) string {
+	return fmt.Sprintf("<!-- prlabeler escalation: %v -->", stage)
+}
+
+// stalledSince replays the status history and returns when the PR was last
+// green (all gating contexts of the policy succeeded), the creation time when
+// it never was, and gating contexts that are not green at the moment. No
+// contexts are returned for green PRs.
+func stalledSince(statuses []*github.RepoStatus, created time.Time, policy *BranchPolicy) (time.Time, []string) {
+	history := slices.DeleteFunc(slices.Clone(statuses), func(status *github.RepoStatus) bool {
+		return !policy.gating(status.GetContext())
+	})
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].GetUpdatedAt().Time.Before(history[j].GetUpdatedAt().Time)
+	})
+
+	since := created
+	states := make(map[string]string)
+	for _, status := range history {
+		states[status.GetContext()] = status.GetState()
+		green := true
+		for _, state := range states {
+			if state != "success" {
+				green = false
+				break
+			}
+		}
+		if green && status.GetUpdatedAt().Time.After(since) {
+			since = status.GetUpdatedAt().Time
+		}
+	}
+
+	notGreen := []string{}
+	for context, state := range states {
+		if state != "success" {
+			notGreen = append(notGreen, fmt.Sprintf("%v (%v)", context, state))
+		}
+	}
+	sort.Strings(notGreen)
+	return since, notGreen
+}
+
+// firedEscalationStages lists stages recorded in the PR comments.
+func firedEscalationStages(comments []*github.IssueComment) map[string]bool {
+	fired := make(map[string]bool)
+	for _, comment := range comments {
+		for _, stage := range []string{escalationStageLabel, escalationStageReview, escalationStageIssue} {
+			if strings.Contains(comment.GetBody(), escalationMarker(stage)) {
+				fired[stage] = true
+			}
+		}
+	}
+	return fired
+}
+
+func recordEscalation(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest, stage, message string, notGreen []string) error {
+	prNum := pr.GetNumber()
+	klog.InfoS("Escalating PR", "number", prNum, "stage", stage)
+	comment := &github.IssueComment{
+		Body: github.String(message + "\n\n" + escalationMarker(stage)),
+	}
+	if _, _, err := client.Issues.CreateComment(ctx, owner, repo, prNum, comment); err != nil {
+		return err
+	}
+	notifications.notify(eventEscalated, pr, stage, notGreen...)
+	return updatePRState(ctx, client, pr, func(state *prState) {
+		if !slices.Contains(state.Escalations, stage) {
+			state.Escalations = append(state.Escalations, stage)
+		}
+	})
+}
+
+// escalatePR escalates a PR that has not been green for longer than the
+// configured thresholds. Every stage fires only once, the fired stages are
+// recorded in PR comments and the PR state.
+func escalatePR(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest) error {
+	cfg := config.Spec.Escalation
+	if cfg == nil {
+		return nil
+	}
+	prNum := pr.GetNumber()
+
+	// The whole history is needed to tell when the PR was last green, the
+	// prefetched statuses hold the latest status of each context only
+	statuses, err := listStatuses(ctx, client, owner, repo, pr.GetHead().GetSHA())
+	if err != nil {
+		return fmt.Errorf("Could not list the status history: %v", err)
+	}
+	since, notGreen := stalledSince(statuses, pr.GetCreatedAt().Time, branchPolicy(config, pr.GetBase().GetRef()))
+	if len(notGreen) == 0 {
+		return nil
+	}
+	stalled := runClock.Now().Sub(since).Round(time.Hour)
+	age := runClock.Now().Sub(pr.GetCreatedAt().Time).Round(time.Hour)
+	klog.InfoS("PR is not green", "number", prNum, "age", age, "notGreenFor", stalled, "contexts", notGreen)
+
+	// Fired stages are kept in the PR state and marked in the PR comments,
+	// stages fired before the state backend was configured are only marked
+	state, err := loadPRState(ctx, client, pr)
+	if err != nil {
+		return err
+	}
+	comments, err := getPRComments(ctx, client, owner, repo, prNum)
+	if err != nil {
+		return err
+	}
+	fired := firedEscalationStages(comments)
+	if state != nil {
+		for _, stage := range state.Escalations {
+			fired[stage] = true
+		}
+	}
+	due := func(stage string, after time.Duration) bool {
+		return after > 0 && stalled >= after && !fired[stage]
+	}
+	failing := "- " + strings.Join(notGreen, "\n- ")
+
+	if due(escalationStageLabel, cfg.LabelAfter) {
+		label := cfg.Label
+		if label == "" {
+			label = defaultEscalationLabel
+		}
+		if _, _, err := client.Issues.AddLabelsToIssue(ctx, owner, repo, prNum, []string{label}); err != nil {
+			return fmt.Errorf("error adding %v label: %v", label, err)
+		}
+		message := fmt.Sprintf("This PR has not been green for %v, the following contexts are not passing:\n\n%v", stalled, failing)
+		if err := recordEscalation(ctx, client, owner, repo, pr, escalationStageLabel, message, notGreen); err != nil {
+			return err
+		}
+	}
+
+	if due(escalationStageReview, cfg.ReviewAfter) {
+		owners, err := loadRepoOwners(ctx, client, pr.GetBase().GetRepo().GetOwner().GetLogin(), pr.GetBase().GetRepo().GetName(), pr.GetBase().GetRef())
+		if err != nil {
+			return err
+		}
+		approvers, err := owners.rootApprovers(ctx)
+		if err != nil {
+			return err
+		}
+		reviewers := []string{}
+		for _, approver := range approvers {
+			if !strings.EqualFold(approver, pr.GetUser().GetLogin()) {
+				reviewers = append(reviewers, approver)
+			}
+		}
+		message := fmt.Sprintf("This PR has not been green for %v and has no OWNERS approvers to request a review from.", stalled)
+		if len(reviewers) > 0 {
+			if _, _, err := client.PullRequests.RequestReviewers(ctx, owner, repo, prNum, github.ReviewersRequest{Reviewers: reviewers}); err != nil {
+				return fmt.Errorf("error requesting review: %v", err)
+			}
+			message = fmt.Sprintf("This PR has not been green for %v, requesting review from the OWNERS approvers: @%v", stalled, strings.Join(reviewers, ", @"))
+		}
+		if err := recordEscalation(ctx, client, owner, repo, pr, escalationStageReview, message, notGreen); err != nil {
+			return err
+		}
+	}
+
+	if due(escalationStageIssue, cfg.IssueAfter) {
+		request := &github.IssueRequest{
+			Title: github.String(fmt.Sprintf("PR #%v has not been green for %v", prNum, stalled)),
+			Body:  github.String(fmt.Sprintf("%v (%v), opened %v ago, has not been green since %v. The following contexts are not passing:\n\n%v", pr.GetHTMLURL(), pr.GetTitle(), age, since.Format(time.RFC3339), failing)),
+		}
+		if len(cfg.IssueLabels) > 0 {
+			request.Labels = &cfg.IssueLabels
+		}
+		issue, _, err := client.Issues.Create(ctx, owner, repo, request)
+		if err != nil {
+			return fmt.Errorf("error opening a tracking issue: %v", err)
+		}
+		message := fmt.Sprintf("This PR has not been green for %v, opened tracking issue #%v.", stalled, issue.GetNumber())
+		if err := recordEscalation(ctx, client, owner, repo, pr, escalationStageIssue, message, notGreen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v76/github"
+)
+
+// testGitHubClient returns a client of the test server.
+func testGitHubClient(t *testing.T, handler http.Handler) *github.Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return client
+}
+
+func TestStalledSinceReadsTheWholeHistory(t *testing.T) {
+	created := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	// The PR was green on the first day, the unit job has been failing
+	// since. Newest statuses come first, the green ones on the second page.
+	pages := map[string]string{
+		"": `[{"context": "ci/prow/unit", "state": "failure", "updated_at": "2025-03-02T00:00:00Z"},
+		      {"context": "ci/prow/unit", "state": "pending", "updated_at": "2025-03-01T23:00:00Z"}]`,
+		"2": `[{"context": "ci/prow/unit", "state": "success", "updated_at": "2025-03-01T12:00:00Z"},
+		       {"context": "ci/prow/unit", "state": "pending", "updated_at": "2025-03-01T11:00:00Z"}]`,
+	}
+	client := testGitHubClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/org/repo/commits/abc123/statuses" || r.URL.Query().Get("per_page") != "100" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		page := r.URL.Query().Get("page")
+		if page == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%v%v?page=2&per_page=100>; rel="next"`, r.Host, r.URL.Path))
+		}
+		fmt.Fprint(w, pages[page])
+	}))
+
+	statuses, err := listStatuses(context.Background(), client, "org", "repo", "abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 4 {
+		t.Fatalf("expected 4 statuses over 2 pages, got %v", len(statuses))
+	}
+	since, notGreen := stalledSince(statuses, created, &builtinDefaultBranchPolicy)
+	if expected := created.Add(12 * time.Hour); !since.Equal(expected) {
+		t.Errorf("expected the PR to be stalled since %v, got %v", expected, since)
+	}
+	if len(notGreen) != 1 || notGreen[0] != "ci/prow/unit (failure)" {
+		t.Errorf("unexpected contexts that are not green: %v", notGreen)
+	}
+}
+
+func TestStalledSinceIgnoresNonGatingContexts(t *testing.T) {
+	created := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	status := func(context, state string, hour int) *github.RepoStatus {
+		return &github.RepoStatus{
+			Context:   github.String(context),
+			State:     github.String(state),
+			UpdatedAt: &github.Timestamp{Time: created.Add(time.Duration(hour) * time.Hour)},
+		}
+	}
+	// tide is pending until the PR merges, the optional job keeps failing
+	statuses := []*github.RepoStatus{
+		status("tide", "pending", 1),
+		status("ci/prow/unit", "pending", 1),
+		status("ci/prow/e2e-optional", "failure", 2),
+		status("ci/prow/unit", "success", 3),
+	}
+
+	tests := []struct {
+		name     string
+		policy   *BranchPolicy
+		since    time.Time
+		notGreen []string
+	}{
+		{
+			name:     "every context but tide",
+			policy:   &builtinDefaultBranchPolicy,
+			since:    created,
+			notGreen: []string{"ci/prow/e2e-optional (failure)"},
+		},
+		{
+			name:     "required contexts only",
+			policy:   &BranchPolicy{RequiredContexts: []string{"ci/prow/unit", "tide"}},
+			since:    created.Add(3 * time.Hour),
+			notGreen: []string{},
+		},
+	}
+	for _, test := range tests {
+		since, notGreen := stalledSince(statuses, created, test.policy)
+		if !since.Equal(test.since) || fmt.Sprint(notGreen) != fmt.Sprint(test.notGreen) {
+			t.Errorf("%v: expected stalled since %v with %v not green, got %v with %v", test.name, test.since, test.notGreen, since, notGreen)
+		}
+	}
+}
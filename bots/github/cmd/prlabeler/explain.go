@@ -0,0 +1,281 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v76/github"
+	"k8s.io/klog/v2"
+)
+
+// parsePRReference parses a PR reference in the owner/repo#number form.
+func parsePRReference(ref string) (string, string, int, error) {
+	repoRef, numberRef, found := strings.Cut(ref, "#")
+	if !found {
+		return "", "", 0, fmt.Errorf("PR reference %q is not in a 'owner/repo#number' form", ref)
+	}
+	items := strings.Split(repoRef, "/")
+	if len(items) != 2 || items[0] == "" || items[1] == "" {
+		return "", "", 0, fmt.Errorf("PR reference %q is not in a 'owner/repo#number' form", ref)
+	}
+	number, err := strconv.Atoi(numberRef)
+	if err != nil || number <= 0 {
+		return "", "", 0, fmt.Errorf("PR reference %q has an invalid number", ref)
+	}
+	return items[0], items[1], number, nil
+}
+
+// explainMain prints the decision trace for a single PR. It never mutates
+// the PR.
+func explainMain(args []string) {
+	fs := commandFlagSet("explain")
+	fs.StringSliceVar(&withAuthors, "with-author", withAuthors, "List of authors to reconcile (accepting PRs with `[auto]` string set in their summary)")
+	fs.StringSliceVar(&goModuleAllowlist, "go-module-allowlist", goModuleAllowlist, "List of go module path patterns whose bumps within the same minor version get approved")
+	fs.StringSliceVar(&botProfileNames, "bot-profile", botProfileNames, "List of dependency bot profiles to reconcile (mintmaker, renovate, dependabot)")
+	fs.StringVar(&recordFilename, "record", recordFilename, "Record all GitHub API requests and responses to a cassette file (tokens are redacted)")
+	fs.StringVar(&replayFilename, "replay", replayFilename, "Replay GitHub API responses from a cassette file instead of accessing GitHub")
+	fs.StringVar(&tokenFilename, "token-file", tokenFilename, "File with the GitHub token, read again when it changes (GITHUB_TOKEN is used when not set). Credentials of the configuration file take precedence.")
+	fs.Parse(args)
+	applyGlobalFlags()
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	organization, repository, prNum, err := parsePRReference(fs.Arg(0))
+	if err != nil {
+		klog.Error(err)
+		os.Exit(1)
+	}
+
+	validateCassetteFlags()
+	applyConfig()
+
+	ctx := context.Background()
+	if config.Spec.State != nil {
+		store, err := newStateStore(config.Spec.State)
+		if err != nil {
+			klog.Error(err)
+			os.Exit(1)
+		}
+		states = readOnlyStore{store}
+	}
+	client, err := newGitHubClient(ctx, organization, repository)
+	if err != nil {
+		klog.Error(err)
+		os.Exit(1)
+	}
+
+	pr, _, err := client.PullRequests.Get(ctx, organization, repository, prNum)
+	if err != nil {
+		klog.Errorf("Error getting PR #%d: %v", prNum, err)
+		os.Exit(1)
+	}
+
+	decision, err := evaluatePR(ctx, client, organization, repository, pr, getAllowedAuthors())
+	if err != nil {
+		klog.Error(err)
+		os.Exit(1)
+	}
+
+	statuses, err := getStatusTraces(ctx, client, organization, repository, prNum, pr, decision.Policy)
+	if err != nil {
+		klog.Error(err)
+		os.Exit(1)
+	}
+
+	writeOutput(explanation{Decision: decision, Statuses: statuses}, func(w io.Writer) {
+		printDecision(w, decision, pr, statuses)
+	})
+}
+
+// explanation is the JSON output of explain.
+type explanation struct {
+	Decision *prDecision   `json:"decision"`
+	Statuses []statusTrace `json:"statuses"`
+}
+
+func printDecision(w io.Writer, decision *prDecision, pr *github.PullRequest, statuses []statusTrace) {
+	fmt.Fprintf(w, "PR %v/%v#%v\n", decision.Organization, decision.Repository, decision.Number)
+	fmt.Fprintf(w, "  Title: %v\n", decision.Title)
+	if decision.AuthorAllowed {
+		fmt.Fprintf(w, "  Author: %v (allowed)\n", decision.Author)
+	} else {
+		fmt.Fprintf(w, "  Author: %v (not allowed, PR is skipped)\n", decision.Author)
+		return
+	}
+
+	fmt.Fprintf(w, "  Changed files (%v):\n", len(decision.Files))
+	for _, file := range decision.Files {
+		fmt.Fprintf(w, "    %v\n", file)
+	}
+	if len(decision.Files) == 0 {
+		fmt.Fprintf(w, "  No changed files, PR is skipped\n")
+		return
+	}
+
+	if metadata := decision.Metadata; metadata != nil {
+		fmt.Fprintf(w, "  Profile: %v\n", decision.Profile)
+		fmt.Fprintf(w, "    dependency: %q module: %v digest: %v new version: %q\n", metadata.Dependency, metadata.Module, metadata.Digest, metadata.NewVersion)
+		for _, update := range metadata.Updates {
+			fmt.Fprintf(w, "    update: %v type=%q update=%q %v -> %v\n", update.Package, update.Type, update.UpdateType, update.From, update.To)
+		}
+	}
+
+	fmt.Fprintf(w, "  Base branch: %v (policy %v)\n", pr.GetBase().GetRef(), decision.Policy.Name)
+	if decision.Freeze != "" {
+		fmt.Fprintf(w, "  Change freeze: %v (report only)\n", decision.Freeze)
+	}
+	if !decision.CommentsAllowed {
+		fmt.Fprintf(w, "  Outside of active hours: comments are not posted\n")
+	}
+
+	withheld := slices.Clone(decision.TrustViolations)
+	for _, context := range decision.Policy.missingRequiredContexts(statuses) {
+		withheld = append(withheld, fmt.Sprintf("required context %v has not succeeded", context))
+	}
+	if len(withheld) > 0 {
+		fmt.Fprintf(w, "  Approvals withheld:\n")
+		for _, reason := range withheld {
+			fmt.Fprintf(w, "    %v\n", reason)
+		}
+	}
+
+	if decision.ApprovedSHA != "" {
+		switch {
+		case decision.ApprovedSHA == pr.GetHead().GetSHA():
+			fmt.Fprintf(w, "  Approved head: %v (current)\n", decision.ApprovedSHA)
+		case validationFailures(decision) != nil:
+			fmt.Fprintf(w, "  Approved head: %v (stale, approvals %v are revoked)\n", decision.ApprovedSHA, grantedApprovals(pr, decision))
+		default:
+			fmt.Fprintf(w, "  Approved head: %v (stale, the PR still passes validation)\n", decision.ApprovedSHA)
+		}
+	}
+	if state := decision.State; state != nil {
+		fmt.Fprintf(w, "  State (updated %v):\n", state.UpdatedAt.Format(time.RFC3339))
+		if state.ApprovedSHA != "" {
+			fmt.Fprintf(w, "    approved head: %v (%v)\n", state.ApprovedSHA, strings.Join(state.ApprovedLabels, ", "))
+		}
+		if state.RevokedSHA != "" {
+			fmt.Fprintf(w, "    revoked head: %v\n", state.RevokedSHA)
+		}
+		if !state.RejectedSince.IsZero() {
+			fmt.Fprintf(w, "    rejected since: %v\n", state.RejectedSince.Format(time.RFC3339))
+		}
+		if len(state.Escalations) > 0 {
+			fmt.Fprintf(w, "    escalation stages fired: %v\n", strings.Join(state.Escalations, ", "))
+		}
+		contexts := []string{}
+		for context := range state.Retests {
+			contexts = append(contexts, context)
+		}
+		sort.Strings(contexts)
+		for _, context := range contexts {
+			fmt.Fprintf(w, "    retests of %v: %v\n", context, state.Retests[context])
+		}
+		for _, command := range state.Commands {
+			fmt.Fprintf(w, "    posted %q at %v on %v\n", command.Command, command.SHA, command.PostedAt.Format(time.RFC3339))
+		}
+		for _, failure := range state.Failures {
+			fmt.Fprintf(w, "    %v %v at %v on %v\n", failure.Context, failure.State, failure.SHA, failure.Time.Format(time.RFC3339))
+		}
+	}
+	if owners := decision.Owners; owners != nil {
+		fmt.Fprintf(w, "  OWNERS: %v can approve: %v, can lgtm: %v\n", owners.Bot, owners.CanApprove, owners.CanLGTM)
+	}
+
+	existingLabels := make(map[string]bool)
+	for _, label := range pr.Labels {
+		existingLabels[label.GetName()] = true
+	}
+
+	fmt.Fprintf(w, "  Rules:\n")
+	if len(decision.Rules) == 0 {
+		fmt.Fprintf(w, "    No rules defined for author %v\n", decision.Author)
+	}
+	reconciledBy := ""
+	for _, rule := range decision.Rules {
+		fmt.Fprintf(w, "    %v:\n", rule.Name)
+		if !rule.Matched {
+			fmt.Fprintf(w, "      match: [false]\n")
+			continue
+		}
+		fmt.Fprintf(w, "      match: [true]\n")
+		if rule.Validator != "" {
+			if rule.Valid {
+				fmt.Fprintf(w, "      %v: [true]\n", rule.Validator)
+			} else if rule.OffendingFile != "" {
+				fmt.Fprintf(w, "      %v: [false], offending file: %v\n", rule.Validator, rule.OffendingFile)
+			} else {
+				fmt.Fprintf(w, "      %v: [false]\n", rule.Validator)
+			}
+			for _, reason := range rule.Reasons {
+				fmt.Fprintf(w, "        %v\n", reason)
+			}
+			if !rule.Valid {
+				continue
+			}
+		}
+
+		if reconciledBy != "" {
+			fmt.Fprintf(w, "      already reconciled through %v\n", reconciledBy)
+			continue
+		}
+		reconciledBy = rule.Name
+
+		labels, comments := rule.Labels, rule.Label2Comments
+		if len(withheld) > 0 {
+			labels, comments = withoutApprovals(labels, comments)
+		}
+		comments, requests := decision.Owners.authorizedCommands(comments)
+		missing := missingPRLabels(pr, labels)
+		commentLabels := []string{}
+		for label := range comments {
+			if !existingLabels[label] {
+				commentLabels = append(commentLabels, label)
+			}
+		}
+		sort.Strings(commentLabels)
+		fmt.Fprintf(w, "      missing labels: %v\n", missing)
+		fmt.Fprintf(w, "      missing comment based labels:\n")
+		for _, label := range commentLabels {
+			fmt.Fprintf(w, "        %v: %q\n", label, comments[label])
+		}
+		for _, request := range requests {
+			fmt.Fprintf(w, "      owners request: %q\n", request)
+		}
+	}
+
+	fmt.Fprintf(w, "  Statuses:\n")
+	for _, status := range statuses {
+		fmt.Fprintf(w, "    %v: state=%v class=%v", status.Context, status.State, status.Class)
+		if status.Reason != "" {
+			fmt.Fprintf(w, " (%v)", status.Reason)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+}
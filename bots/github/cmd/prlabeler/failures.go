@@ -0,0 +1,261 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+type failureClass string
+
+const (
+	failureInfrastructure failureClass = "infrastructure failure"
+	failureFlaky          failureClass = "known flaky test"
+	failureReal           failureClass = "test failure"
+
+	// Artifacts larger than this are truncated
+	maxArtifactSize = 16 * 1024 * 1024
+)
+
+// Built-in classification of Prow jobs of OpenShift CI.
+var builtinFailureClassification = FailureClassification{
+	Contexts: []string{"ci/prow/unit", "ci/prow/e2e-aws-operator"},
+	ArtifactURLRewrites: []URLRewrite{
+		{From: "https://prow.ci.openshift.org/view/gs/", To: "https://storage.googleapis.com/"},
+	},
+	JUnitPaths: builtinJUnitPaths,
+	InfrastructurePatterns: []string{
+		`(?i)failed to (create|install|initialize|provision) (the )?cluster`,
+		`(?i)failed to acquire lease`,
+		`(?i)quota exceeded|QuotaExceeded|insufficient quota`,
+		`ErrImagePull|ImagePullBackOff|(?i)failed to pull image`,
+	},
+}
+
+// JUnit files written by the test step of the multi-stage jobs, the unit
+// tests and the operator e2e tests
+var builtinJUnitPaths = []string{
+	"artifacts/{test}/test/artifacts/junit_operator.xml",
+	"artifacts/{test}/test/artifacts/junit.xml",
+}
+
+var (
+	// Go test failures in build logs
+	goTestFailure = regexp.MustCompile(`(?m)^\s*--- FAIL: (\S+)`)
+	// Failures in the summary of Ginkgo suites, "[FAIL]" since v2, "[Fail]"
+	// before
+	ginkgoFailure = regexp.MustCompile(`(?m)^\s*\[(?:FAIL|Fail)\] (.*\S)`)
+	// Colors of Ginkgo output
+	ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+)
+
+// failureClassification returns the configured classification, the built-in
+// one when none is configured. The built-in JUnit paths are used when the
+// configured classification has none.
+func failureClassification(cfg *PRLabelerConfig) *FailureClassification {
+	classification := cfg.Spec.FailureClassification
+	if classification == nil {
+		return &builtinFailureClassification
+	}
+	if len(classification.JUnitPaths) == 0 {
+		withDefaults := *classification
+		withDefaults.JUnitPaths = builtinJUnitPaths
+		return &withDefaults
+	}
+	return classification
+}
+
+// buildLogFailures returns the failed tests listed in a build log, both go
+// test failures and failures of Ginkgo summaries.
+func buildLogFailures(buildLog []byte) []string {
+	failed := []string{}
+	for _, match := range goTestFailure.FindAllSubmatch(buildLog, -1) {
+		failed = append(failed, string(match[1]))
+	}
+	for _, match := range ginkgoFailure.FindAllSubmatch(ansiEscape.ReplaceAll(buildLog, nil), -1) {
+		failed = append(failed, string(match[1]))
+	}
+	return failed
+}
+
+// artifactsURL returns the job artifacts location of a status target URL.
+func (c *FailureClassification) artifactsURL(targetURL string) string {
+	for _, rewrite := range c.ArtifactURLRewrites {
+		if rest, found := strings.CutPrefix(targetURL, rewrite.From); found {
+			return strings.TrimSuffix(rewrite.To+rest, "/")
+		}
+	}
+	return strings.TrimSuffix(targetURL, "/")
+}
+
+type junitTestCase struct {
+	Name      string    `xml:"name,attr"`
+	Classname string    `xml:"classname,attr"`
+	Failure   *struct{} `xml:"failure"`
+	Error     *struct{} `xml:"error"`
+}
+
+// junitTestSuite covers both <testsuites> and <testsuite> roots.
+type junitTestSuite struct {
+	TestCases  []junitTestCase  `xml:"testcase"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+func (s *junitTestSuite) failedTests() []string {
+	failed := []string{}
+	for _, testCase := range s.TestCases {
+		if testCase.Failure != nil || testCase.Error != nil {
+			failed = append(failed, testCase.Name)
+		}
+	}
+	for _, suite := range s.TestSuites {
+		failed = append(failed, suite.failedTests()...)
+	}
+	return failed
+}
+
+func parseJUnitFailures(content []byte) ([]string, error) {
+	suite := &junitTestSuite{}
+	if err := xml.Unmarshal(content, suite); err != nil {
+		return nil, err
+	}
+	return suite.failedTests(), nil
+}
+
+// fetchArtifact downloads a job artifact. Missing artifacts are returned as
+// nil.
+func fetchArtifact(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(io.LimitReader(resp.Body, maxArtifactSize))
+	case http.StatusNotFound:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("GET %v: %v", url, resp.Status)
+}
+
+// classifyFailure classifies a failed job from its build log and JUnit
+// artifacts. Infrastructure failures and failures of known flaky tests only
+// can be overridden.
+func (c *FailureClassification) classifyFailure(ctx context.Context, client *http.Client, contextName, targetURL string) (failureClass, string, error) {
+	artifacts := c.artifactsURL(targetURL)
+	buildLog, err := fetchArtifact(ctx, client, artifacts+"/build-log.txt")
+	if err != nil {
+		return "", "", err
+	}
+	for _, pattern := range c.InfrastructurePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			continue
+		}
+		if match := re.Find(buildLog); match != nil {
+			return failureInfrastructure, fmt.Sprintf("build log matches %q", string(match)), nil
+		}
+	}
+
+	failed := buildLogFailures(buildLog)
+	test := strings.TrimPrefix(contextName, "ci/prow/")
+	for _, junitPath := range c.JUnitPaths {
+		junitURL := artifacts + "/" + strings.ReplaceAll(junitPath, "{test}", test)
+		content, err := fetchArtifact(ctx, client, junitURL)
+		if err != nil {
+			return "", "", err
+		}
+		if content == nil {
+			continue
+		}
+		tests, err := parseJUnitFailures(content)
+		if err != nil {
+			return "", "", fmt.Errorf("unable to parse %v: %v", junitURL, err)
+		}
+		failed = append(failed, tests...)
+	}
+	sort.Strings(failed)
+	failed = slices.Compact(failed)
+	if len(failed) == 0 {
+		return failureReal, "no failed tests found in the artifacts", nil
+	}
+
+	for _, name := range failed {
+		if !matchAnyRegex(c.FlakyTests, name) {
+			return failureReal, fmt.Sprintf("failed tests: %v", strings.Join(failed, ", ")), nil
+		}
+	}
+	return failureFlaky, fmt.Sprintf("failed tests: %v", strings.Join(failed, ", ")), nil
+}
+
+func matchAnyRegex(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := regexp.MatchString(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyFailures classifies failed jobs eligible for an override. Jobs
+// failing for real, or whose failure can not be classified, are not
+// overridden.
+func classifyFailures(ctx context.Context, traces []statusTrace) {
+	classification := failureClassification(config)
+	client := &http.Client{
+		Timeout:   time.Minute,
+		Transport: cassetteTransport(&retryTransport{base: http.DefaultTransport}),
+	}
+	for i := range traces {
+		trace := &traces[i]
+		if trace.Class != statusOverrideEligible || !slices.Contains(classification.Contexts, trace.Context) {
+			continue
+		}
+		if trace.TargetURL == "" {
+			trace.Class = statusIgnored
+			trace.Reason = "no target URL to classify the failure"
+			continue
+		}
+		class, reason, err := classification.classifyFailure(ctx, client, trace.Context, trace.TargetURL)
+		if err != nil {
+			klog.ErrorS(err, "Unable to classify the failure", "context", trace.Context, "url", trace.TargetURL)
+			trace.Class = statusIgnored
+			trace.Reason = fmt.Sprintf("unable to classify the failure: %v", err)
+			continue
+		}
+		trace.Reason = fmt.Sprintf("%v, %v", class, reason)
+		if class == failureReal {
+			trace.Class = statusIgnored
+		}
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const ginkgoBuildLog = "Running Suite: Descheduler e2e\n" +
+	"\x1b[38;5;9m• [FAILED] [61.503 seconds]\x1b[0m\n" +
+	"Summarizing 2 Failures:\n" +
+	"  \x1b[38;5;9m[FAIL]\x1b[0m [sig-scheduling] Descheduler \x1b[38;5;9m\x1b[1m[It] evicts pods violating topology spread\x1b[0m\n" +
+	"  /go/src/github.com/openshift/cluster-kube-descheduler-operator/test/e2e/e2e_test.go:212\n" +
+	"  [FAIL] [sig-scheduling] Descheduler [It] evicts duplicates\n" +
+	"Ran 12 of 12 Specs in 812.123 seconds\n"
+
+func TestBuildLogFailures(t *testing.T) {
+	buildLog := "=== RUN   TestSync\n--- FAIL: TestSync (0.01s)\n    --- FAIL: TestSync/subtest (0.00s)\n" + ginkgoBuildLog
+	expected := []string{
+		"TestSync",
+		"TestSync/subtest",
+		"[sig-scheduling] Descheduler [It] evicts pods violating topology spread",
+		"[sig-scheduling] Descheduler [It] evicts duplicates",
+	}
+	if failed := buildLogFailures([]byte(buildLog)); !reflect.DeepEqual(failed, expected) {
+		t.Errorf("expected %q, got %q", expected, failed)
+	}
+}
+
+func TestClassifyFailure(t *testing.T) {
+	// Job artifacts served over plain HTTP from a directory
+	dir := t.TempDir()
+	artifacts := map[string]string{
+		"infra/build-log.txt": "level=error msg=failed to install the cluster: timeout\n",
+		"e2e/build-log.txt":   ginkgoBuildLog,
+		"unit/build-log.txt":  "make: *** [test-unit] Error 1\n",
+		"unit/artifacts/unit/test/artifacts/junit_operator.xml": `<testsuites>
+  <testsuite name="pkg/operator">
+    <testcase name="TestEvictionLimits"><failure>eviction limit exceeded</failure></testcase>
+    <testcase name="TestSync"/>
+  </testsuite>
+</testsuites>`,
+	}
+	for name, content := range artifacts {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	previous := config
+	defer func() { config = previous }()
+	config = &PRLabelerConfig{}
+	// JUnit paths are not configured, the built-in ones are used
+	config.Spec.FailureClassification = &FailureClassification{
+		Contexts:               []string{"ci/prow/unit", "ci/prow/e2e-aws-operator"},
+		InfrastructurePatterns: builtinFailureClassification.InfrastructurePatterns,
+		FlakyTests:             []string{`^TestEviction`, `topology spread$`},
+	}
+	classification := failureClassification(config)
+
+	tests := []struct {
+		name    string
+		context string
+		path    string
+		class   failureClass
+		reason  string
+	}{
+		{"infrastructure", "ci/prow/unit", "infra", failureInfrastructure, `build log matches "failed to install the cluster"`},
+		{"flaky JUnit test", "ci/prow/unit", "unit", failureFlaky, "failed tests: TestEvictionLimits"},
+		{"Ginkgo failure", "ci/prow/e2e-aws-operator", "e2e", failureReal, "failed tests: [sig-scheduling] Descheduler [It] evicts duplicates, [sig-scheduling] Descheduler [It] evicts pods violating topology spread"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			class, reason, err := classification.classifyFailure(context.Background(), server.Client(), test.context, server.URL+"/"+test.path+"/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if class != test.class || reason != test.reason {
+				t.Errorf("expected %v (%v), got %v (%v)", test.class, test.reason, class, reason)
+			}
+		})
+	}
+}
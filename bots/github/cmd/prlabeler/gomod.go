@@ -0,0 +1,434 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v76/github"
+)
+
+// Levels of a go module version bump
+const (
+	bumpMajor = "major"
+	bumpMinor = "minor"
+	bumpPatch = "patch"
+	// Same major.minor.patch, only a pre-release or a pseudo-version differs
+	bumpPrerelease = "prerelease"
+)
+
+type moduleBump struct {
+	Path  string
+	From  string
+	To    string
+	Level string
+}
+
+type semver struct {
+	major, minor, patch int
+	prerelease          string
+}
+
+func parseSemver(version string) (semver, bool) {
+	v, found := strings.CutPrefix(version, "v")
+	if !found {
+		return semver{}, false
+	}
+	v, _, _ = strings.Cut(v, "+")
+	v, prerelease, _ := strings.Cut(v, "-")
+	items := strings.Split(v, ".")
+	if len(items) != 3 {
+		return semver{}, false
+	}
+	numbers := []int{}
+	for _, item := range items {
+		n, err := strconv.Atoi(item)
+		if err != nil || n < 0 {
+			return semver{}, false
+		}
+		numbers = append(numbers, n)
+	}
+	return semver{major: numbers[0], minor: numbers[1], patch: numbers[2], prerelease: prerelease}, true
+}
+
+// classifyBump returns the level of the version change. Unparsable versions
+// are treated as a major bump.
+func classifyBump(from, to string) string {
+	f, ok := parseSemver(from)
+	if !ok {
+		return bumpMajor
+	}
+	t, ok := parseSemver(to)
+	if !ok {
+		return bumpMajor
+	}
+	switch {
+	case f.major != t.major:
+		return bumpMajor
+	case f.minor != t.minor:
+		return bumpMinor
+	case f.patch != t.patch:
+		return bumpPatch
+	default:
+		return bumpPrerelease
+	}
+}
+
+// parseRequireLine parses a single "module version" requirement, either
+// inside a require block or prefixed with the require keyword.
+func parseRequireLine(line string) (string, string, bool) {
+	line, _, _ = strings.Cut(line, "//")
+	fields := strings.Fields(line)
+	if len(fields) > 0 && fields[0] == "require" {
+		fields = fields[1:]
+	}
+	if len(fields) != 2 || !strings.HasPrefix(fields[1], "v") {
+		return "", "", false
+	}
+	return fields[0], fields[1], true
+}
+
+// goModBlock returns the directive of a line opening a block, e.g. "exclude"
+// for "exclude (".
+func goModBlock(line string) (string, bool) {
+	directive, rest, found := strings.Cut(strings.TrimSpace(line), " ")
+	return directive, found && strings.TrimSpace(rest) == "("
+}
+
+// parseGoModRequires returns all the required modules and their versions.
+func parseGoModRequires(content string) map[string]string {
+	requires := make(map[string]string)
+	inBlock := false
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "require ("):
+			inBlock = true
+		case inBlock && trimmed == ")":
+			inBlock = false
+		case inBlock || strings.HasPrefix(trimmed, "require "):
+			if module, version, ok := parseRequireLine(trimmed); ok {
+				requires[module] = version
+			}
+		}
+	}
+	return requires
+}
+
+// parseGoModReplaces returns the replacement of every replaced module, e.g.
+// "example.com/fork v1.2.0" or "../local".
+func parseGoModReplaces(content string) map[string]string {
+	replaces := make(map[string]string)
+	inBlock := false
+	for _, line := range strings.Split(content, "\n") {
+		trimmed, _, _ := strings.Cut(strings.TrimSpace(line), "//")
+		switch {
+		case strings.HasPrefix(trimmed, "replace ("):
+			inBlock = true
+		case inBlock && strings.TrimSpace(trimmed) == ")":
+			inBlock = false
+		case inBlock || strings.HasPrefix(trimmed, "replace "):
+			old, replacement, found := strings.Cut(strings.TrimPrefix(trimmed, "replace "), "=>")
+			if fields := strings.Fields(old); found && len(fields) > 0 {
+				replaces[fields[0]] = strings.Join(strings.Fields(replacement), " ")
+			}
+		}
+	}
+	return replaces
+}
+
+// parseGoModDiff extracts version changes from a unified diff of go.mod.
+// A module removed without being re-added under the same path (e.g. moving
+// to a new /vN major path) is reported as a major bump. Only requirements
+// count, lines of exclude, replace and retract blocks are not bumps.
+func parseGoModDiff(patch string) []moduleBump {
+	removed := make(map[string]string)
+	added := make(map[string]string)
+	// Directive of the enclosing block, empty when outside of a block or
+	// not known
+	block := ""
+	for _, line := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(line, "---") || strings.HasPrefix(line, "+++") || line == "" {
+			continue
+		}
+		if header, found := strings.CutPrefix(line, "@@"); found {
+			// Hunk headers end with the line enclosing the hunk, e.g.
+			// "@@ -5,7 +5,7 @@ exclude ("
+			block = ""
+			if _, section, found := strings.Cut(header, "@@"); found {
+				if directive, ok := goModBlock(section); ok {
+					block = directive
+				}
+			}
+			continue
+		}
+		if directive, ok := goModBlock(line[1:]); ok {
+			block = directive
+			continue
+		}
+		if strings.TrimSpace(line[1:]) == ")" {
+			block = ""
+			continue
+		}
+		if block != "" && block != "require" {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "-"):
+			if module, version, ok := parseRequireLine(line[1:]); ok {
+				removed[module] = version
+			}
+		case strings.HasPrefix(line, "+"):
+			if module, version, ok := parseRequireLine(line[1:]); ok {
+				added[module] = version
+			}
+		}
+	}
+
+	bumps := []moduleBump{}
+	for module, to := range added {
+		from, exists := removed[module]
+		if !exists {
+			bumps = append(bumps, moduleBump{Path: module, To: to, Level: bumpMajor})
+			continue
+		}
+		if from == to {
+			continue
+		}
+		bumps = append(bumps, moduleBump{Path: module, From: from, To: to, Level: classifyBump(from, to)})
+	}
+	for module, from := range removed {
+		if _, exists := added[module]; !exists {
+			bumps = append(bumps, moduleBump{Path: module, From: from, Level: bumpMajor})
+		}
+	}
+	sort.Slice(bumps, func(i, j int) bool { return bumps[i].Path < bumps[j].Path })
+	return bumps
+}
+
+// vendoredModule is a module recorded in vendor/modules.txt.
+type vendoredModule struct {
+	// Version required by go.mod, empty for replacements of modules not
+	// required at all
+	Version string
+	// Target of the replace directive, e.g. "example.com/fork v1.2.0"
+	Replacement string
+}
+
+// parseModulesTxt returns modules explicitly required or replaced by go.mod
+// as recorded in vendor/modules.txt.
+func parseModulesTxt(content string) map[string]vendoredModule {
+	explicit := make(map[string]vendoredModule)
+	module, vendored := "", vendoredModule{}
+	for _, line := range strings.Split(content, "\n") {
+		switch {
+		case strings.HasPrefix(line, "# "):
+			// "# module version", "# module version => replacement" or
+			// "# module => replacement" lines
+			old, replacement, _ := strings.Cut(line[2:], "=>")
+			fields := strings.Fields(old)
+			module, vendored = "", vendoredModule{Replacement: strings.Join(strings.Fields(replacement), " ")}
+			if len(fields) == 0 || len(fields) > 2 {
+				continue
+			}
+			module = fields[0]
+			if len(fields) == 2 {
+				vendored.Version = fields[1]
+			}
+		case strings.HasPrefix(line, "## ") && module != "":
+			if strings.Contains(line, "explicit") {
+				explicit[module] = vendored
+			}
+		}
+	}
+	return explicit
+}
+
+func isModuleAllowlisted(module string, allowlist []string) bool {
+	for _, pattern := range allowlist {
+		if matched, _ := path.Match(pattern, module); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// approvableBump tells whether a bump can be approved automatically. Only
+// bumps within the same minor version are. Patch bumps to a release always
+// are, allowlisted modules can be bumped to any version of the same minor,
+// pre-releases and pseudo-versions included.
+func approvableBump(bump moduleBump, allowlist []string) bool {
+	if bump.Level != bumpPatch && bump.Level != bumpPrerelease {
+		return false
+	}
+	if isModuleAllowlisted(bump.Path, allowlist) {
+		return true
+	}
+	to, _ := parseSemver(bump.To)
+	return bump.Level == bumpPatch && to.prerelease == ""
+}
+
+func validateGoModuleFiles(files []string) (string, bool) {
+	for _, file := range files {
+		if file != "go.mod" && file != "go.sum" && !strings.HasPrefix(file, "vendor/") {
+			return file, false
+		}
+	}
+	return "", true
+}
+
+// validateVendorConsistency checks every module required in go.mod is
+// vendored in the same version and with the same replacement, and vice versa.
+func validateVendorConsistency(goMod, modulesTxt string) []string {
+	reasons := []string{}
+	requires := parseGoModRequires(goMod)
+	replaces := parseGoModReplaces(goMod)
+	vendored := parseModulesTxt(modulesTxt)
+	for module, version := range requires {
+		vendoredModule, exists := vendored[module]
+		switch {
+		case !exists:
+			reasons = append(reasons, fmt.Sprintf("vendor/modules.txt: %v is not marked as explicit", module))
+		case vendoredModule.Version != version:
+			reasons = append(reasons, fmt.Sprintf("vendor/modules.txt: %v is %v, go.mod requires %v", module, vendoredModule.Version, version))
+		}
+	}
+	for module, replacement := range replaces {
+		vendoredModule, exists := vendored[module]
+		switch {
+		case !exists:
+			reasons = append(reasons, fmt.Sprintf("vendor/modules.txt: replacement of %v is missing", module))
+		case vendoredModule.Replacement != replacement:
+			reasons = append(reasons, fmt.Sprintf("vendor/modules.txt: %v is replaced by %v, go.mod replaces it by %v", module, vendoredModule.Replacement, replacement))
+		}
+	}
+	for module, vendoredModule := range vendored {
+		if _, exists := requires[module]; !exists && vendoredModule.Version != "" {
+			reasons = append(reasons, fmt.Sprintf("vendor/modules.txt: %v is not required in go.mod", module))
+		}
+		if _, exists := replaces[module]; !exists && vendoredModule.Replacement != "" {
+			reasons = append(reasons, fmt.Sprintf("vendor/modules.txt: %v is not replaced in go.mod", module))
+		}
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+// isGoModuleUpdate tells whether the bot announced a go module update.
+func isGoModuleUpdate(metadata *prMetadata, files []string) bool {
+	if metadata.Module {
+		return true
+	}
+	for _, update := range metadata.Updates {
+		if update.Type == "require" || update.Type == "indirect" {
+			return true
+		}
+	}
+	// Dependabot does not distinguish package managers in its titles
+	return metadata.Profile == "dependabot" && metadata.Dependency != "" && slices.Contains(files, "go.mod")
+}
+
+// evaluateGoModuleBumpRule validates go module update PRs. Only
+// go.mod, go.sum and vendor/ can change, every bump has to be approvable and
+// the vendor directory has to be in sync with go.mod.
+func evaluateGoModuleBumpRule(ctx context.Context, client *github.Client, pr *github.PullRequest, matched bool, changes []*github.CommitFile, policy *BranchPolicy) (ruleTrace, error) {
+	rule := ruleTrace{
+		Name:           "go module update",
+		Validator:      "validateUpdateGoModules",
+		Matched:        matched,
+		Labels:         policy.Labels,
+		Label2Comments: policy.CommentLabels,
+	}
+	if !rule.Matched {
+		return rule, nil
+	}
+
+	files := []string{}
+	var goModChange *github.CommitFile
+	for _, change := range changes {
+		files = append(files, change.GetFilename())
+		if change.GetFilename() == "go.mod" {
+			goModChange = change
+		}
+	}
+
+	if offendingFile, ok := validateGoModuleFiles(files); !ok {
+		rule.OffendingFile = offendingFile
+		return rule, nil
+	}
+	if goModChange != nil && goModChange.Patch == nil {
+		allChanges, err := withPatches(ctx, client, pr, changes)
+		if err != nil {
+			return rule, err
+		}
+		for _, change := range allChanges {
+			if change.GetFilename() == "go.mod" {
+				goModChange = change
+			}
+		}
+	}
+	if goModChange == nil || goModChange.GetPatch() == "" {
+		rule.OffendingFile = "go.mod"
+		rule.Reasons = append(rule.Reasons, "go.mod diff is not available")
+		return rule, nil
+	}
+
+	bumps := parseGoModDiff(goModChange.GetPatch())
+	if len(bumps) == 0 {
+		rule.Reasons = append(rule.Reasons, "go.mod diff contains no module bumps")
+	}
+	for _, bump := range bumps {
+		approvable := approvableBump(bump, goModuleAllowlist)
+		rule.Reasons = append(rule.Reasons, fmt.Sprintf("%v %v -> %v: %v (approvable: %v)", bump.Path, bump.From, bump.To, bump.Level, approvable))
+		if !approvable {
+			rule.OffendingFile = "go.mod"
+		}
+	}
+	if rule.OffendingFile != "" || len(bumps) == 0 {
+		return rule, nil
+	}
+
+	owner := pr.GetHead().GetRepo().GetOwner().GetLogin()
+	repo := pr.GetHead().GetRepo().GetName()
+	headSHA := pr.GetHead().GetSHA()
+	modulesTxt, err := getFileContent(ctx, client, owner, repo, "vendor/modules.txt", headSHA)
+	switch {
+	case isNotFound(err):
+		// The repository does not vendor its dependencies
+	case err != nil:
+		return rule, err
+	default:
+		goMod, err := getFileContent(ctx, client, owner, repo, "go.mod", headSHA)
+		if err != nil {
+			return rule, err
+		}
+		if reasons := validateVendorConsistency(goMod, modulesTxt); len(reasons) > 0 {
+			rule.OffendingFile = "vendor/modules.txt"
+			rule.Reasons = append(rule.Reasons, reasons...)
+			return rule, nil
+		}
+	}
+
+	rule.Valid = true
+	return rule, nil
+}
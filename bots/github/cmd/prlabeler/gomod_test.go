@@ -0,0 +1,120 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApprovableBump(t *testing.T) {
+	allowlist := []string{"k8s.io/*"}
+	tests := []struct {
+		path, from, to string
+		approvable     bool
+	}{
+		{"github.com/spf13/pflag", "v1.0.5", "v1.0.6", true},
+		{"github.com/spf13/pflag", "v1.0.5", "v1.1.0", false},
+		{"github.com/spf13/pflag", "v1.0.5", "v1.0.6-rc.1", false},
+		{"github.com/spf13/pflag", "v1.0.5", "v1.0.5-0.20250301000000-abcdefabcdef", false},
+		{"k8s.io/api", "v0.32.1", "v0.32.2", true},
+		{"k8s.io/api", "v0.32.1", "v0.32.3-rc.0", true},
+		{"k8s.io/api", "v0.32.1", "v0.32.1-0.20250301000000-abcdefabcdef", true},
+		{"k8s.io/api", "v0.32.1", "v0.33.0", false},
+		{"k8s.io/api", "v0.32.1", "v1.0.0", false},
+	}
+	for _, test := range tests {
+		bump := moduleBump{Path: test.path, From: test.from, To: test.to, Level: classifyBump(test.from, test.to)}
+		if approvable := approvableBump(bump, allowlist); approvable != test.approvable {
+			t.Errorf("%v %v -> %v: expected approvable %v, got %v", test.path, test.from, test.to, test.approvable, approvable)
+		}
+	}
+}
+
+func TestParseGoModDiff(t *testing.T) {
+	patch := `@@ -5,10 +5,10 @@ require (
+ 	github.com/google/go-github/v76 v76.0.0
+-	github.com/spf13/pflag v1.0.5
++	github.com/spf13/pflag v1.0.6
+ )
+ 
+ exclude (
+-	golang.org/x/net v0.1.0
++	golang.org/x/net v0.2.0
+ )
+ 
+ replace (
+-	k8s.io/api => k8s.io/api v0.32.1
++	k8s.io/api => k8s.io/api v0.32.2
+ )
+@@ -30,3 +30,3 @@ exclude (
+-	golang.org/x/text v0.1.0
++	golang.org/x/text v0.3.0
+ )
+@@ -40,2 +40,2 @@
+-exclude golang.org/x/sys v0.1.0
++exclude golang.org/x/sys v0.2.0
+-require golang.org/x/oauth2 v0.20.0
++require golang.org/x/oauth2 v0.20.1`
+	expected := []moduleBump{
+		{Path: "github.com/spf13/pflag", From: "v1.0.5", To: "v1.0.6", Level: bumpPatch},
+		{Path: "golang.org/x/oauth2", From: "v0.20.0", To: "v0.20.1", Level: bumpPatch},
+	}
+	if bumps := parseGoModDiff(patch); !reflect.DeepEqual(bumps, expected) {
+		t.Errorf("expected %+v, got %+v", expected, bumps)
+	}
+}
+
+func TestValidateVendorConsistency(t *testing.T) {
+	goMod := `module example.com/m
+
+require (
+	github.com/spf13/pflag v1.0.6
+	k8s.io/api v0.32.1
+)
+
+replace (
+	k8s.io/api => k8s.io/api v0.32.2
+	example.com/unused => ../unused
+)
+`
+	modulesTxt := `# github.com/spf13/pflag v1.0.6
+## explicit; go 1.12
+github.com/spf13/pflag
+# k8s.io/api v0.32.1 => k8s.io/api v0.32.2
+## explicit; go 1.23
+k8s.io/api/core/v1
+# example.com/unused => ../unused
+## explicit
+`
+	if reasons := validateVendorConsistency(goMod, modulesTxt); len(reasons) != 0 {
+		t.Errorf("expected a consistent vendor directory, got %v", reasons)
+	}
+
+	stale := `# github.com/spf13/pflag v1.0.6
+## explicit; go 1.12
+# k8s.io/api v0.32.1 => k8s.io/api v0.32.1
+## explicit; go 1.23
+`
+	expected := []string{
+		"vendor/modules.txt: k8s.io/api is replaced by k8s.io/api v0.32.1, go.mod replaces it by k8s.io/api v0.32.2",
+		"vendor/modules.txt: replacement of example.com/unused is missing",
+	}
+	if reasons := validateVendorConsistency(goMod, stale); !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("expected %q, got %q", expected, reasons)
+	}
+}
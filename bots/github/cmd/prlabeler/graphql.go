@@ -0,0 +1,331 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v76/github"
+	"k8s.io/klog/v2"
+)
+
+// Number of PRs fetched in a single GraphQL request
+const graphqlPRBatchSize = 25
+
+// The query fetches everything validators need for a batch of open PRs. Lists
+// are capped at 100 items, incomplete lists are left to the REST fallback.
+const openPRsQuery = `query($owner: String!, $name: String!, $cursor: String, $batch: Int!) {
+  repository(owner: $owner, name: $name) {
+    pullRequests(states: OPEN, first: $batch, after: $cursor, orderBy: {field: UPDATED_AT, direction: DESC}) {
+      pageInfo { hasNextPage endCursor }
+      nodes {
+        number
+        title
+        body
+        url
+        createdAt
+        updatedAt
+        author { __typename login }
+        baseRefName
+        headRefName
+        headRefOid
+        baseRepository { name nameWithOwner owner { login } }
+        headRepository { name nameWithOwner owner { login } }
+        labels(first: 100) { nodes { name } }
+        files(first: 100) {
+          pageInfo { hasNextPage }
+          nodes { path additions deletions changeType }
+        }
+        comments(last: 100) {
+          totalCount
+          nodes { databaseId body createdAt author { __typename login } }
+        }
+        headCommit: commits(last: 1) {
+          nodes {
+            commit {
+              status { contexts { context state description targetUrl createdAt } }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+type graphqlActor struct {
+	Typename string `json:"__typename"`
+	Login    string `json:"login"`
+}
+
+// login returns the login in the form REST uses ("name[bot]" for bots).
+func (a *graphqlActor) login() string {
+	if a == nil {
+		return ""
+	}
+	if a.Typename == "Bot" && !strings.HasSuffix(a.Login, "[bot]") {
+		return a.Login + "[bot]"
+	}
+	return a.Login
+}
+
+type graphqlRepository struct {
+	Name          string `json:"name"`
+	NameWithOwner string `json:"nameWithOwner"`
+	Owner         struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+}
+
+func (r *graphqlRepository) toRepository() *github.Repository {
+	if r == nil {
+		return nil
+	}
+	return &github.Repository{
+		Name:     github.String(r.Name),
+		FullName: github.String(r.NameWithOwner),
+		Owner:    &github.User{Login: github.String(r.Owner.Login)},
+	}
+}
+
+type graphqlPullRequest struct {
+	Number         int                `json:"number"`
+	Title          string             `json:"title"`
+	Body           string             `json:"body"`
+	URL            string             `json:"url"`
+	CreatedAt      time.Time          `json:"createdAt"`
+	UpdatedAt      time.Time          `json:"updatedAt"`
+	Author         *graphqlActor      `json:"author"`
+	BaseRefName    string             `json:"baseRefName"`
+	HeadRefName    string             `json:"headRefName"`
+	HeadRefOid     string             `json:"headRefOid"`
+	BaseRepository *graphqlRepository `json:"baseRepository"`
+	HeadRepository *graphqlRepository `json:"headRepository"`
+	Labels         struct {
+		Nodes []struct {
+			Name string `json:"name"`
+		} `json:"nodes"`
+	} `json:"labels"`
+	Files struct {
+		PageInfo struct {
+			HasNextPage bool `json:"hasNextPage"`
+		} `json:"pageInfo"`
+		Nodes []struct {
+			Path       string `json:"path"`
+			Additions  int    `json:"additions"`
+			Deletions  int    `json:"deletions"`
+			ChangeType string `json:"changeType"`
+		} `json:"nodes"`
+	} `json:"files"`
+	Comments struct {
+		TotalCount int `json:"totalCount"`
+		Nodes      []struct {
+			DatabaseID int64         `json:"databaseId"`
+			Body       string        `json:"body"`
+			CreatedAt  time.Time     `json:"createdAt"`
+			Author     *graphqlActor `json:"author"`
+		} `json:"nodes"`
+	} `json:"comments"`
+	HeadCommit struct {
+		Nodes []struct {
+			Commit struct {
+				Status *struct {
+					Contexts []struct {
+						Context     string    `json:"context"`
+						State       string    `json:"state"`
+						Description string    `json:"description"`
+						TargetURL   string    `json:"targetUrl"`
+						CreatedAt   time.Time `json:"createdAt"`
+					} `json:"contexts"`
+				} `json:"status"`
+			} `json:"commit"`
+		} `json:"nodes"`
+	} `json:"headCommit"`
+}
+
+type openPRsResponse struct {
+	Data struct {
+		Repository struct {
+			PullRequests struct {
+				PageInfo struct {
+					HasNextPage bool   `json:"hasNextPage"`
+					EndCursor   string `json:"endCursor"`
+				} `json:"pageInfo"`
+				Nodes []graphqlPullRequest `json:"nodes"`
+			} `json:"pullRequests"`
+		} `json:"repository"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// prefetchedPR holds the PR data fetched in bulk. Nil lists were not fetched
+// completely and have to be fetched through REST.
+type prefetchedPR struct {
+	Files    []*github.CommitFile
+	Comments []*github.IssueComment
+	Statuses []*github.RepoStatus
+}
+
+// prefetched is filled by prefetchOpenPRs and consulted before REST calls.
+var prefetched = make(map[string]*prefetchedPR)
+
+func prefetchKey(owner, repo string, number int) string {
+	return fmt.Sprintf("%v/%v#%v", owner, repo, number)
+}
+
+func getPrefetched(owner, repo string, number int) *prefetchedPR {
+	return prefetched[prefetchKey(owner, repo, number)]
+}
+
+// graphqlURL derives the GraphQL endpoint from the REST base URL
+// (https://api.github.com/ or https://HOST/api/v3/ for GitHub Enterprise).
+func graphqlURL(client *github.Client) string {
+	u := *client.BaseURL
+	if strings.HasSuffix(u.Path, "/api/v3/") {
+		u.Path = strings.TrimSuffix(u.Path, "v3/") + "graphql"
+	} else {
+		u.Path += "graphql"
+	}
+	return u.String()
+}
+
+// toPrefetched converts a GraphQL PR into the REST types validators use.
+func (p *graphqlPullRequest) toPrefetched() (*github.PullRequest, *prefetchedPR) {
+	pr := &github.PullRequest{
+		Number:    github.Int(p.Number),
+		Title:     github.String(p.Title),
+		Body:      github.String(p.Body),
+		HTMLURL:   github.String(p.URL),
+		CreatedAt: &github.Timestamp{Time: p.CreatedAt},
+		UpdatedAt: &github.Timestamp{Time: p.UpdatedAt},
+		User:      &github.User{Login: github.String(p.Author.login())},
+		Base: &github.PullRequestBranch{
+			Ref:  github.String(p.BaseRefName),
+			Repo: p.BaseRepository.toRepository(),
+		},
+		Head: &github.PullRequestBranch{
+			Ref:  github.String(p.HeadRefName),
+			SHA:  github.String(p.HeadRefOid),
+			Repo: p.HeadRepository.toRepository(),
+		},
+	}
+	for _, label := range p.Labels.Nodes {
+		pr.Labels = append(pr.Labels, &github.Label{Name: github.String(label.Name)})
+	}
+
+	data := &prefetchedPR{}
+	if !p.Files.PageInfo.HasNextPage {
+		data.Files = []*github.CommitFile{}
+		for _, file := range p.Files.Nodes {
+			data.Files = append(data.Files, &github.CommitFile{
+				Filename:  github.String(file.Path),
+				Additions: github.Int(file.Additions),
+				Deletions: github.Int(file.Deletions),
+				Changes:   github.Int(file.Additions + file.Deletions),
+				Status:    github.String(strings.ToLower(file.ChangeType)),
+			})
+		}
+	}
+	if p.Comments.TotalCount <= len(p.Comments.Nodes) {
+		data.Comments = []*github.IssueComment{}
+		for _, comment := range p.Comments.Nodes {
+			data.Comments = append(data.Comments, &github.IssueComment{
+				ID:        github.Int64(comment.DatabaseID),
+				Body:      github.String(comment.Body),
+				CreatedAt: &github.Timestamp{Time: comment.CreatedAt},
+				User:      &github.User{Login: github.String(comment.Author.login())},
+			})
+		}
+	}
+	data.Statuses = []*github.RepoStatus{}
+	for _, node := range p.HeadCommit.Nodes {
+		if node.Commit.Status != nil {
+			for _, status := range node.Commit.Status.Contexts {
+				data.Statuses = append(data.Statuses, &github.RepoStatus{
+					Context:     github.String(status.Context),
+					State:       github.String(strings.ToLower(status.State)),
+					Description: github.String(status.Description),
+					TargetURL:   github.String(status.TargetURL),
+					CreatedAt:   &github.Timestamp{Time: status.CreatedAt},
+					UpdatedAt:   &github.Timestamp{Time: status.CreatedAt},
+				})
+			}
+		}
+	}
+	return pr, data
+}
+
+// prefetchOpenPRs fetches all open PRs of a repository with their labels,
+// changed files, latest comments and statuses in batches. The PR
+// data is stored for the validators to use instead of per PR REST calls.
+func prefetchOpenPRs(ctx context.Context, client *github.Client, owner, repo string) ([]*github.PullRequest, error) {
+	// Drop data of PRs fetched in a previous run
+	for key := range prefetched {
+		if strings.HasPrefix(key, owner+"/"+repo+"#") {
+			delete(prefetched, key)
+		}
+	}
+
+	prs := []*github.PullRequest{}
+	var cursor *string
+
+	for {
+		body := map[string]any{
+			"query": openPRsQuery,
+			"variables": map[string]any{
+				"owner":  owner,
+				"name":   repo,
+				"cursor": cursor,
+				"batch":  graphqlPRBatchSize,
+			},
+		}
+		resp := &openPRsResponse{}
+		err := withRetry(ctx, func() error {
+			req, err := client.NewRequest("POST", graphqlURL(client), body)
+			if err != nil {
+				return err
+			}
+			_, err = client.Do(ctx, req, resp)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error querying open PRs of %v/%v: %v", owner, repo, err)
+		}
+		if len(resp.Errors) > 0 {
+			return nil, fmt.Errorf("error querying open PRs of %v/%v: %v", owner, repo, resp.Errors[0].Message)
+		}
+
+		pullRequests := resp.Data.Repository.PullRequests
+		for i := range pullRequests.Nodes {
+			pr, data := pullRequests.Nodes[i].toPrefetched()
+			prefetched[prefetchKey(owner, repo, pr.GetNumber())] = data
+			prs = append(prs, pr)
+		}
+		klog.V(2).InfoS("Fetched a batch of open PRs", "repository", owner+"/"+repo, "count", len(pullRequests.Nodes))
+
+		if !pullRequests.PageInfo.HasNextPage {
+			break
+		}
+		cursor = github.String(pullRequests.PageInfo.EndCursor)
+	}
+
+	return prs, nil
+}
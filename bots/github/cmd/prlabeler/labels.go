@@ -0,0 +1,218 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v76/github"
+	"k8s.io/klog/v2"
+)
+
+func getRepositoryLabels(ctx context.Context, client *github.Client, owner, repo string) ([]*github.Label, error) {
+	allLabels := []*github.Label{}
+	listOpts := &github.ListOptions{PerPage: 100}
+
+	for {
+		labels, resp, err := client.Issues.ListLabels(ctx, owner, repo, listOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error listing labels of %v/%v: %v", owner, repo, err)
+		}
+
+		allLabels = append(allLabels, labels...)
+
+		if resp.NextPage == 0 {
+			break
+		}
+		listOpts.Page = resp.NextPage
+	}
+
+	return allLabels, nil
+}
+
+// syncRepositoryLabels creates the declared labels missing in the repository
+// and updates colors and descriptions of the existing ones. Labels not
+// declared are left untouched. Label names are case insensitive.
+func syncRepositoryLabels(ctx context.Context, client *github.Client, owner, repo string, declared []LabelSpec) error {
+	existing, err := getRepositoryLabels(ctx, client, owner, repo)
+	if err != nil {
+		return err
+	}
+	existingLabels := make(map[string]*github.Label)
+	for _, label := range existing {
+		existingLabels[strings.ToLower(label.GetName())] = label
+	}
+
+	for _, spec := range declared {
+		color := strings.ToLower(spec.Color)
+		label, exists := existingLabels[strings.ToLower(spec.Name)]
+		if !exists {
+			klog.InfoS("Creating label", "repository", owner+"/"+repo, "label", spec.Name)
+			_, _, err := client.Issues.CreateLabel(ctx, owner, repo, &github.Label{
+				Name:        github.String(spec.Name),
+				Color:       github.String(color),
+				Description: github.String(spec.Description),
+			})
+			if err != nil {
+				return fmt.Errorf("error creating label %q in %v/%v: %v", spec.Name, owner, repo, err)
+			}
+			continue
+		}
+		if label.GetName() == spec.Name && strings.ToLower(label.GetColor()) == color && label.GetDescription() == spec.Description {
+			continue
+		}
+		klog.InfoS("Updating label", "repository", owner+"/"+repo, "label", spec.Name)
+		_, _, err := client.Issues.EditLabel(ctx, owner, repo, label.GetName(), &github.Label{
+			Name:        github.String(spec.Name),
+			Color:       github.String(color),
+			Description: github.String(spec.Description),
+		})
+		if err != nil {
+			return fmt.Errorf("error updating label %q in %v/%v: %v", spec.Name, owner, repo, err)
+		}
+	}
+	return nil
+}
+
+// undeclaredPRLabels maps labels of open PRs which are not declared to the
+// PR numbers carrying them.
+func undeclaredPRLabels(ctx context.Context, client *github.Client, owner, repo string, declared []LabelSpec) (map[string][]int, error) {
+	declaredLabels := make(map[string]bool)
+	for _, spec := range declared {
+		declaredLabels[strings.ToLower(spec.Name)] = true
+	}
+
+	undeclared := make(map[string][]int)
+	opts := &github.PullRequestListOptions{
+		State:       "open",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		prs, resp, err := client.PullRequests.List(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error listing PRs of %v/%v: %v", owner, repo, err)
+		}
+		for _, pr := range prs {
+			for _, label := range pr.Labels {
+				if !declaredLabels[strings.ToLower(label.GetName())] {
+					undeclared[label.GetName()] = append(undeclared[label.GetName()], pr.GetNumber())
+				}
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return undeclared, nil
+}
+
+// undeclaredLabel is a label used on PRs of a repository that is not
+// declared.
+type undeclaredLabel struct {
+	Repository string `json:"repository"`
+	Label      string `json:"label"`
+	PRs        []int  `json:"prs"`
+}
+
+// labelSyncResult is the output of the labels sync command.
+type labelSyncResult struct {
+	Undeclared []undeclaredLabel `json:"undeclared"`
+	*runSummary
+}
+
+func (r *labelSyncResult) report(w io.Writer) {
+	if len(r.Undeclared) > 0 {
+		fmt.Fprintf(w, "Labels used on PRs that are not declared:\n")
+		for _, label := range r.Undeclared {
+			prs := []string{}
+			for _, number := range label.PRs {
+				prs = append(prs, fmt.Sprintf("#%v", number))
+			}
+			fmt.Fprintf(w, "  %v: %v (%v)\n", label.Repository, label.Label, strings.Join(prs, ", "))
+		}
+	}
+	r.runSummary.report(w)
+}
+
+// syncAllLabels syncs the declared labels in all the managed repositories
+// and returns labels used on PRs that are not declared.
+func syncAllLabels(ctx context.Context, repositories []string, summary *runSummary) []undeclaredLabel {
+	drift := []undeclaredLabel{}
+	if len(config.Spec.Labels) == 0 {
+		klog.Info("No labels declared, skipping the label sync")
+		return drift
+	}
+
+	for _, repo := range repositories {
+		items := strings.Split(repo, "/")
+		client, err := newGitHubClient(ctx, items[0], items[1])
+		if err != nil {
+			summary.add(repo, 0, err)
+			continue
+		}
+		if err := syncRepositoryLabels(ctx, client, items[0], items[1], config.Spec.Labels); err != nil {
+			summary.add(repo, 0, err)
+			continue
+		}
+		undeclared, err := undeclaredPRLabels(ctx, client, items[0], items[1], config.Spec.Labels)
+		if err != nil {
+			summary.add(repo, 0, err)
+			continue
+		}
+		names := []string{}
+		for name := range undeclared {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			klog.InfoS("Label used on PRs is not declared", "repository", repo, "label", name, "prs", undeclared[name])
+			drift = append(drift, undeclaredLabel{Repository: repo, Label: name, PRs: undeclared[name]})
+		}
+	}
+	return drift
+}
+
+// labelsSyncMain syncs the labels declared in the configuration file.
+func labelsSyncMain(args []string) {
+	fs := commandFlagSet("labels sync")
+	fs.StringSliceVar(&repositories, "repository", repositories, "List of repositories to process")
+	fs.StringVar(&tokenFilename, "token-file", tokenFilename, "File with the GitHub token, read again when it changes (GITHUB_TOKEN is used when not set). Credentials of the configuration file take precedence.")
+	fs.Parse(args)
+	applyGlobalFlags()
+
+	validateRepositories()
+	if configFilename == "" {
+		klog.Error("config is required")
+		os.Exit(1)
+	}
+	applyConfig()
+
+	ctx := context.Background()
+	summary := &runSummary{}
+	result := &labelSyncResult{runSummary: summary}
+	result.Undeclared = syncAllLabels(ctx, checkRepositoryAccess(ctx, summary), summary)
+	writeOutput(result, result.report)
+	if summary.failed() {
+		os.Exit(1)
+	}
+}
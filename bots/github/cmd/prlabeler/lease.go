@@ -0,0 +1,374 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"k8s.io/klog/v2"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// Lease times are serialized as metav1.MicroTime
+	microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// kubeClient is a minimal Kubernetes API client, just enough to maintain a
+// Lease.
+type kubeClient struct {
+	host       string
+	httpClient *http.Client
+}
+
+// kubeConfig locates the Kubernetes API and the credentials to access it.
+type kubeConfig struct {
+	// API base URL, e.g. https://10.0.0.1:443
+	host string
+	// PEM encoded CA bundle of the API
+	ca []byte
+	// File with the bearer token, read again when it is rotated
+	tokenFile string
+}
+
+// inClusterKubeConfig returns the configuration of the pod's service account.
+func inClusterKubeConfig() (*kubeConfig, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the cluster CA: %v", err)
+	}
+	return &kubeConfig{
+		host:      "https://" + net.JoinHostPort(host, port),
+		ca:        ca,
+		tokenFile: serviceAccountDir + "/token",
+	}, nil
+}
+
+func newKubeClient(cfg *kubeConfig) (*kubeClient, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(cfg.ca) {
+		return nil, fmt.Errorf("no certificates found in the cluster CA")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &kubeClient{
+		host: cfg.host,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: cassetteTransport(&oauth2.Transport{
+				Source: &fileTokenSource{filename: cfg.tokenFile},
+				Base:   transport,
+			}),
+		},
+	}, nil
+}
+
+// inClusterKubeClient authenticates with the pod's service account.
+func inClusterKubeClient() (*kubeClient, error) {
+	cfg, err := inClusterKubeConfig()
+	if err != nil {
+		return nil, err
+	}
+	return newKubeClient(cfg)
+}
+
+// inClusterNamespace returns the namespace of the pod.
+func inClusterNamespace() (string, error) {
+	namespace, err := readSecret(serviceAccountDir + "/namespace")
+	if err != nil {
+		return "", fmt.Errorf("unable to read the pod namespace: %v", err)
+	}
+	return namespace, nil
+}
+
+// do sends the object as JSON and decodes the response into out. The status
+// code is returned for any response.
+func (c *kubeClient) do(ctx context.Context, method, path string, in, out interface{}) (int, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.host+path, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("%v %v: %v: %v", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return resp.StatusCode, fmt.Errorf("unable to decode %v %v: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// lease is a coordination.k8s.io/v1 Lease.
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+}
+
+// leaderElector keeps a Lease held while running. Other replicas only take
+// the Lease over once it has not been renewed for its whole duration, the
+// leader steps down before that so two replicas never lead at once.
+type leaderElector struct {
+	client    *kubeClient
+	namespace string
+	name      string
+	identity  string
+	// How long others wait before taking an unrenewed Lease over
+	leaseDuration time.Duration
+	// How long the leader keeps leading without a successful renewal
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+	clock         clock
+
+	mutex sync.Mutex
+	// Lease spec last seen and when it was seen. Expiration is measured by
+	// the local clock so clock skew between replicas does not matter.
+	observed     leaseSpec
+	observedTime time.Time
+	renewed      time.Time
+	leaderCtx    context.Context
+	stopLeading  context.CancelFunc
+}
+
+func newLeaderElector(client *kubeClient, namespace, name, identity string) *leaderElector {
+	return &leaderElector{
+		client:        client,
+		namespace:     namespace,
+		name:          name,
+		identity:      identity,
+		leaseDuration: leaseDuration,
+		renewDeadline: renewDeadline,
+		retryPeriod:   retryPeriod,
+		clock:         realClock{},
+	}
+}
+
+func (e *leaderElector) path() string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%v/leases", e.namespace)
+}
+
+// tryAcquireOrRenew creates, takes over or renews the Lease. False is
+// returned when another replica holds it.
+func (e *leaderElector) tryAcquireOrRenew(ctx context.Context) (bool, error) {
+	now := e.clock.Now()
+	spec := leaseSpec{
+		HolderIdentity:       e.identity,
+		LeaseDurationSeconds: int(e.leaseDuration.Seconds()),
+		AcquireTime:          now.Format(microTimeFormat),
+		RenewTime:            now.Format(microTimeFormat),
+	}
+
+	current := &lease{}
+	status, err := e.client.do(ctx, http.MethodGet, e.path()+"/"+e.name, nil, current)
+	if status == http.StatusNotFound {
+		created := &lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   leaseMetadata{Name: e.name, Namespace: e.namespace},
+			Spec:       spec,
+		}
+		status, err := e.client.do(ctx, http.MethodPost, e.path(), created, nil)
+		if status == http.StatusConflict {
+			// Created by another replica in the meantime
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		e.observe(spec, now)
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	e.mutex.Lock()
+	if current.Spec != e.observed {
+		e.observed, e.observedTime = current.Spec, now
+	}
+	expires := e.observedTime.Add(time.Duration(current.Spec.LeaseDurationSeconds) * time.Second)
+	e.mutex.Unlock()
+	holder := current.Spec.HolderIdentity
+	if holder != "" && holder != e.identity && now.Before(expires) {
+		return false, nil
+	}
+
+	spec.LeaseTransitions = current.Spec.LeaseTransitions
+	if holder == e.identity {
+		spec.AcquireTime = current.Spec.AcquireTime
+	} else {
+		spec.LeaseTransitions++
+	}
+	current.Spec = spec
+	// The resource version makes the update fail when the Lease changed
+	status, err = e.client.do(ctx, http.MethodPut, e.path()+"/"+e.name, current, nil)
+	if status == http.StatusConflict {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	e.observe(spec, now)
+	return true, nil
+}
+
+func (e *leaderElector) observe(spec leaseSpec, now time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.observed, e.observedTime = spec, now
+}
+
+// leader returns a context canceled once the replica stops leading, false
+// when it does not lead.
+func (e *leaderElector) leader() (context.Context, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.leaderCtx, e.leaderCtx != nil
+}
+
+// step tries to acquire or renew the Lease once and starts or stops leading
+// accordingly.
+func (e *leaderElector) step(ctx context.Context) {
+	acquired, err := e.tryAcquireOrRenew(ctx)
+	if err != nil && ctx.Err() == nil {
+		klog.ErrorS(err, "Unable to acquire or renew the lease", "lease", e.namespace+"/"+e.name)
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	switch {
+	case acquired:
+		e.renewed = e.clock.Now()
+		if e.leaderCtx == nil {
+			klog.InfoS("Started leading", "lease", e.namespace+"/"+e.name, "identity", e.identity)
+			e.leaderCtx, e.stopLeading = context.WithCancel(ctx)
+		}
+	// Keep leading through API errors until the renew deadline
+	case e.leaderCtx != nil && (err == nil || e.clock.Now().Sub(e.renewed) > e.renewDeadline):
+		klog.InfoS("Stopped leading", "lease", e.namespace+"/"+e.name, "identity", e.identity)
+		e.stopLeading()
+		e.leaderCtx = nil
+	}
+}
+
+// run maintains the Lease until the context is canceled. The Lease is
+// released then so another replica can take over right away.
+func (e *leaderElector) run(ctx context.Context) {
+	ticker := time.NewTicker(e.retryPeriod)
+	defer ticker.Stop()
+	for {
+		e.step(ctx)
+
+		select {
+		case <-ctx.Done():
+			e.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// release gives up the Lease if held.
+func (e *leaderElector) release() {
+	e.mutex.Lock()
+	leading := e.leaderCtx != nil
+	if leading {
+		e.stopLeading()
+		e.leaderCtx = nil
+	}
+	e.mutex.Unlock()
+	if !leading {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	current := &lease{}
+	if _, err := e.client.do(ctx, http.MethodGet, e.path()+"/"+e.name, nil, current); err != nil {
+		klog.ErrorS(err, "Unable to release the lease", "lease", e.namespace+"/"+e.name)
+		return
+	}
+	if current.Spec.HolderIdentity != e.identity {
+		return
+	}
+	now := e.clock.Now().Format(microTimeFormat)
+	current.Spec = leaseSpec{
+		LeaseDurationSeconds: 1,
+		AcquireTime:          now,
+		RenewTime:            now,
+		LeaseTransitions:     current.Spec.LeaseTransitions,
+	}
+	if _, err := e.client.do(ctx, http.MethodPut, e.path()+"/"+e.name, current, nil); err != nil {
+		klog.ErrorS(err, "Unable to release the lease", "lease", e.namespace+"/"+e.name)
+		return
+	}
+	klog.InfoS("Released the lease", "lease", e.namespace+"/"+e.name)
+}
@@ -0,0 +1,267 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testLeasePath = "/apis/coordination.k8s.io/v1/namespaces/prlabeler/leases"
+	testKubeToken = "service-account-token"
+)
+
+// fakeLeaseAPI serves a single Lease the way the Kubernetes API does,
+// refusing updates of stale resource versions.
+type fakeLeaseAPI struct {
+	t     *testing.T
+	mutex sync.Mutex
+	lease *lease
+	// Requests fail with 500 while set
+	failing bool
+}
+
+func (f *fakeLeaseAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if r.Header.Get("Authorization") != "Bearer "+testKubeToken {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if f.failing {
+		http.Error(w, "etcdserver: request timed out", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == testLeasePath+"/prlabeler":
+		if f.lease == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(f.lease)
+	case r.Method == http.MethodPost && r.URL.Path == testLeasePath:
+		if f.lease != nil {
+			http.Error(w, "already exists", http.StatusConflict)
+			return
+		}
+		f.lease = f.decode(r)
+		f.lease.Metadata.ResourceVersion = "1"
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(f.lease)
+	case r.Method == http.MethodPut && r.URL.Path == testLeasePath+"/prlabeler":
+		updated := f.decode(r)
+		if f.lease == nil || updated.Metadata.ResourceVersion != f.lease.Metadata.ResourceVersion {
+			http.Error(w, "the object has been modified", http.StatusConflict)
+			return
+		}
+		version, _ := strconv.Atoi(f.lease.Metadata.ResourceVersion)
+		updated.Metadata.ResourceVersion = strconv.Itoa(version + 1)
+		f.lease = updated
+		json.NewEncoder(w).Encode(f.lease)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func (f *fakeLeaseAPI) decode(r *http.Request) *lease {
+	decoded := &lease{}
+	if err := json.NewDecoder(r.Body).Decode(decoded); err != nil {
+		f.t.Errorf("unable to decode the Lease: %v", err)
+	}
+	return decoded
+}
+
+func (f *fakeLeaseAPI) holder() string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.lease == nil {
+		return ""
+	}
+	return f.lease.Spec.HolderIdentity
+}
+
+func (f *fakeLeaseAPI) setFailing(failing bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.failing = failing
+}
+
+// newFakeLeaseAPI serves the API over TLS and returns a client trusting it
+// through the injected CA and token file.
+func newFakeLeaseAPI(t *testing.T) (*fakeLeaseAPI, *kubeClient) {
+	api := &fakeLeaseAPI{t: t}
+	server := httptest.NewTLSServer(api)
+	t.Cleanup(server.Close)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte(testKubeToken+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client, err := newKubeClient(&kubeConfig{
+		host:      server.URL,
+		ca:        pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}),
+		tokenFile: tokenFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api, client
+}
+
+func testElector(client *kubeClient, identity string, now time.Time) (*leaderElector, *fixedClock) {
+	elector := newLeaderElector(client, "prlabeler", "prlabeler", identity)
+	elector.leaseDuration = 15 * time.Second
+	elector.renewDeadline = 10 * time.Second
+	elector.retryPeriod = 10 * time.Millisecond
+	clock := &fixedClock{now: now}
+	elector.clock = clock
+	return elector, clock
+}
+
+func leading(e *leaderElector) bool {
+	_, leading := e.leader()
+	return leading
+}
+
+func TestLeaderElectionTakeover(t *testing.T) {
+	ctx := context.Background()
+	api, client := newFakeLeaseAPI(t)
+	start := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	first, firstClock := testElector(client, "replica-1", start)
+	second, secondClock := testElector(client, "replica-2", start)
+
+	first.step(ctx)
+	second.step(ctx)
+	if !leading(first) || leading(second) {
+		t.Fatalf("expected replica-1 to lead alone, leading: %v, %v", leading(first), leading(second))
+	}
+
+	// The Lease is renewed, so it does not expire for the other replica
+	firstClock.now = start.Add(10 * time.Second)
+	first.step(ctx)
+	secondClock.now = start.Add(20 * time.Second)
+	second.step(ctx)
+	if leading(second) {
+		t.Fatalf("replica-2 took over a renewed Lease")
+	}
+
+	// replica-1 stops renewing, the Lease expires for replica-2 once it has
+	// not changed for its whole duration
+	secondClock.now = start.Add(34 * time.Second)
+	second.step(ctx)
+	if leading(second) {
+		t.Fatalf("replica-2 took over before the Lease expired")
+	}
+	secondClock.now = start.Add(36 * time.Second)
+	second.step(ctx)
+	if !leading(second) || api.holder() != "replica-2" {
+		t.Fatalf("replica-2 did not take the expired Lease over, holder %q", api.holder())
+	}
+	if transitions := api.lease.Spec.LeaseTransitions; transitions != 1 {
+		t.Errorf("expected 1 transition, got %v", transitions)
+	}
+
+	leaderCtx, _ := first.leader()
+	firstClock.now = start.Add(36 * time.Second)
+	first.step(ctx)
+	if leading(first) {
+		t.Fatalf("replica-1 kept leading a Lease held by replica-2")
+	}
+	if leaderCtx.Err() == nil {
+		t.Errorf("the leader context of replica-1 was not canceled")
+	}
+}
+
+func TestLeaderElectionRenewDeadline(t *testing.T) {
+	ctx := context.Background()
+	api, client := newFakeLeaseAPI(t)
+	start := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	elector, clock := testElector(client, "replica-1", start)
+
+	elector.step(ctx)
+	if !leading(elector) {
+		t.Fatalf("replica-1 did not acquire the Lease")
+	}
+	leaderCtx, _ := elector.leader()
+
+	// API errors are tolerated until the renew deadline
+	api.setFailing(true)
+	clock.now = start.Add(9 * time.Second)
+	elector.step(ctx)
+	if !leading(elector) {
+		t.Fatalf("replica-1 stopped leading before the renew deadline")
+	}
+	clock.now = start.Add(11 * time.Second)
+	elector.step(ctx)
+	if leading(elector) || leaderCtx.Err() == nil {
+		t.Fatalf("replica-1 kept leading past the renew deadline")
+	}
+
+	// The Lease is still held by replica-1, so it is renewed once the API
+	// recovers
+	api.setFailing(false)
+	clock.now = start.Add(12 * time.Second)
+	elector.step(ctx)
+	if !leading(elector) {
+		t.Fatalf("replica-1 did not lead again once the API recovered")
+	}
+}
+
+func TestLeaderElectionReleaseOnShutdown(t *testing.T) {
+	api, client := newFakeLeaseAPI(t)
+	start := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	first, _ := testElector(client, "replica-1", start)
+	second, _ := testElector(client, "replica-2", start)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		first.run(ctx)
+		close(done)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); !leading(first); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("replica-1 did not acquire the Lease")
+		}
+	}
+	leaderCtx, _ := first.leader()
+	cancel()
+	<-done
+
+	if leaderCtx.Err() == nil || leading(first) {
+		t.Errorf("replica-1 kept leading after shutdown")
+	}
+	if holder := api.holder(); holder != "" {
+		t.Fatalf("the Lease is still held by %q", holder)
+	}
+	// Another replica takes the released Lease over right away
+	second.step(context.Background())
+	if !leading(second) {
+		t.Errorf("replica-2 did not acquire the released Lease")
+	}
+}
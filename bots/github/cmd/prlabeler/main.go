@@ -2,26 +2,26 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"maps"
+	"net/http"
 	"os"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/google/go-github/v76/github"
-	"golang.org/x/oauth2"
 
 	"k8s.io/klog/v2"
 )
 
 const (
-	konfluxAuthor                      = "red-hat-konflux[bot]"
-	ingvagabundAuthor                  = "ingvagabund"
-	updateKonfluxReferencesPRTitle     = "chore(deps): update konflux references"
-	updateKonfluxReferencesPRTitle2    = "Update Konflux references"
-	updateDockerfileBundlePRTitle      = "chore(deps): update"
-	updateDockerfileUbi9MinimalPRTitle = "chore(deps): update registry.access.redhat.com/ubi9/ubi-minimal:latest"
+	konfluxAuthor        = "red-hat-konflux[bot]"
+	ingvagabundAuthor    = "ingvagabund"
+	konfluxReferences    = "konflux references"
+	ubi9MinimalBaseImage = "registry.access.redhat.com/ubi9/ubi-minimal"
 )
 
 var label2comments = map[string]string{
@@ -32,8 +32,16 @@ var label2comments = map[string]string{
 	"approved":               "/approve",
 }
 
-func getChangedFiles(ctx context.Context, client *github.Client, owner, repo string, prNum int) ([]string, error) {
-	allFiles := []string{}
+func getChangedFiles(ctx context.Context, client *github.Client, owner, repo string, prNum int) ([]*github.CommitFile, error) {
+	if data := getPrefetched(owner, repo, prNum); data != nil && data.Files != nil {
+		return data.Files, nil
+	}
+	return listChangedFiles(ctx, client, owner, repo, prNum)
+}
+
+// listChangedFiles lists the changed files including their patches.
+func listChangedFiles(ctx context.Context, client *github.Client, owner, repo string, prNum int) ([]*github.CommitFile, error) {
+	allFiles := []*github.CommitFile{}
 	listOpts := &github.ListOptions{PerPage: 100}
 
 	for {
@@ -42,9 +50,7 @@ func getChangedFiles(ctx context.Context, client *github.Client, owner, repo str
 			return nil, fmt.Errorf("error listing files for PR #%d: %v", prNum, err)
 		}
 
-		for _, file := range files {
-			allFiles = append(allFiles, file.GetFilename())
-		}
+		allFiles = append(allFiles, files...)
 
 		if resp.NextPage == 0 {
 			break
@@ -55,40 +61,35 @@ func getChangedFiles(ctx context.Context, client *github.Client, owner, repo str
 	return allFiles, nil
 }
 
-func validateUpdateKonfluxReferences(files []string) bool {
-	for _, file := range files {
-		if !strings.HasPrefix(file, ".tekton") {
-			return false
-		}
-		if !strings.HasSuffix(file, ".yaml") {
-			return false
-		}
-		if strings.HasSuffix(file, "images-mirror-set.yaml") {
-			return false
+// withPatches returns the changes including their patches. Bulk fetched
+// files come without patches and are listed again.
+func withPatches(ctx context.Context, client *github.Client, pr *github.PullRequest, changes []*github.CommitFile) ([]*github.CommitFile, error) {
+	for _, change := range changes {
+		if change.Patch == nil {
+			return listChangedFiles(ctx, client, pr.GetBase().GetRepo().GetOwner().GetLogin(), pr.GetBase().GetRepo().GetName(), pr.GetNumber())
 		}
 	}
-	return true
+	return changes, nil
 }
 
-func validateUpdateBundleImageShas(files []string) bool {
-	for _, file := range files {
-		if file != "bundle.Dockerfile" {
-			return false
-		}
+// getFileContent returns content of a file at the given git reference.
+func getFileContent(ctx context.Context, client *github.Client, owner, repo, path, ref string) (string, error) {
+	fileContent, _, _, err := client.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{Ref: ref})
+	if err != nil {
+		return "", fmt.Errorf("error getting %v at %v: %w", path, ref, err)
+	}
+	if fileContent == nil {
+		return "", fmt.Errorf("%v at %v is not a file", path, ref)
 	}
-	return true
+	return fileContent.GetContent()
 }
 
-func validateUpdateUbi9MinimalBaseImage(files []string) bool {
-	for _, file := range files {
-		if file != "bundle.Dockerfile" && file != "Dockerfile" {
-			return false
-		}
-	}
-	return true
+func isNotFound(err error) bool {
+	var errResp *github.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound
 }
 
-func ensurePRLabels(ctx context.Context, client *github.Client, owner, repo string, prNum int, pr *github.PullRequest, labels []string) error {
+func missingPRLabels(pr *github.PullRequest, labels []string) []string {
 	existingLabels := make(map[string]struct{})
 	for _, label := range pr.Labels {
 		existingLabels[label.GetName()] = struct{}{}
@@ -100,7 +101,11 @@ func ensurePRLabels(ctx context.Context, client *github.Client, owner, repo stri
 			mustHaveLabels = append(mustHaveLabels, label)
 		}
 	}
+	return mustHaveLabels
+}
 
+func ensurePRLabels(ctx context.Context, client *github.Client, owner, repo string, prNum int, pr *github.PullRequest, labels []string) error {
+	mustHaveLabels := missingPRLabels(pr, labels)
 	if len(mustHaveLabels) == 0 {
 		klog.InfoS("No missing labels for PR", "number", prNum)
 		return nil
@@ -132,7 +137,34 @@ func ensurePRCommentBasedLabel(ctx context.Context, client *github.Client, owner
 	return err
 }
 
-func getLatestRetestComment(ctx context.Context, client *github.Client, organization, repository string, prNum int) (*github.IssueComment, error) {
+// ensureReportComment reports why a rule is not valid. The report is posted
+// once per rule and PR head.
+func ensureReportComment(ctx context.Context, client *github.Client, owner, repo string, prNum int, pr *github.PullRequest, rule ruleTrace) error {
+	marker := fmt.Sprintf("<!-- prlabeler report: %v %v -->", rule.Name, pr.GetHead().GetSHA())
+	comments, err := getPRComments(ctx, client, owner, repo, prNum)
+	if err != nil {
+		return err
+	}
+	for _, comment := range comments {
+		if strings.Contains(comment.GetBody(), marker) {
+			klog.InfoS("Report already posted", "number", prNum, "rule", rule.Name)
+			return nil
+		}
+	}
+
+	klog.InfoS("Reporting on PR", "number", prNum, "rule", rule.Name)
+	comment := &github.IssueComment{
+		Body: github.String(rule.Report + "\n\n" + marker),
+	}
+	_, _, err = client.Issues.CreateComment(ctx, owner, repo, prNum, comment)
+	return err
+}
+
+func getPRComments(ctx context.Context, client *github.Client, organization, repository string, prNum int) ([]*github.IssueComment, error) {
+	if data := getPrefetched(organization, repository, prNum); data != nil && data.Comments != nil {
+		return data.Comments, nil
+	}
+
 	listCommentsOpts := &github.IssueListCommentsOptions{
 		Sort:      github.String("created"),
 		Direction: github.String("desc"),
@@ -141,7 +173,7 @@ func getLatestRetestComment(ctx context.Context, client *github.Client, organiza
 		},
 	}
 
-	var retestComment *github.IssueComment
+	allComments := []*github.IssueComment{}
 
 	for {
 		// Note: We use the Issues service because GitHub treats PR comments as Issue comments.
@@ -150,18 +182,7 @@ func getLatestRetestComment(ctx context.Context, client *github.Client, organiza
 			return nil, fmt.Errorf("Error listing comments (Page %d): %v", listCommentsOpts.Page, err)
 		}
 
-		// --- Iterate over comments on the current page ---
-		for _, comment := range comments {
-			body := comment.GetBody()
-			if len(body) > 20 {
-				body = body[:20] + "..."
-			}
-			if strings.HasPrefix(body, "/retest") { // || strings.HasPrefix(body, "/retest-required") {
-				if retestComment == nil || comment.CreatedAt.GetTime().After(*retestComment.CreatedAt.GetTime()) {
-					retestComment = comment
-				}
-			}
-		}
+		allComments = append(allComments, comments...)
 
 		// --- Check for next page ---
 		if resp.NextPage == 0 {
@@ -172,14 +193,141 @@ func getLatestRetestComment(ctx context.Context, client *github.Client, organiza
 		listCommentsOpts.Page = resp.NextPage
 	}
 
+	return allComments, nil
+}
+
+func getLatestRetestComment(ctx context.Context, client *github.Client, organization, repository string, prNum int) (*github.IssueComment, error) {
+	comments, err := getPRComments(ctx, client, organization, repository, prNum)
+	if err != nil {
+		return nil, err
+	}
+
+	var retestComment *github.IssueComment
+	for _, comment := range comments {
+		body := comment.GetBody()
+		if len(body) > 20 {
+			body = body[:20] + "..."
+		}
+		if strings.HasPrefix(body, "/retest") { // || strings.HasPrefix(body, "/retest-required") {
+			if retestComment == nil || comment.CreatedAt.GetTime().After(*retestComment.CreatedAt.GetTime()) {
+				retestComment = comment
+			}
+		}
+	}
+
 	return retestComment, nil
 }
 
-func getTestsToRerun(ctx context.Context, client *github.Client, organization, repository string, prNum int, pr *github.PullRequest) (map[string]string, []string, error) {
-	// testName -> comment
-	testsToRetry := make(map[string]string)
-	overrides := []string{}
+// statusClass describes how a commit status context is treated when deciding
+// about retests and overrides.
+type statusClass string
 
+const (
+	statusPendingTooLong   statusClass = "pending too long"
+	statusRecentlyRetested statusClass = "recently retested"
+	statusOverrideEligible statusClass = "override-eligible"
+	statusBaseBranchBroken statusClass = "base branch broken"
+	statusIgnored          statusClass = "ignored"
+	retestInterval                     = 4 * time.Hour
+)
+
+type statusTrace struct {
+	Context     string
+	State       string
+	Description string
+	TargetURL   string
+	UpdatedAt   time.Time
+	Class       statusClass
+	Reason      string
+}
+
+// classifyStatuses classifies the latest status of each context without
+// performing any action.
+func classifyStatuses(statuses []*github.RepoStatus, lastRetest time.Time, now time.Time, policy *BranchPolicy) []statusTrace {
+	latestStatuses := make(map[string]*github.RepoStatus)
+	contexts := []string{}
+	for _, status := range statuses {
+		contextName := status.GetContext()
+		if _, exists := latestStatuses[contextName]; !exists {
+			latestStatuses[contextName] = status
+			contexts = append(contexts, contextName)
+		}
+	}
+
+	traces := []statusTrace{}
+	for _, contextName := range contexts {
+		status := latestStatuses[contextName]
+		trace := statusTrace{
+			Context:     status.GetContext(),
+			State:       status.GetState(),
+			Description: status.GetDescription(),
+			TargetURL:   status.GetTargetURL(),
+			UpdatedAt:   status.GetUpdatedAt().Time,
+			Class:       statusIgnored,
+		}
+		switch status.GetState() {
+		case "pending":
+			switch {
+			case status.UpdatedAt.GetTime() == nil:
+				trace.Reason = "no update time"
+			case !strings.Contains(status.GetDescription(), "Job Red Hat Konflux"):
+				trace.Reason = "not a Konflux job"
+			case !status.UpdatedAt.GetTime().Add(retestInterval).Before(now):
+				// any test pending for more than 4 hours -> retry
+				trace.Reason = fmt.Sprintf("pending for %v only", now.Sub(*status.UpdatedAt.GetTime()).Round(time.Minute))
+			case !lastRetest.IsZero() && !lastRetest.Add(retestInterval).Before(now):
+				trace.Class = statusRecentlyRetested
+				trace.Reason = fmt.Sprintf("retested at %v", lastRetest)
+			default:
+				trace.Class = statusPendingTooLong
+				trace.Reason = fmt.Sprintf("pending since %v", status.UpdatedAt.GetTime())
+			}
+		case "failure":
+			if policy.overridable(status.GetContext()) {
+				trace.Class = statusOverrideEligible
+			} else {
+				trace.Reason = "context can not be overridden"
+			}
+		default:
+			trace.Reason = fmt.Sprintf("state %v", status.GetState())
+		}
+		traces = append(traces, trace)
+	}
+	return traces
+}
+
+func getCommitStatuses(ctx context.Context, client *github.Client, organization, repository string, prNum int, sha string) ([]*github.RepoStatus, error) {
+	if data := getPrefetched(organization, repository, prNum); data != nil {
+		return data.Statuses, nil
+	}
+	statuses, err := listStatuses(ctx, client, organization, repository, sha)
+	if err != nil {
+		return nil, fmt.Errorf("Could not list older commit statuses: %v", err)
+	}
+	return statuses, nil
+}
+
+// listStatuses lists all the statuses of the ref, newest first. Unlike the
+// GraphQL status rollup holding the latest status of each context only, it
+// returns the whole history.
+func listStatuses(ctx context.Context, client *github.Client, organization, repository, ref string) ([]*github.RepoStatus, error) {
+	opts := &github.ListOptions{PerPage: 100}
+	allStatuses := []*github.RepoStatus{}
+	for {
+		statuses, resp, err := client.Repositories.ListStatuses(ctx, organization, repository, ref, opts)
+		if err != nil {
+			return nil, fmt.Errorf("Error listing statuses (Page %d): %v", opts.Page, err)
+		}
+		allStatuses = append(allStatuses, statuses...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return allStatuses, nil
+}
+
+func getStatusTraces(ctx context.Context, client *github.Client, organization, repository string, prNum int, pr *github.PullRequest, policy *BranchPolicy) ([]statusTrace, error) {
 	headSHA := pr.GetHead().GetSHA()
 
 	// opts := &github.ListCheckRunsOptions{
@@ -200,80 +348,142 @@ func getTestsToRerun(ctx context.Context, client *github.Client, organization, r
 	// 	opts.Page = resp.NextPage
 	// }
 
+	// Retests posted by prlabeler are kept in the PR state, retests posted
+	// by humans (and by stateless runs) are found in the PR comments
+	state, err := loadPRState(ctx, client, pr)
+	if err != nil {
+		return nil, err
+	}
+	var lastRetest time.Time
 	retestGHComment, err := getLatestRetestComment(ctx, client, organization, repository, prNum)
 	if err != nil {
-		return nil, nil, fmt.Errorf("Error getting the latest retest comment: %v", err)
+		return nil, fmt.Errorf("Error getting the latest retest comment: %v", err)
+	}
+	if retestGHComment != nil {
+		lastRetest = retestGHComment.GetCreatedAt().Time
+	}
+	if state != nil && state.lastPosted("/retest").After(lastRetest) {
+		lastRetest = state.lastPosted("/retest")
 	}
 
 	// List the older style statuses for the commit
-	statuses, _, err := client.Repositories.ListStatuses(ctx, organization, repository, headSHA, nil)
+	statuses, err := getCommitStatuses(ctx, client, organization, repository, prNum, headSHA)
 	if err != nil {
-		return nil, nil, fmt.Errorf("Could not list older commit statuses: %v", err)
+		return nil, err
 	}
 
-	latestStatuses := make(map[string]*github.RepoStatus)
-	for _, status := range statuses {
-		contextName := status.GetContext()
-		if _, exists := latestStatuses[contextName]; !exists {
-			latestStatuses[contextName] = status
-		}
+	traces := classifyStatuses(statuses, lastRetest, runClock.Now(), policy)
+	if err := classifyBaseBranch(ctx, client, organization, repository, pr, traces, runClock.Now()); err != nil {
+		return nil, err
 	}
+	classifyFailures(ctx, traces)
+	return traces, nil
+}
 
-	for _, status := range latestStatuses {
-		fmt.Printf("Status (Legacy): %s | State: %s | Context: %s | UpdatedAt: %s\n",
-			status.GetDescription(), status.GetState(), status.GetContext(), *status.UpdatedAt.GetTime())
-		switch status.GetState() {
-		case "pending":
-			if status.UpdatedAt.GetTime() != nil {
-				if !strings.Contains(status.GetDescription(), "Job Red Hat Konflux") {
-					continue
-				}
-				// any test pending for more than 4 hours -> retry
-				now := time.Now()
-				if status.UpdatedAt.GetTime().Add(4 * time.Hour).Before(now) {
-					if retestGHComment != nil && retestGHComment.CreatedAt.GetTime().Add(4*time.Hour).After(now) {
-						klog.InfoS("PR was retested in less than 4 hours ago", "number", prNum, "delta", retestGHComment.CreatedAt.GetTime().Add(4*time.Hour).Sub(now))
-					}
+func getTestsToRerun(prNum int, traces []statusTrace) (map[string]string, []string) {
+	// testName -> comment
+	testsToRetry := make(map[string]string)
+	overrides := []string{}
 
-					if retestGHComment == nil {
-						testsToRetry[status.GetDescription()] = "/retest"
-					} else if retestGHComment != nil && retestGHComment.CreatedAt.GetTime().Add(4*time.Hour).Before(now) {
-						testsToRetry[status.GetDescription()] = "/retest"
-					}
-				}
-			}
-		case "failure":
-			switch status.GetContext() {
-			case "ci/prow/unit", "ci/prow/images", "ci/prow/e2e-aws-operator", "ci/prow/verify":
-				// testsToRetry[status.GetDescription()] = "/retest-required"
-				overrides = append(overrides, status.GetContext())
-			}
+	for _, trace := range traces {
+		klog.V(2).InfoS("Status", "number", prNum, "context", trace.Context, "state", trace.State, "description", trace.Description, "updatedAt", trace.UpdatedAt)
+		switch trace.Class {
+		case statusPendingTooLong:
+			testsToRetry[trace.Description] = "/retest"
+		case statusRecentlyRetested:
+			klog.InfoS("PR was retested in less than 4 hours ago", "number", prNum, "context", trace.Context)
+		case statusOverrideEligible:
+			// testsToRetry[trace.Description] = "/retest-required"
+			overrides = append(overrides, trace.Context)
+		case statusBaseBranchBroken:
+			klog.InfoS("Base branch broken, holding off retests and overrides", "number", prNum, "context", trace.Context, "reason", trace.Reason)
 		}
 	}
 
-	return testsToRetry, overrides, nil
+	return testsToRetry, overrides
 }
 
-func reconcilePR(ctx context.Context, client *github.Client, organization, repository string, prNum int, pr *github.PullRequest, label2comments map[string]string) {
-	// Set the right labels
-	if err := ensurePRLabels(ctx, client, organization, repository, prNum, pr, []string{"jira/valid-bug", "jira/valid-reference"}); err != nil {
-		klog.Errorf("Error labeling PR: %v", err)
+// prActions restricts what reconcilePR is allowed to do.
+type prActions struct {
+	// Approve allows approving labels and overrides
+	Approve bool
+	// Comment allows comment generating actions
+	Comment bool
+}
+
+// reconcilePR performs all the actions it can and returns errors of those
+// that failed.
+func reconcilePR(ctx context.Context, client *github.Client, organization, repository string, prNum int, pr *github.PullRequest, policy *BranchPolicy, labels []string, label2comments map[string]string, actions prActions) error {
+	errs := []error{}
+	traces, err := getStatusTraces(ctx, client, organization, repository, prNum, pr, policy)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("Error getting tests to run: %v", err))
+	}
+	sha := pr.GetHead().GetSHA()
+	// Commands already posted and the approved head are taken from the PR
+	// state, stateless runs rely on PR comments
+	state, loadErr := loadPRState(ctx, client, pr)
+	if loadErr != nil {
+		errs = append(errs, fmt.Errorf("Error loading the PR state: %v", loadErr))
+	}
+	if err := updatePRState(ctx, client, pr, func(state *prState) { recordFailures(state, sha, traces) }); err != nil {
+		errs = append(errs, fmt.Errorf("Error recording failures: %v", err))
+	}
+	if missing := policy.missingRequiredContexts(traces); len(missing) > 0 {
+		klog.InfoS("Required contexts not succeeded, withholding approvals", "number", prNum, "policy", policy.Name, "contexts", missing)
+		actions.Approve = false
+		labels, label2comments = withoutApprovals(labels, label2comments)
+	}
+
+	// Set the right labels. The approved head is recorded only for approvals
+	// that were granted, so they can be revoked once the PR changes.
+	granted := []string{}
+	if err := ensurePRLabels(ctx, client, organization, repository, prNum, pr, labels); err != nil {
+		errs = append(errs, fmt.Errorf("Error labeling PR: %v", err))
+	} else {
+		granted = append(granted, labels...)
+	}
+	if !actions.Comment {
+		if err := recordGrantedApprovals(ctx, client, organization, repository, pr, state, granted); err != nil {
+			errs = append(errs, fmt.Errorf("Error recording the approval: %v", err))
+		}
+		klog.InfoS("Outside of active hours, skipping comments", "number", prNum)
+		return errors.Join(errs...)
 	}
 	// Produce the right labels through comments
+	posted := []string{}
 	for targetLabel, targetComment := range label2comments {
+		if state != nil && state.posted(sha, targetComment) {
+			klog.InfoS("Command already posted on the PR head", "number", prNum, "comment", targetComment)
+			granted = append(granted, targetLabel)
+			continue
+		}
 		if err := ensurePRCommentBasedLabel(ctx, client, organization, repository, prNum, pr, targetLabel, targetComment); err != nil {
-			klog.Errorf("Error ensuring %q label: %v", targetLabel, err)
+			errs = append(errs, fmt.Errorf("Error ensuring %q label: %v", targetLabel, err))
+			continue
+		}
+		granted = append(granted, targetLabel)
+		if len(missingPRLabels(pr, []string{targetLabel})) > 0 {
+			posted = append(posted, targetComment)
 		}
 	}
-	fmt.Printf("reconcilePR\n")
-	testsToRetry, overrides, err := getTestsToRerun(ctx, client, organization, repository, prNum, pr)
-	if err != nil {
-		klog.Errorf("Error getting tests to run: %v", err)
-	} else {
+	if err := recordGrantedApprovals(ctx, client, organization, repository, pr, state, granted); err != nil {
+		errs = append(errs, fmt.Errorf("Error recording the approval: %v", err))
+	}
+	if len(posted) > 0 {
+		if err := updatePRState(ctx, client, pr, func(state *prState) {
+			for _, command := range posted {
+				recordCommand(state, sha, command)
+			}
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("Error recording commands: %v", err))
+		}
+	}
+	if err == nil {
+		testsToRetry, overrides := getTestsToRerun(prNum, traces)
 		retestComment := ""
-		fmt.Printf("Tests to retry:\n")
 		for testName := range testsToRetry {
-			fmt.Printf("\t%v: %v\n", testName, testsToRetry[testName])
+			klog.V(2).InfoS("Test to retry", "number", prNum, "test", testName, "comment", testsToRetry[testName])
 			if testsToRetry[testName] == "/retest" {
 				retestComment = "/retest"
 				break
@@ -287,37 +497,362 @@ func reconcilePR(ctx context.Context, client *github.Client, organization, repos
 			klog.InfoS("Adding comment to PR", "number", prNum, "comment", retestComment)
 			_, _, err := client.Issues.CreateComment(ctx, organization, repository, prNum, &github.IssueComment{Body: github.String(retestComment)})
 			if err != nil {
-				klog.Errorf("Error adding a comment: %v", err)
+				errs = append(errs, fmt.Errorf("Error adding a comment: %v", err))
+			} else if err := updatePRState(ctx, client, pr, func(state *prState) {
+				if state.Retests == nil {
+					state.Retests = make(map[string]int)
+				}
+				for testName := range testsToRetry {
+					state.Retests[testName]++
+				}
+				recordCommand(state, sha, retestComment)
+			}); err != nil {
+				errs = append(errs, fmt.Errorf("Error recording the retest: %v", err))
 			}
 		}
+		if !actions.Approve && len(overrides) > 0 {
+			klog.InfoS("Skipping overrides for an untrusted PR", "number", prNum, "overrides", overrides)
+			overrides = nil
+		}
 		// apply overrides
+		overridden := []string{}
 		for _, override := range overrides {
 			overrideComment := fmt.Sprintf("/override %v", override)
+			if state != nil && state.posted(sha, overrideComment) {
+				klog.InfoS("Override already posted on the PR head", "number", prNum, "context", override)
+				continue
+			}
 			klog.InfoS("Adding comment to PR", "number", prNum, "comment", overrideComment)
 			_, _, err := client.Issues.CreateComment(ctx, organization, repository, prNum, &github.IssueComment{Body: github.String(overrideComment)})
 			if err != nil {
-				klog.Errorf("Error adding a comment: %v", err)
+				errs = append(errs, fmt.Errorf("Error adding a comment: %v", err))
+				continue
+			}
+			overridden = append(overridden, override)
+		}
+		if len(overridden) > 0 {
+			sort.Strings(overridden)
+			notifications.notify(eventOverride, pr, sha+" "+strings.Join(overridden, ","), overridden...)
+			if err := updatePRState(ctx, client, pr, func(state *prState) {
+				for _, override := range overridden {
+					recordCommand(state, sha, fmt.Sprintf("/override %v", override))
+				}
+			}); err != nil {
+				errs = append(errs, fmt.Errorf("Error recording overrides: %v", err))
 			}
 		}
 	}
+	return errors.Join(errs...)
 }
 
-func inspectRepository(ctx context.Context, client *github.Client, organization, repository string, allowedAuthors map[string]bool) {
-	klog.Infof("Fetching open Pull Requests for %s/%s...", organization, repository)
+// ruleTrace records how a single reconciliation rule was evaluated for a PR.
+type ruleTrace struct {
+	Name          string
+	Matched       bool
+	Validator     string
+	Valid         bool
+	OffendingFile string
+	// All files violating the expected change set
+	OffendingFiles []string
+	// Additional details backing the verdict
+	Reasons []string
+	// Labels and comment based labels the rule requires when it applies
+	Labels         []string
+	Label2Comments map[string]string
+	// Comment posted on the PR when the rule matches but is not valid
+	Report string
+}
+
+func (r ruleTrace) applies() bool {
+	return r.Matched && r.Valid
+}
+
+// prDecision is the outcome of evaluating a PR against all the rules.
+type prDecision struct {
+	Organization  string
+	Repository    string
+	Number        int
+	Author        string
+	Title         string
+	AuthorAllowed bool
+	// Bot profile of the author and the metadata parsed with it
+	Profile  string
+	Metadata *prMetadata
+	Files    []string
+	Changes  []*github.CommitFile `json:"-"`
+	Rules    []ruleTrace
+	// Reasons for withholding all approvals (lgtm, approve, overrides)
+	TrustViolations []string
+	// Name of the change freeze the PR falls into
+	Freeze string
+	// Whether comment generating actions are allowed at the moment
+	CommentsAllowed bool
+	// Policy of the PR base branch
+	Policy *BranchPolicy
+	// Prow commands the bot can issue for the changed files, resolved only
+	// when a rule applies
+	Owners *ownersCoverage
+	// PR head recorded at the last approval, looked up only when the PR has
+	// approval labels
+	ApprovedSHA string
+	// Approvals granted to the approved head, nil when not recorded
+	ApprovedLabels []string
+	// State kept between runs, nil for stateless runs
+	State *prState
+}
+
+// evaluateValidatorRule evaluates a rule judged by a validator. The
+// validator runs only when the rule matches.
+func evaluateValidatorRule(ctx context.Context, name string, matched bool, input *validationInput, v validator, policy *BranchPolicy) (ruleTrace, error) {
+	rule := ruleTrace{
+		Name:           name,
+		Matched:        matched,
+		Validator:      v.Name(),
+		Labels:         policy.Labels,
+		Label2Comments: policy.CommentLabels,
+	}
+	if !rule.Matched {
+		return rule, nil
+	}
+	result, err := v.Validate(ctx, input)
+	if err != nil {
+		return rule, fmt.Errorf("%v: %v: %v", name, v.Name(), err)
+	}
+	rule.Valid = result.Valid
+	rule.OffendingFiles = result.OffendingFiles
+	if len(result.OffendingFiles) > 0 {
+		rule.OffendingFile = result.OffendingFiles[0]
+	}
+	rule.Reasons = result.Reasons
+	return rule, nil
+}
+
+// evaluateDependencyRules evaluates rules for PRs of dependency bots. The
+// rules match on the metadata parsed from the PR.
+func evaluateDependencyRules(ctx context.Context, client *github.Client, pr *github.PullRequest, profile *botProfile, metadata *prMetadata, changes []*github.CommitFile, files []string, policy *BranchPolicy) ([]ruleTrace, error) {
+	// Only PRs either changing just .tekton files or just Dockerfiles
+	input := &validationInput{client: client, pr: pr, changes: changes}
+	rules := []ruleTrace{}
+	for _, builtin := range []struct {
+		name      string
+		matched   bool
+		validator validator
+	}{
+		{"tekton files update", metadata.Dependency == konfluxReferences, konfluxReferencesValidator},
+		// Digest bumps of images referenced by the bundle
+		{"bundle.Dockerfile update", metadata.Digest && slices.Contains(files, "bundle.Dockerfile"), bundleImageShasValidator},
+		{"ubi9-minimal base image update", strings.HasPrefix(metadata.Dependency, ubi9MinimalBaseImage), ubi9MinimalBaseImageValidator},
+	} {
+		rule, err := evaluateValidatorRule(ctx, builtin.name, builtin.matched, input, builtin.validator, policy)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	rule, err := evaluateGoModuleBumpRule(ctx, client, pr, isGoModuleUpdate(metadata, files), changes, policy)
+	if err != nil {
+		return nil, err
+	}
+	rules = append(rules, rule)
+
+	for i := range rules {
+		if rules[i].Name == "bundle.Dockerfile update" {
+			if err := evaluateBundleConsistency(ctx, client, pr, &rules[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if config.Spec.ImageVerification.Enabled {
+		if err := verifyRuleImages(ctx, client, pr, rules, changes); err != nil {
+			return nil, err
+		}
+	}
+
+	if file := profile.disallowedFile(files); file != "" {
+		for i := range rules {
+			if rules[i].Matched {
+				rules[i].Valid = false
+				rules[i].OffendingFile = file
+				rules[i].Reasons = append(rules[i].Reasons, fmt.Sprintf("%v is outside of the %v file set", file, profile.Name))
+				for _, file := range files {
+					if !matchAnyFileGlob(profile.AllowedFiles, file) && !slices.Contains(rules[i].OffendingFiles, file) {
+						rules[i].OffendingFiles = append(rules[i].OffendingFiles, file)
+					}
+				}
+			}
+		}
+	}
+	return rules, nil
+}
+
+// evaluateConfiguredRules evaluates the rules of the configuration file.
+func evaluateConfiguredRules(ctx context.Context, client *github.Client, pr *github.PullRequest, changes []*github.CommitFile, policy *BranchPolicy) ([]ruleTrace, error) {
+	input := &validationInput{client: client, pr: pr, changes: changes}
+	rules := []ruleTrace{}
+	for _, spec := range config.Spec.Rules {
+		v, err := spec.Validator.build()
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %v", spec.Name, err)
+		}
+		matched := len(spec.Authors) == 0 || matchAnyGlob(spec.Authors, pr.GetUser().GetLogin())
+		if spec.Title != "" {
+			matched = matched && regexp.MustCompile(spec.Title).MatchString(pr.GetTitle())
+		}
+		rule, err := evaluateValidatorRule(ctx, spec.Name, matched, input, v, policy)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// evaluatePR decides which rules apply to a PR. It only reads from GitHub.
+func evaluatePR(ctx context.Context, client *github.Client, organization, repository string, pr *github.PullRequest, allowedAuthors map[string]bool) (*prDecision, error) {
+	decision := &prDecision{
+		Organization:  organization,
+		Repository:    repository,
+		Number:        pr.GetNumber(),
+		Author:        pr.GetUser().GetLogin(),
+		Title:         pr.GetTitle(),
+		AuthorAllowed: allowedAuthors[pr.GetUser().GetLogin()],
+	}
+
+	now := runClock.Now()
+	decision.Freeze = config.Spec.Schedule.activeFreeze(organization, repository, pr.GetBase().GetRef(), now)
+	decision.CommentsAllowed = config.Spec.Schedule.commentsAllowed(now)
+	decision.Policy = branchPolicy(config, pr.GetBase().GetRef())
+
+	if !decision.AuthorAllowed {
+		return decision, nil
+	}
+
+	if states != nil {
+		state, err := states.Load(ctx, client, pr)
+		if err != nil {
+			return nil, err
+		}
+		decision.State = state
+	}
+
+	changes, err := getChangedFiles(ctx, client, organization, repository, decision.Number)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing files: %v", err)
+	}
+	files := []string{}
+	for _, change := range changes {
+		files = append(files, change.GetFilename())
+	}
+	decision.Files = files
+	decision.Changes = changes
+
+	if len(files) == 0 {
+		return decision, nil
+	}
+
+	if profile := profileForAuthor(decision.Author, botProfileNames); profile != nil {
+		// Bots are trusted only when nobody else pushed to their PRs
+		commits, err := getPRCommits(ctx, client, organization, repository, decision.Number)
+		if err != nil {
+			return nil, err
+		}
+		decision.TrustViolations = commitAuthorshipViolations(pr, commits, decision.Author)
 
+		decision.Profile = profile.Name
+		decision.Metadata = profile.parseMetadata(decision.Title, pr.GetBody())
+		rules, err := evaluateDependencyRules(ctx, client, pr, profile, decision.Metadata, changes, files, decision.Policy)
+		if err != nil {
+			return nil, err
+		}
+		decision.Rules = append(decision.Rules, rules...)
+	} else if decision.Author == ingvagabundAuthor {
+		decision.Rules = append(decision.Rules, ruleTrace{
+			Name:           "[auto] summary",
+			Matched:        strings.Contains(decision.Title, "[auto]"),
+			Valid:          true,
+			Labels:         append(slices.Clone(decision.Policy.Labels), "lgtm"),
+			Label2Comments: withoutCommentLabel(decision.Policy.CommentLabels, "lgtm"),
+		})
+	}
+
+	rules, err := evaluateConfiguredRules(ctx, client, pr, changes, decision.Policy)
+	if err != nil {
+		return nil, err
+	}
+	decision.Rules = append(decision.Rules, rules...)
+
+	for _, rule := range decision.Rules {
+		if rule.applies() {
+			if decision.Owners, err = getOwnersCoverage(ctx, client, pr, files); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	// Approvals recorded before the state backend was configured are only
+	// found in the PR comments
+	switch {
+	case len(presentApprovals(pr)) == 0:
+	case decision.State != nil && decision.State.ApprovedSHA != "":
+		decision.ApprovedSHA = decision.State.ApprovedSHA
+		decision.ApprovedLabels = decision.State.ApprovedLabels
+	default:
+		comments, err := getPRComments(ctx, client, organization, repository, decision.Number)
+		if err != nil {
+			return nil, err
+		}
+		decision.ApprovedSHA, decision.ApprovedLabels, _ = approvedSHA(comments)
+	}
+
+	return decision, nil
+}
+
+// listOpenPRs lists open PRs, through GraphQL with their data prefetched
+// when enabled.
+func listOpenPRs(ctx context.Context, client *github.Client, organization, repository string) ([]*github.PullRequest, error) {
+	if useGraphQL {
+		prs, err := prefetchOpenPRs(ctx, client, organization, repository)
+		if err == nil {
+			return prs, nil
+		}
+		klog.Errorf("Error fetching PRs through GraphQL, falling back to REST: %v", err)
+	}
 	opts := &github.PullRequestListOptions{
 		State:       "open",
 		Sort:        "updated",
 		Direction:   "desc",
 		ListOptions: github.ListOptions{PerPage: 100},
 	}
-
 	prs, _, err := client.PullRequests.List(ctx, organization, repository, opts)
 	if err != nil {
-		log.Fatalf("Error listing PRs: %v", err)
+		return nil, fmt.Errorf("Error listing PRs: %v", err)
+	}
+	return prs, nil
+}
+
+// inspectRepository reconciles all open PRs of a repository. Failures are
+// recorded in the summary, a failing PR does not stop the others.
+func inspectRepository(ctx context.Context, client *github.Client, organization, repository string, allowedAuthors map[string]bool, summary *runSummary) {
+	fullName := organization + "/" + repository
+	clear(loadedOwners)
+	clear(baseBranchStatuses)
+	klog.Infof("Fetching open Pull Requests for %s/%s...", organization, repository)
+
+	prs, err := listOpenPRs(ctx, client, organization, repository)
+	if err != nil {
+		summary.add(fullName, 0, err)
+		return
 	}
 
 	klog.Infof("Found %d open PRs.", len(prs))
+	openPRs := make(map[int]bool)
+	for _, pr := range prs {
+		openPRs[pr.GetNumber()] = true
+	}
+	defer func() {
+		summary.add(fullName, 0, collectPRStates(ctx, client, organization, repository, openPRs))
+	}()
 
 	for _, pr := range prs {
 		if pr.Number == nil || pr.User == nil || pr.User.Login == nil || pr.Title == nil {
@@ -329,91 +864,118 @@ func inspectRepository(ctx context.Context, client *github.Client, organization,
 
 		klog.InfoS("Processing PR", "number", prNum, "author", prAuthor, "title", *pr.Title)
 
-		if !allowedAuthors[prAuthor] {
-			continue
-		}
-
-		files, err := getChangedFiles(ctx, client, organization, repository, prNum)
+		decision, err := evaluatePR(ctx, client, organization, repository, pr, allowedAuthors)
 		if err != nil {
-			klog.Errorf("Error listing files: %v", err)
+			summary.add(fullName, prNum, err)
 			continue
 		}
 
-		if len(files) == 0 {
-			continue
+		actions := prActions{
+			Approve: len(decision.TrustViolations) == 0,
+			Comment: decision.CommentsAllowed,
+		}
+		if !actions.Approve {
+			klog.InfoS("Withholding approvals", "number", prNum, "reasons", decision.TrustViolations)
 		}
 
-		if prAuthor == konfluxAuthor {
-			// Only PRs either changing just .tekton files or just Dockerfiles
-			if strings.Contains(*pr.Title, updateKonfluxReferencesPRTitle) || strings.Contains(*pr.Title, updateKonfluxReferencesPRTitle2) {
-				if validateUpdateKonfluxReferences(files) {
-					reconcilePR(ctx, client, organization, repository, prNum, pr, label2comments)
-				} else {
-					klog.InfoS("validateUpdateKonfluxReferences: [false]")
+		// Only the first applying rule reconciles, a PR is reconciled once
+		reconciledBy := ""
+		for _, rule := range decision.Rules {
+			switch {
+			case rule.applies() && reconciledBy != "":
+				klog.InfoS("PR already reconciled", "number", prNum, "rule", rule.Name, "reconciledBy", reconciledBy)
+			case rule.applies() && decision.Freeze != "":
+				// Only report during a change freeze
+				klog.InfoS("Change freeze in effect, not reconciling", "number", prNum, "rule", rule.Name, "freeze", decision.Freeze)
+			case rule.applies():
+				reconciledBy = rule.Name
+				labels, comments := rule.Labels, rule.Label2Comments
+				if !actions.Approve {
+					labels, comments = withoutApprovals(labels, comments)
 				}
-			} else {
-				klog.Infof("PR does not resemble tekton files update")
-			}
-			if strings.Contains(*pr.Title, updateDockerfileBundlePRTitle) {
-				if validateUpdateBundleImageShas(files) {
-					reconcilePR(ctx, client, organization, repository, prNum, pr, label2comments)
-				} else {
-					klog.InfoS("validateUpdateBundleImageShas: [false]")
+				comments, requests := decision.Owners.authorizedCommands(comments)
+				summary.add(fullName, prNum, reconcilePR(ctx, client, organization, repository, prNum, pr, decision.Policy, labels, comments, actions))
+				if actions.Comment {
+					summary.add(fullName, prNum, ensureOwnersRequests(ctx, client, organization, repository, prNum, requests))
 				}
-			} else {
-				klog.Infof("PR does not resemble a bundle.Dockerfile update")
-			}
-			if strings.Contains(*pr.Title, updateDockerfileUbi9MinimalPRTitle) {
-				if validateUpdateUbi9MinimalBaseImage(files) {
-					reconcilePR(ctx, client, organization, repository, prNum, pr, label2comments)
-				} else {
-					klog.InfoS("validateUpdateUbi9MinimalBaseImage: [false]")
+			case rule.Matched:
+				klog.InfoS(fmt.Sprintf("%v: [false]", rule.Validator), "number", prNum, "file", rule.OffendingFile, "reasons", rule.Reasons)
+				if rule.Report != "" && actions.Comment {
+					summary.add(fullName, prNum, ensureReportComment(ctx, client, organization, repository, prNum, pr, rule))
 				}
-			} else {
-				klog.Infof("PR does not resemble a bundle.Dockerfile update")
+			default:
+				klog.Infof("PR does not resemble %v", rule.Name)
 			}
-		} else if prAuthor == ingvagabundAuthor {
-			if strings.Contains(*pr.Title, "[auto]") {
-				m := maps.Clone(label2comments)
-				delete(m, "lgtm")
-				reconcilePR(ctx, client, organization, repository, prNum, pr, m)
-				if err := ensurePRLabels(ctx, client, organization, repository, prNum, pr, []string{"jira/valid-bug", "jira/valid-reference", "lgtm"}); err != nil {
-					klog.Errorf("Error labeling PR: %v", err)
-				}
+		}
+
+		summary.add(fullName, prNum, revokeStaleApproval(ctx, client, organization, repository, pr, decision, actions))
+		if decision.Profile != "" {
+			summary.add(fullName, prNum, rejectPR(ctx, client, organization, repository, pr, decision, actions))
+		}
+
+		if decision.Profile != "" && actions.Comment && pr.GetState() != "closed" {
+			summary.add(fullName, prNum, escalatePR(ctx, client, organization, repository, pr))
+		}
+	}
+}
+
+func getAllowedAuthors() map[string]bool {
+	allowedAuthors := map[string]bool{}
+	for _, name := range botProfileNames {
+		if profile := getBotProfile(name); profile != nil {
+			for _, author := range profile.Authors {
+				allowedAuthors[author] = true
 			}
 		}
 	}
+
+	for _, author := range withAuthors {
+		allowedAuthors[author] = true
+	}
+	return allowedAuthors
 }
 
 func main() {
-	initFlags()
-	validateFlags()
+	runCommand(os.Args[1:])
+}
 
-	ctx := context.Background()
+// reconcile processes PRs of all the repositories once.
+func reconcile(ctx context.Context) *runSummary {
+	allowedAuthors := getAllowedAuthors()
 
-	token := os.Getenv("GITHUB_TOKEN")
-	if token == "" {
-		log.Fatal("Error: GITHUB_TOKEN environment variable is not set. Please set your Personal Access Token.")
+	summary := &runSummary{}
+	if notifications == nil && config.Spec.Notifications != nil {
+		n, err := newNotifier(config.Spec.Notifications)
+		if err != nil {
+			summary.add("notifications", 0, err)
+		}
+		notifications = n
 	}
-
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-	tc := oauth2.NewClient(ctx, ts)
-	client := github.NewClient(tc)
-
-	allowedAuthors := map[string]bool{
-		konfluxAuthor:     true,
+	if states == nil && config.Spec.State != nil {
+		store, err := newStateStore(config.Spec.State)
+		if err != nil {
+			summary.add("state", 0, err)
+		}
+		states = store
 	}
-
-	for _, author := range withAuthors {
-		allowedAuthors[ author ] = true
+	accessible := checkRepositoryAccess(ctx, summary)
+	if syncLabels {
+		syncAllLabels(ctx, accessible, summary)
 	}
 
-	for _, repo := range repositories {
+	for _, repo := range accessible {
 		items := strings.Split(repo, "/")
 		klog.InfoS("Processing repository", "organization", items[0], "repository", items[1])
-		inspectRepository(ctx, client, items[0], items[1], allowedAuthors)
+		client, err := newGitHubClient(ctx, items[0], items[1])
+		if err != nil {
+			summary.add(repo, 0, err)
+			continue
+		}
+		inspectRepository(ctx, client, items[0], items[1], allowedAuthors, summary)
 	}
-
+	if states != nil {
+		summary.add("state", 0, states.Flush(ctx))
+	}
+	summary.add("notifications", 0, notifications.flush(ctx))
+	return summary
 }
@@ -0,0 +1,162 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v76/github"
+)
+
+// fixedClock is a clock tests move forward explicitly.
+type fixedClock struct {
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time { return c.now }
+
+// setClock replaces runClock for the duration of the test.
+func setClock(t *testing.T, now time.Time) *fixedClock {
+	t.Helper()
+	previous := runClock
+	c := &fixedClock{now: now}
+	runClock = c
+	t.Cleanup(func() { runClock = previous })
+	return c
+}
+
+// fakeIssues serves the comments and labels of PRs of org/repo the way the
+// GitHub REST API does and records the writes.
+type fakeIssues struct {
+	mutex    sync.Mutex
+	nextID   int64
+	comments map[int][]*github.IssueComment
+	labels   map[int][]string
+	closed   map[int]bool
+	// Method and path of every write
+	writes []string
+}
+
+func newFakeIssues(t *testing.T) (*fakeIssues, *github.Client) {
+	f := &fakeIssues{
+		nextID:   100,
+		comments: make(map[int][]*github.IssueComment),
+		labels:   make(map[int][]string),
+		closed:   make(map[int]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/org/repo/issues/{number}/comments", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(f.commentsOf(pathNumber(r)))
+	})
+	mux.HandleFunc("POST /repos/org/repo/issues/{number}/comments", func(w http.ResponseWriter, r *http.Request) {
+		comment := &github.IssueComment{}
+		json.NewDecoder(r.Body).Decode(comment)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(f.addComment(pathNumber(r), comment.GetBody(), r))
+	})
+	mux.HandleFunc("PATCH /repos/org/repo/issues/comments/{id}", func(w http.ResponseWriter, r *http.Request) {
+		edited := &github.IssueComment{}
+		json.NewDecoder(r.Body).Decode(edited)
+		id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		comment := f.editComment(id, edited.GetBody(), r)
+		if comment == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(comment)
+	})
+	mux.HandleFunc("POST /repos/org/repo/issues/{number}/labels", func(w http.ResponseWriter, r *http.Request) {
+		labels := []string{}
+		json.NewDecoder(r.Body).Decode(&labels)
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		f.writes = append(f.writes, r.Method+" "+r.URL.Path)
+		f.labels[pathNumber(r)] = append(f.labels[pathNumber(r)], labels...)
+		json.NewEncoder(w).Encode([]*github.Label{})
+	})
+	mux.HandleFunc("DELETE /repos/org/repo/issues/{number}/labels/{label}", func(w http.ResponseWriter, r *http.Request) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		f.writes = append(f.writes, r.Method+" "+r.URL.Path)
+		f.labels[pathNumber(r)] = slices.DeleteFunc(f.labels[pathNumber(r)], func(label string) bool { return label == r.PathValue("label") })
+	})
+	mux.HandleFunc("PATCH /repos/org/repo/pulls/{number}", func(w http.ResponseWriter, r *http.Request) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		f.writes = append(f.writes, r.Method+" "+r.URL.Path)
+		f.closed[pathNumber(r)] = true
+		json.NewEncoder(w).Encode(&github.PullRequest{State: github.String("closed")})
+	})
+	return f, testGitHubClient(t, mux)
+}
+
+func pathNumber(r *http.Request) int {
+	number, _ := strconv.Atoi(r.PathValue("number"))
+	return number
+}
+
+func (f *fakeIssues) commentsOf(number int) []*github.IssueComment {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return slices.Clone(f.comments[number])
+}
+
+func (f *fakeIssues) addComment(number int, body string, r *http.Request) *github.IssueComment {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if r != nil {
+		f.writes = append(f.writes, r.Method+" "+r.URL.Path)
+	}
+	f.nextID++
+	comment := &github.IssueComment{
+		ID:        github.Int64(f.nextID),
+		Body:      github.String(body),
+		CreatedAt: &github.Timestamp{Time: runClock.Now()},
+	}
+	f.comments[number] = append(f.comments[number], comment)
+	return comment
+}
+
+func (f *fakeIssues) editComment(id int64, body string, r *http.Request) *github.IssueComment {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.writes = append(f.writes, r.Method+" "+r.URL.Path)
+	for _, comments := range f.comments {
+		for _, comment := range comments {
+			if comment.GetID() == id {
+				comment.Body = github.String(body)
+				return comment
+			}
+		}
+	}
+	return nil
+}
+
+// takeWrites returns the writes since the last call.
+func (f *fakeIssues) takeWrites() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	writes := f.writes
+	f.writes = nil
+	return writes
+}
@@ -0,0 +1,496 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/go-github/v76/github"
+	"k8s.io/klog/v2"
+)
+
+const (
+	eventOverride  = "override"
+	eventApproved  = "approved"
+	eventRejected  = "rejected"
+	eventClosed    = "closed"
+	eventRevoked   = "revoked"
+	eventEscalated = "escalated"
+	// Template of the daily digest
+	eventDigest = "digest"
+
+	defaultDigestAt = "09:00"
+)
+
+// Events of PRs that need humans, the others are prlabeler's activity
+var humanEvents = map[string]bool{
+	eventRejected:  true,
+	eventClosed:    true,
+	eventRevoked:   true,
+	eventEscalated: true,
+}
+
+var notificationEvents = []string{eventOverride, eventApproved, eventRejected, eventClosed, eventRevoked, eventEscalated}
+
+var defaultNotificationTemplates = map[string]string{
+	eventOverride:  "prlabeler overrode {{join .Details \", \"}} on {{.Repository}}#{{.Number}}\n{{.Title}}\n{{.URL}}\n",
+	eventApproved:  "prlabeler approved {{.Repository}}#{{.Number}} at {{.State}}\n{{.Title}}\n{{.URL}}\n",
+	eventRejected:  "{{.Repository}}#{{.Number}} fails validation and needs a human\n{{.Title}}\n{{.URL}}\n{{range .Details}}- {{.}}\n{{end}}",
+	eventClosed:    "prlabeler closed {{.Repository}}#{{.Number}}, it failed validation for too long\n{{.Title}}\n{{.URL}}\n",
+	eventRevoked:   "prlabeler revoked the approval of {{.Repository}}#{{.Number}}, it changed and fails validation\n{{.Title}}\n{{.URL}}\n{{range .Details}}- {{.}}\n{{end}}",
+	eventEscalated: "{{.Repository}}#{{.Number}} has not been green for long ({{.State}} escalation)\n{{.Title}}\n{{.URL}}\n{{range .Details}}- {{.}}\n{{end}}",
+	eventDigest: `prlabeler digest of {{.Date}}
+{{if .NeedsHumans}}
+PRs that need humans:
+{{range .NeedsHumans}}- {{.Repository}}#{{.Number}} ({{.Event}}): {{.Title}} {{.URL}}
+{{end}}{{end}}{{if .Activity}}
+Activity:
+{{range .Activity}}- {{.Repository}}#{{.Number}} ({{.Event}}{{if .Details}}: {{join .Details ", "}}{{end}}): {{.Title}} {{.URL}}
+{{end}}{{end}}`,
+}
+
+// notification announces an event of a PR.
+type notification struct {
+	Event      string    `json:"event"`
+	Repository string    `json:"repository"`
+	Number     int       `json:"number"`
+	Title      string    `json:"title"`
+	URL        string    `json:"url"`
+	State      string    `json:"state"`
+	Details    []string  `json:"details,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// digest is the data of the digest template.
+type digest struct {
+	Date        string
+	NeedsHumans []notification
+	Activity    []notification
+}
+
+// notificationState is kept across runs in the state file.
+type notificationState struct {
+	// Last announced state per event and PR
+	Announced map[string]string `json:"announced"`
+	// Notifications waiting to be sent, per sink
+	Queued map[string][]notification `json:"queued"`
+	// Notifications waiting for the next digest, per sink
+	Digests map[string][]notification `json:"digests"`
+	// When the last digest was sent, per sink
+	LastDigest map[string]time.Time `json:"lastDigest"`
+}
+
+// notificationSender delivers a rendered message.
+type notificationSender interface {
+	send(ctx context.Context, message string) error
+}
+
+// slackSender posts to a Slack compatible incoming webhook. The webhook URL
+// is read on every send so it can be rotated.
+type slackSender struct {
+	name       string
+	urlFile    string
+	httpClient *http.Client
+}
+
+func (s *slackSender) send(ctx context.Context, message string) error {
+	data, err := os.ReadFile(s.urlFile)
+	if err != nil {
+		return fmt.Errorf("error reading the webhook of %v: %v", s.name, err)
+	}
+	// The URL is a secret, it is redacted from cassettes
+	cassetteSecrets.add(strings.TrimSpace(string(data)))
+	body, err := json.Marshal(map[string]string{"text": message})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSpace(string(data)), bytes.NewReader(body))
+	if err != nil {
+		// The URL is a secret, it is not part of the error
+		return fmt.Errorf("invalid webhook of %v", s.name)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("error posting to the webhook of %v: %v", s.name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook of %v responded with %v", s.name, resp.Status)
+	}
+	return nil
+}
+
+// smtpSender sends emails. The first line of a message is the subject.
+type smtpSender struct {
+	name string
+	cfg  *SMTPNotification
+}
+
+func (s *smtpSender) send(_ context.Context, message string) error {
+	// SMTP is not HTTP and can not be replayed, replayed runs are offline
+	if replayer != nil {
+		klog.InfoS("Replaying a cassette, not sending email", "sink", s.name)
+		return nil
+	}
+	var auth smtp.Auth
+	if s.cfg.UsernameFile != "" {
+		username, err := os.ReadFile(s.cfg.UsernameFile)
+		if err != nil {
+			return fmt.Errorf("error reading the username of %v: %v", s.name, err)
+		}
+		password, err := os.ReadFile(s.cfg.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("error reading the password of %v: %v", s.name, err)
+		}
+		host, _, _ := net.SplitHostPort(s.cfg.Address)
+		auth = smtp.PlainAuth("", strings.TrimSpace(string(username)), strings.TrimSpace(string(password)), host)
+	}
+	subject, body, _ := strings.Cut(strings.TrimSpace(message), "\n")
+	email := fmt.Sprintf("From: %v\r\nTo: %v\r\nSubject: %v\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%v\r\n",
+		s.cfg.From, strings.Join(s.cfg.To, ", "), subject, strings.ReplaceAll(strings.TrimSpace(body), "\n", "\r\n"))
+	if err := smtp.SendMail(s.cfg.Address, auth, s.cfg.From, s.cfg.To, []byte(email)); err != nil {
+		return fmt.Errorf("error sending email through %v: %v", s.name, err)
+	}
+	return nil
+}
+
+// notifier routes PR events to sinks. Events are queued during a run and
+// sent by flush.
+type notifier struct {
+	cfg       *Notifications
+	templates map[string]*template.Template
+	senders   map[string]notificationSender
+	state     notificationState
+}
+
+// Notifier of the run, nil when notifications are not configured
+var notifications *notifier
+
+func parseNotificationTemplates(cfg *Notifications) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template)
+	funcs := template.FuncMap{"join": strings.Join}
+	for _, event := range slices.Concat(notificationEvents, []string{eventDigest}) {
+		text := defaultNotificationTemplates[event]
+		if custom, exists := cfg.Templates[event]; exists {
+			text = custom
+		}
+		tmpl, err := template.New(event).Funcs(funcs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("template %v: %v", event, err)
+		}
+		templates[event] = tmpl
+	}
+	return templates, nil
+}
+
+func newNotifier(cfg *Notifications) (*notifier, error) {
+	templates, err := parseNotificationTemplates(cfg)
+	if err != nil {
+		return nil, err
+	}
+	n := &notifier{
+		cfg:       cfg,
+		templates: templates,
+		senders:   make(map[string]notificationSender),
+		state: notificationState{
+			Announced:  make(map[string]string),
+			Queued:     make(map[string][]notification),
+			Digests:    make(map[string][]notification),
+			LastDigest: make(map[string]time.Time),
+		},
+	}
+	for _, sink := range cfg.Sinks {
+		if sink.Slack != nil {
+			n.senders[sink.Name] = &slackSender{name: sink.Name, urlFile: sink.Slack.WebhookURLFile, httpClient: &http.Client{Timeout: 30 * time.Second, Transport: cassetteTransport(http.DefaultTransport)}}
+		} else {
+			n.senders[sink.Name] = &smtpSender{name: sink.Name, cfg: sink.SMTP}
+		}
+	}
+
+	if cfg.StateFile == "" {
+		return n, nil
+	}
+	data, err := os.ReadFile(cfg.StateFile)
+	if os.IsNotExist(err) {
+		return n, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading notification state: %v", err)
+	}
+	if err := json.Unmarshal(data, &n.state); err != nil {
+		return nil, fmt.Errorf("error parsing notification state %v: %v", cfg.StateFile, err)
+	}
+	return n, nil
+}
+
+func (r NotificationRoute) matches(event, repository string) bool {
+	if len(r.Events) > 0 && !slices.Contains(r.Events, event) {
+		return false
+	}
+	return len(r.Repositories) == 0 || matchAnyGlob(r.Repositories, repository)
+}
+
+// notify queues an event of the PR for the routed sinks. A PR is announced
+// once per event and state, e.g. the PR head. Events no route matches are not
+// recorded as announced, so they are announced once a route is added.
+func (n *notifier) notify(event string, pr *github.PullRequest, state string, details ...string) {
+	if n == nil {
+		return
+	}
+	repository := pr.GetBase().GetRepo().GetOwner().GetLogin() + "/" + pr.GetBase().GetRepo().GetName()
+	key := fmt.Sprintf("%v %v#%v", event, repository, pr.GetNumber())
+	if announced, exists := n.state.Announced[key]; exists && announced == state {
+		return
+	}
+
+	item := notification{
+		Event:      event,
+		Repository: repository,
+		Number:     pr.GetNumber(),
+		Title:      pr.GetTitle(),
+		URL:        pr.GetHTMLURL(),
+		State:      state,
+		Details:    details,
+		Time:       runClock.Now(),
+	}
+	queued := make(map[string]bool)
+	for _, route := range n.cfg.Routes {
+		if !route.matches(event, repository) {
+			continue
+		}
+		for _, sink := range route.Sinks {
+			id := fmt.Sprintf("%v/%v", sink, route.Digest)
+			if queued[id] {
+				continue
+			}
+			queued[id] = true
+			klog.InfoS("Queuing notification", "event", event, "number", item.Number, "sink", sink, "digest", route.Digest)
+			if route.Digest {
+				n.state.Digests[sink] = append(n.state.Digests[sink], item)
+			} else {
+				n.state.Queued[sink] = append(n.state.Queued[sink], item)
+			}
+		}
+	}
+	if len(queued) > 0 {
+		n.state.Announced[key] = state
+	}
+}
+
+func (n *notifier) render(event string, data any) (string, error) {
+	var message bytes.Buffer
+	if err := n.templates[event].Execute(&message, data); err != nil {
+		return "", fmt.Errorf("error rendering %v notification: %v", event, err)
+	}
+	return message.String(), nil
+}
+
+// digestDue tells whether the digest of the day is due and not sent yet.
+func (n *notifier) digestDue(sink string, now time.Time) (bool, error) {
+	loc, err := config.Spec.Schedule.location()
+	if err != nil {
+		return false, err
+	}
+	digestAt := n.cfg.DigestAt
+	if digestAt == "" {
+		digestAt = defaultDigestAt
+	}
+	at, err := parseClock(digestAt)
+	if err != nil {
+		return false, err
+	}
+	local := now.In(loc)
+	due := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc).Add(at)
+	return !now.Before(due) && n.state.LastDigest[sink].Before(due), nil
+}
+
+// flush sends queued notifications and the digests that are due. Messages
+// failing to be sent are kept for the next run.
+func (n *notifier) flush(ctx context.Context) error {
+	if n == nil {
+		return nil
+	}
+	errs := []error{}
+	sinks := []string{}
+	for sink := range n.senders {
+		sinks = append(sinks, sink)
+	}
+	sort.Strings(sinks)
+
+	for _, sink := range sinks {
+		sender := n.senders[sink]
+		for len(n.state.Queued[sink]) > 0 {
+			item := n.state.Queued[sink][0]
+			message, err := n.render(item.Event, item)
+			if err == nil {
+				klog.InfoS("Sending notification", "event", item.Event, "repository", item.Repository, "number", item.Number, "sink", sink)
+				err = sender.send(ctx, message)
+			}
+			if err != nil {
+				errs = append(errs, err)
+				break
+			}
+			n.state.Queued[sink] = n.state.Queued[sink][1:]
+		}
+
+		now := runClock.Now()
+		due, err := n.digestDue(sink, now)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !due {
+			continue
+		}
+		if items := n.state.Digests[sink]; len(items) > 0 {
+			data := digest{Date: now.Format(time.DateOnly)}
+			for _, item := range items {
+				if humanEvents[item.Event] {
+					data.NeedsHumans = append(data.NeedsHumans, item)
+				} else {
+					data.Activity = append(data.Activity, item)
+				}
+			}
+			message, err := n.render(eventDigest, data)
+			if err == nil {
+				klog.InfoS("Sending digest", "sink", sink, "notifications", len(items))
+				err = sender.send(ctx, message)
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		n.state.Digests[sink] = nil
+		n.state.LastDigest[sink] = now
+	}
+
+	if err := n.save(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// save writes the state file atomically.
+func (n *notifier) save() error {
+	if n.cfg.StateFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(n.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(n.cfg.StateFile), ".notifications-*")
+	if err != nil {
+		return fmt.Errorf("error writing notification state: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing notification state: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing notification state: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), n.cfg.StateFile); err != nil {
+		return fmt.Errorf("error writing notification state: %v", err)
+	}
+	return nil
+}
+
+func validateNotifications(cfg *Notifications) error {
+	sinks := make(map[string]bool)
+	for _, sink := range cfg.Sinks {
+		if sink.Name == "" {
+			return fmt.Errorf("sink name has to be specified")
+		}
+		if sinks[sink.Name] {
+			return fmt.Errorf("sink %q is defined more than once", sink.Name)
+		}
+		sinks[sink.Name] = true
+		if (sink.Slack == nil) == (sink.SMTP == nil) {
+			return fmt.Errorf("sink %q: exactly one of slack and smtp has to be specified", sink.Name)
+		}
+		if sink.Slack != nil && sink.Slack.WebhookURLFile == "" {
+			return fmt.Errorf("sink %q: webhookURLFile has to be specified", sink.Name)
+		}
+		if mail := sink.SMTP; mail != nil {
+			if _, _, err := net.SplitHostPort(mail.Address); err != nil {
+				return fmt.Errorf("sink %q: address %q is not in the host:port form", sink.Name, mail.Address)
+			}
+			if mail.From == "" || len(mail.To) == 0 {
+				return fmt.Errorf("sink %q: from and to have to be specified", sink.Name)
+			}
+			if (mail.UsernameFile == "") != (mail.PasswordFile == "") {
+				return fmt.Errorf("sink %q: usernameFile and passwordFile have to be specified together", sink.Name)
+			}
+		}
+	}
+	for i, route := range cfg.Routes {
+		if len(route.Sinks) == 0 {
+			return fmt.Errorf("route #%v: at least one sink has to be specified", i+1)
+		}
+		for _, sink := range route.Sinks {
+			if !sinks[sink] {
+				return fmt.Errorf("route #%v: unknown sink %q", i+1, sink)
+			}
+		}
+		for _, event := range route.Events {
+			if !slices.Contains(notificationEvents, event) {
+				return fmt.Errorf("route #%v: unknown event %q", i+1, event)
+			}
+		}
+		for _, pattern := range route.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("route #%v: invalid pattern %q", i+1, pattern)
+			}
+		}
+	}
+	if cfg.DigestAt != "" {
+		if _, err := parseClock(cfg.DigestAt); err != nil {
+			return fmt.Errorf("digestAt: %v", err)
+		}
+	}
+	for event := range cfg.Templates {
+		if _, exists := defaultNotificationTemplates[event]; !exists {
+			return fmt.Errorf("template of unknown event %q", event)
+		}
+	}
+	_, err := parseNotificationTemplates(cfg)
+	return err
+}
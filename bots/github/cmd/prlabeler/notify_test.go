@@ -0,0 +1,244 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSender records sent messages, sends fail while failing is set.
+type fakeSender struct {
+	messages []string
+	failing  bool
+}
+
+func (s *fakeSender) send(_ context.Context, message string) error {
+	if s.failing {
+		return fmt.Errorf("sink is down")
+	}
+	s.messages = append(s.messages, message)
+	return nil
+}
+
+// testNotifier returns a notifier of the routes keeping its state in a
+// temporary file. The sinks deliver to fake senders.
+func testNotifier(t *testing.T, routes ...NotificationRoute) (*notifier, map[string]*fakeSender) {
+	t.Helper()
+	cfg := &Notifications{
+		StateFile: filepath.Join(t.TempDir(), "notifications.json"),
+		Sinks: []NotificationSink{
+			{Name: "chat", Slack: &SlackWebhook{WebhookURLFile: "unused"}},
+			{Name: "mail", SMTP: &SMTPNotification{Address: "localhost:25", From: "prlabeler@example.com", To: []string{"team@example.com"}}},
+		},
+		Routes: routes,
+	}
+	n, err := newNotifier(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	senders := map[string]*fakeSender{"chat": {}, "mail": {}}
+	for name, sender := range senders {
+		n.senders[name] = sender
+	}
+	return n, senders
+}
+
+func queuedEvents(items []notification) []string {
+	events := []string{}
+	for _, item := range items {
+		events = append(events, fmt.Sprintf("%v %v#%v", item.Event, item.Repository, item.Number))
+	}
+	return events
+}
+
+func TestNotificationRouting(t *testing.T) {
+	setClock(t, time.Date(2025, 3, 3, 12, 0, 0, 0, time.UTC))
+	n, _ := testNotifier(t,
+		NotificationRoute{Events: []string{eventOverride}, Sinks: []string{"chat"}},
+		NotificationRoute{Repositories: []string{"org/other"}, Sinks: []string{"chat"}},
+		NotificationRoute{Events: []string{eventRejected, eventOverride}, Sinks: []string{"mail"}, Digest: true},
+		// Duplicate routes queue once
+		NotificationRoute{Events: []string{eventOverride}, Sinks: []string{"chat"}},
+	)
+
+	n.notify(eventOverride, testPR(1, "abc"), "abc", "ci/prow/unit")
+	n.notify(eventRejected, testPR(2, "def"), "def")
+	n.notify(eventApproved, testPR(3, "ghi"), "ghi")
+
+	if queued := strings.Join(queuedEvents(n.state.Queued["chat"]), ", "); queued != "override org/repo#1" {
+		t.Errorf("unexpected notifications queued for chat: %v", queued)
+	}
+	if queued := queuedEvents(n.state.Queued["mail"]); len(queued) != 0 {
+		t.Errorf("unexpected notifications queued for mail: %v", queued)
+	}
+	if queued := strings.Join(queuedEvents(n.state.Digests["mail"]), ", "); queued != "override org/repo#1, rejected org/repo#2" {
+		t.Errorf("unexpected notifications waiting for the mail digest: %v", queued)
+	}
+	if _, exists := n.state.Announced["approved org/repo#3"]; exists {
+		t.Errorf("an event no route matches was recorded as announced")
+	}
+}
+
+func TestNotifyOncePerState(t *testing.T) {
+	setClock(t, time.Date(2025, 3, 3, 12, 0, 0, 0, time.UTC))
+	n, _ := testNotifier(t, NotificationRoute{Events: []string{eventApproved}, Sinks: []string{"chat"}})
+
+	n.notify(eventApproved, testPR(1, "abc"), "abc")
+	n.notify(eventApproved, testPR(1, "abc"), "abc")
+	if queued := len(n.state.Queued["chat"]); queued != 1 {
+		t.Fatalf("expected the PR to be announced once, got %v notifications", queued)
+	}
+	n.notify(eventApproved, testPR(1, "def"), "def")
+	if queued := len(n.state.Queued["chat"]); queued != 2 {
+		t.Fatalf("expected the new PR head to be announced, got %v notifications", queued)
+	}
+
+	// Unrouted events are announced once a route is added
+	n.notify(eventRevoked, testPR(1, "def"), "def")
+	n.cfg.Routes = append(n.cfg.Routes, NotificationRoute{Events: []string{eventRevoked}, Sinks: []string{"chat"}})
+	n.notify(eventRevoked, testPR(1, "def"), "def")
+	if queued := strings.Join(queuedEvents(n.state.Queued["chat"]), ", "); queued != "approved org/repo#1, approved org/repo#1, revoked org/repo#1" {
+		t.Errorf("unexpected notifications: %v", queued)
+	}
+}
+
+// testWebhook is a Slack compatible webhook failing while failing is set.
+type testWebhook struct {
+	mutex    sync.Mutex
+	failing  bool
+	messages []string
+}
+
+func (w *testWebhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.failing {
+		http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	payload := map[string]string{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.messages = append(w.messages, payload["text"])
+}
+
+func TestFlushRequeuesFailedSends(t *testing.T) {
+	setClock(t, time.Date(2025, 3, 3, 12, 0, 0, 0, time.UTC))
+	webhook := &testWebhook{failing: true}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	n, _ := testNotifier(t, NotificationRoute{Events: []string{eventOverride}, Sinks: []string{"chat"}})
+	urlFile := filepath.Join(t.TempDir(), "webhook")
+	if err := os.WriteFile(urlFile, []byte(server.URL+"/hooks/secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	n.senders["chat"] = &slackSender{name: "chat", urlFile: urlFile, httpClient: server.Client()}
+
+	n.notify(eventOverride, testPR(1, "abc"), "abc", "ci/prow/unit")
+	n.notify(eventOverride, testPR(2, "def"), "def", "ci/prow/e2e-aws-operator")
+	err := n.flush(context.Background())
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("expected an error not revealing the webhook, got %v", err)
+	}
+	if queued := len(n.state.Queued["chat"]); queued != 2 {
+		t.Fatalf("expected both notifications to be kept, got %v", queued)
+	}
+
+	// The queue survives the run
+	reloaded, err := newNotifier(n.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued := len(reloaded.state.Queued["chat"]); queued != 2 {
+		t.Fatalf("expected both notifications in the state file, got %v", queued)
+	}
+	reloaded.senders["chat"] = n.senders["chat"]
+	reloaded.senders["mail"] = &fakeSender{}
+
+	webhook.failing = false
+	if err := reloaded.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if queued := len(reloaded.state.Queued["chat"]); queued != 0 {
+		t.Errorf("expected the queue to be sent, %v notifications left", queued)
+	}
+	if len(webhook.messages) != 2 || !strings.Contains(webhook.messages[0], "overrode ci/prow/unit on org/repo#1") {
+		t.Errorf("unexpected messages: %q", webhook.messages)
+	}
+}
+
+func TestDigestDue(t *testing.T) {
+	previous := config
+	config = &PRLabelerConfig{}
+	config.Spec.Schedule.Timezone = "Europe/Prague"
+	defer func() { config = previous }()
+
+	clock := setClock(t, time.Date(2025, 3, 3, 7, 59, 0, 0, time.UTC))
+	n, senders := testNotifier(t, NotificationRoute{Sinks: []string{"mail"}, Digest: true})
+	n.notify(eventRejected, testPR(1, "abc"), "abc", "go.mod is not allowed")
+	n.notify(eventApproved, testPR(2, "def"), "def")
+
+	// 09:00 in Prague is 08:00 UTC in winter
+	if due, err := n.digestDue("mail", runClock.Now()); err != nil || due {
+		t.Fatalf("expected the digest not to be due before 09:00, got %v, %v", due, err)
+	}
+	if err := n.flush(context.Background()); err != nil || len(senders["mail"].messages) != 0 {
+		t.Fatalf("expected no digest before 09:00, got %q, %v", senders["mail"].messages, err)
+	}
+
+	clock.now = time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC)
+	if due, err := n.digestDue("mail", runClock.Now()); err != nil || !due {
+		t.Fatalf("expected the digest to be due at 09:00, got %v, %v", due, err)
+	}
+	if err := n.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(senders["mail"].messages) != 1 {
+		t.Fatalf("expected a single digest, got %q", senders["mail"].messages)
+	}
+	message := senders["mail"].messages[0]
+	if !strings.Contains(message, "PRs that need humans:\n- org/repo#1 (rejected)") || !strings.Contains(message, "Activity:\n- org/repo#2 (approved)") {
+		t.Errorf("unexpected digest: %q", message)
+	}
+	if digests := len(n.state.Digests["mail"]); digests != 0 {
+		t.Errorf("expected the digest to be emptied, %v notifications left", digests)
+	}
+
+	// Once a day
+	clock.now = time.Date(2025, 3, 3, 20, 0, 0, 0, time.UTC)
+	if due, _ := n.digestDue("mail", runClock.Now()); due {
+		t.Errorf("expected the digest to be sent once a day")
+	}
+	// Summer time moves the digest to 07:00 UTC
+	clock.now = time.Date(2025, 3, 31, 7, 0, 0, 0, time.UTC)
+	if due, _ := n.digestDue("mail", runClock.Now()); !due {
+		t.Errorf("expected the digest to be due at 09:00 summer time")
+	}
+}
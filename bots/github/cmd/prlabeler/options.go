@@ -26,16 +26,49 @@ import (
 
 var (
 	repositories []string
-	withAuthors []string
+	withAuthors  []string
+	// Modules whose bumps are approved within the same minor version
+	goModuleAllowlist = []string{"k8s.io/*"}
+	botProfileNames   = []string{"mintmaker"}
+	configFilename    string
+	// Sync the declared labels at the start of every reconcile
+	syncLabels bool
+	// Fetch PRs in bulk through GraphQL
+	useGraphQL = true
+	// Cassettes of GitHub API traffic
+	recordFilename string
+	replayFilename string
+	// File with the GitHub token, read again whenever it changes
+	tokenFilename string
 )
 
-func initFlags() {
-	pflag.StringSliceVar(&repositories, "repository", repositories, "List of repositories to process")
-	pflag.StringSliceVar(&withAuthors, "with-author", withAuthors, "List of authors to reconcile (accepting PRs with `[auto]` string set in their summary)")
-	pflag.Parse()
+// addReconcileFlags adds flags of a reconcile of all the repositories.
+func addReconcileFlags(fs *pflag.FlagSet) {
+	fs.StringSliceVar(&repositories, "repository", repositories, "List of repositories to process")
+	fs.StringSliceVar(&withAuthors, "with-author", withAuthors, "List of authors to reconcile (accepting PRs with `[auto]` string set in their summary)")
+	fs.StringSliceVar(&goModuleAllowlist, "go-module-allowlist", goModuleAllowlist, "List of go module path patterns whose bumps within the same minor version get approved")
+	fs.StringSliceVar(&botProfileNames, "bot-profile", botProfileNames, "List of dependency bot profiles to reconcile (mintmaker, renovate, dependabot)")
+	fs.BoolVar(&syncLabels, "sync-labels", syncLabels, "Sync labels declared in the configuration file before reconciling")
+	fs.BoolVar(&useGraphQL, "graphql", useGraphQL, "Fetch open PRs with their files, comments and statuses in bulk through GraphQL (REST is used as a fallback)")
+	fs.StringVar(&recordFilename, "record", recordFilename, "Record all GitHub API requests and responses to a cassette file (tokens are redacted)")
+	fs.StringVar(&replayFilename, "replay", replayFilename, "Replay GitHub API responses from a cassette file instead of accessing GitHub")
+	fs.StringVar(&tokenFilename, "token-file", tokenFilename, "File with the GitHub token, read again when it changes (GITHUB_TOKEN is used when not set). Credentials of the configuration file take precedence.")
 }
 
 func validateFlags() {
+	validateRepositories()
+	validateCassetteFlags()
+
+	for _, name := range botProfileNames {
+		if getBotProfile(name) == nil {
+			klog.Errorf("unknown bot profile %q", name)
+			os.Exit(1)
+			return
+		}
+	}
+}
+
+func validateRepositories() {
 	if len(repositories) == 0 {
 		klog.Error("repository is required")
 		os.Exit(1)
@@ -45,9 +78,33 @@ func validateFlags() {
 	for _, repository := range repositories {
 		items := strings.Split(repository, "/")
 		if len(items) != 2 {
-			klog.Error("repository %q is not in a 'organization/repository' form")
+			klog.Errorf("repository %q is not in a 'organization/repository' form", repository)
 			os.Exit(1)
 			return
 		}
 	}
 }
+
+func validateCassetteFlags() {
+	if recordFilename != "" && replayFilename != "" {
+		klog.Error("--record and --replay are mutually exclusive")
+		os.Exit(1)
+	}
+	if err := openCassette(); err != nil {
+		klog.Error(err)
+		os.Exit(1)
+	}
+}
+
+// applyConfig loads the configuration file when one is provided.
+func applyConfig() {
+	if configFilename == "" {
+		return
+	}
+	cfg, err := loadConfig(configFilename)
+	if err != nil {
+		klog.Error(err)
+		os.Exit(1)
+	}
+	config = cfg
+}
@@ -0,0 +1,348 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v76/github"
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+)
+
+type ownersConfig struct {
+	Approvers []string `yaml:"approvers"`
+	Reviewers []string `yaml:"reviewers"`
+}
+
+// ownersFile is the Prow OWNERS file.
+type ownersFile struct {
+	ownersConfig `yaml:",inline"`
+	Options      struct {
+		NoParentOwners bool `yaml:"no_parent_owners"`
+	} `yaml:"options"`
+	// Owners of files (relative to the OWNERS directory) matching the regexes
+	Filters map[string]ownersConfig `yaml:"filters"`
+}
+
+// ownersAliases is the Prow OWNERS_ALIASES file.
+type ownersAliases struct {
+	Aliases map[string][]string `yaml:"aliases"`
+}
+
+// repoOwners resolves owners of files from the OWNERS files of a repository
+// at a single git reference. OWNERS files are loaded on demand.
+type repoOwners struct {
+	client     *github.Client
+	owner      string
+	repo       string
+	ref        string
+	aliases    map[string][]string
+	ownersDirs map[string]bool
+	files      map[string]*ownersFile
+}
+
+// Owners of repositories loaded during the current run, keyed by
+// owner/repo@ref
+var loadedOwners = make(map[string]*repoOwners)
+
+// loadRepoOwners lists the OWNERS files of the repository and loads
+// OWNERS_ALIASES.
+func loadRepoOwners(ctx context.Context, client *github.Client, owner, repo, ref string) (*repoOwners, error) {
+	key := fmt.Sprintf("%v/%v@%v", owner, repo, ref)
+	if owners, exists := loadedOwners[key]; exists {
+		return owners, nil
+	}
+
+	tree, _, err := client.Git.GetTree(ctx, owner, repo, ref, true)
+	if err != nil {
+		return nil, fmt.Errorf("error listing files of %v/%v at %v: %v", owner, repo, ref, err)
+	}
+	if tree.GetTruncated() {
+		return nil, fmt.Errorf("file tree of %v/%v at %v is too large to look up OWNERS files", owner, repo, ref)
+	}
+	owners := &repoOwners{
+		client:     client,
+		owner:      owner,
+		repo:       repo,
+		ref:        ref,
+		aliases:    make(map[string][]string),
+		ownersDirs: make(map[string]bool),
+		files:      make(map[string]*ownersFile),
+	}
+	for _, entry := range tree.Entries {
+		if entry.GetType() == "blob" && path.Base(entry.GetPath()) == "OWNERS" {
+			owners.ownersDirs[path.Dir(entry.GetPath())] = true
+		}
+	}
+
+	content, err := getFileContent(ctx, client, owner, repo, "OWNERS_ALIASES", ref)
+	switch {
+	case isNotFound(err):
+	case err != nil:
+		return nil, err
+	default:
+		aliases := &ownersAliases{}
+		if err := yaml.Unmarshal([]byte(content), aliases); err != nil {
+			return nil, fmt.Errorf("unable to parse OWNERS_ALIASES of %v/%v: %v", owner, repo, err)
+		}
+		for alias, members := range aliases.Aliases {
+			owners.aliases[strings.ToLower(alias)] = members
+		}
+	}
+
+	loadedOwners[key] = owners
+	return owners, nil
+}
+
+func (o *repoOwners) hasOwners() bool {
+	return len(o.ownersDirs) > 0
+}
+
+func (o *repoOwners) ownersFile(ctx context.Context, dir string) (*ownersFile, error) {
+	if file, exists := o.files[dir]; exists {
+		return file, nil
+	}
+	content, err := getFileContent(ctx, o.client, o.owner, o.repo, path.Join(dir, "OWNERS"), o.ref)
+	if err != nil {
+		return nil, err
+	}
+	file := &ownersFile{}
+	if err := yaml.Unmarshal([]byte(content), file); err != nil {
+		return nil, fmt.Errorf("unable to parse %v of %v/%v: %v", path.Join(dir, "OWNERS"), o.owner, o.repo, err)
+	}
+	o.files[dir] = file
+	return file, nil
+}
+
+// expand replaces aliases with their members. Logins are lowercased.
+func (o *repoOwners) expand(logins []string) []string {
+	expanded := []string{}
+	for _, login := range logins {
+		login = strings.ToLower(login)
+		if members, exists := o.aliases[login]; exists {
+			for _, member := range members {
+				expanded = append(expanded, strings.ToLower(member))
+			}
+			continue
+		}
+		expanded = append(expanded, login)
+	}
+	return expanded
+}
+
+// fileOwners returns owners of a file as OWNERS files from the closest to the
+// root, stopping at no_parent_owners.
+func (o *repoOwners) fileOwners(ctx context.Context, file string) ([]ownersConfig, error) {
+	configs := []ownersConfig{}
+	for dir := path.Dir(file); ; dir = path.Dir(dir) {
+		if o.ownersDirs[dir] {
+			owners, err := o.ownersFile(ctx, dir)
+			if err != nil {
+				return nil, err
+			}
+			resolved := ownersConfig{
+				Approvers: o.expand(owners.Approvers),
+				Reviewers: o.expand(owners.Reviewers),
+			}
+			relative := strings.TrimPrefix(strings.TrimPrefix(file, dir), "/")
+			for pattern, filter := range owners.Filters {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					klog.Warningf("Ignoring invalid filter %q in %v", pattern, path.Join(dir, "OWNERS"))
+					continue
+				}
+				if re.MatchString(relative) {
+					resolved.Approvers = append(resolved.Approvers, o.expand(filter.Approvers)...)
+					resolved.Reviewers = append(resolved.Reviewers, o.expand(filter.Reviewers)...)
+				}
+			}
+			configs = append(configs, resolved)
+			if owners.Options.NoParentOwners {
+				break
+			}
+		}
+		if dir == "." {
+			break
+		}
+	}
+	return configs, nil
+}
+
+// rootApprovers returns approvers of the root OWNERS file.
+func (o *repoOwners) rootApprovers(ctx context.Context) ([]string, error) {
+	configs, err := o.fileOwners(ctx, "OWNERS")
+	if err != nil || len(configs) == 0 {
+		return nil, err
+	}
+	return configs[len(configs)-1].Approvers, nil
+}
+
+// ownersCoverage tells which Prow commands the bot can issue for the changed
+// files.
+type ownersCoverage struct {
+	Bot        string
+	CanApprove bool
+	CanLGTM    bool
+	// Closest approvers and reviewers of files the bot can not approve or lgtm
+	Approvers []string
+	Reviewers []string
+}
+
+// closest returns the logins of the closest OWNERS file listing any.
+func closest(configs []ownersConfig, logins func(ownersConfig) []string) []string {
+	for _, owners := range configs {
+		if len(logins(owners)) > 0 {
+			return logins(owners)
+		}
+	}
+	return nil
+}
+
+func (o *repoOwners) coverage(ctx context.Context, bot string, files []string) (*ownersCoverage, error) {
+	coverage := &ownersCoverage{Bot: bot, CanApprove: true, CanLGTM: true}
+	if !o.hasOwners() {
+		return coverage, nil
+	}
+	bot = strings.ToLower(bot)
+	approvers := make(map[string]bool)
+	reviewers := make(map[string]bool)
+	for _, file := range files {
+		configs, err := o.fileOwners(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+		canApprove, canLGTM := false, false
+		for _, owners := range configs {
+			for _, approver := range owners.Approvers {
+				if approver == bot {
+					canApprove, canLGTM = true, true
+				}
+			}
+			for _, reviewer := range owners.Reviewers {
+				if reviewer == bot {
+					canLGTM = true
+				}
+			}
+		}
+		if !canApprove {
+			coverage.CanApprove = false
+			for _, approver := range closest(configs, func(c ownersConfig) []string { return c.Approvers }) {
+				approvers[approver] = true
+			}
+		}
+		if !canLGTM {
+			coverage.CanLGTM = false
+			for _, reviewer := range closest(configs, func(c ownersConfig) []string { return c.Reviewers }) {
+				reviewers[reviewer] = true
+			}
+		}
+	}
+	for approver := range approvers {
+		coverage.Approvers = append(coverage.Approvers, approver)
+	}
+	for reviewer := range reviewers {
+		coverage.Reviewers = append(coverage.Reviewers, reviewer)
+	}
+	sort.Strings(coverage.Approvers)
+	sort.Strings(coverage.Reviewers)
+	return coverage, nil
+}
+
+// authorizedCommands drops /approve and /lgtm comments the bot can not issue
+// and returns /assign and /cc requests for the owners instead.
+func (c *ownersCoverage) authorizedCommands(label2comments map[string]string) (map[string]string, []string) {
+	if c == nil {
+		return label2comments, nil
+	}
+	authorized := make(map[string]string)
+	requests := []string{}
+	for label, comment := range label2comments {
+		switch {
+		case label == "approved" && !c.CanApprove:
+			if len(c.Approvers) > 0 {
+				requests = append(requests, "/assign @"+strings.Join(c.Approvers, " @"))
+			}
+		case label == "lgtm" && !c.CanLGTM:
+			if len(c.Reviewers) > 0 {
+				requests = append(requests, "/cc @"+strings.Join(c.Reviewers, " @"))
+			}
+		default:
+			authorized[label] = comment
+		}
+	}
+	sort.Strings(requests)
+	return authorized, requests
+}
+
+// Logins of the clients' users. GitHub Apps act as <app>[bot].
+var botLogins = make(map[*github.Client]string)
+
+func getBotLogin(ctx context.Context, client *github.Client) (string, error) {
+	if login, exists := botLogins[client]; exists {
+		return login, nil
+	}
+	user, _, err := client.Users.Get(ctx, "")
+	if err != nil {
+		return "", fmt.Errorf("error getting the authenticated user: %v", err)
+	}
+	botLogins[client] = user.GetLogin()
+	return user.GetLogin(), nil
+}
+
+// getOwnersCoverage resolves the owners of the changed files at the PR base.
+func getOwnersCoverage(ctx context.Context, client *github.Client, pr *github.PullRequest, files []string) (*ownersCoverage, error) {
+	bot, err := getBotLogin(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	owners, err := loadRepoOwners(ctx, client, pr.GetBase().GetRepo().GetOwner().GetLogin(), pr.GetBase().GetRepo().GetName(), pr.GetBase().GetRef())
+	if err != nil {
+		return nil, err
+	}
+	return owners.coverage(ctx, bot, files)
+}
+
+// ensureOwnersRequests posts /assign and /cc requests not posted yet.
+func ensureOwnersRequests(ctx context.Context, client *github.Client, owner, repo string, prNum int, requests []string) error {
+	if len(requests) == 0 {
+		return nil
+	}
+	comments, err := getPRComments(ctx, client, owner, repo, prNum)
+	if err != nil {
+		return err
+	}
+	posted := make(map[string]bool)
+	for _, comment := range comments {
+		posted[strings.TrimSpace(comment.GetBody())] = true
+	}
+	for _, request := range requests {
+		if posted[request] {
+			continue
+		}
+		klog.InfoS("Requesting owners", "number", prNum, "comment", request)
+		if _, _, err := client.Issues.CreateComment(ctx, owner, repo, prNum, &github.IssueComment{Body: github.String(request)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"maps"
+	"slices"
+)
+
+var defaultLabels = []string{"jira/valid-bug", "jira/valid-reference"}
+
+var defaultOverridableContexts = []string{
+	"ci/prow/unit",
+	"ci/prow/images",
+	"ci/prow/e2e-aws-operator",
+	"ci/prow/verify",
+}
+
+// Built-in policies. Backport risk is assessed for release branches only.
+var (
+	builtinBranchPolicies = []BranchPolicy{
+		{
+			Name:                "development",
+			Branches:            []string{"main", "master"},
+			Labels:              defaultLabels,
+			CommentLabels:       withoutCommentLabel(label2comments, "backport-risk-assessed"),
+			OverridableContexts: defaultOverridableContexts,
+		},
+	}
+	builtinDefaultBranchPolicy = BranchPolicy{
+		Name:                "default",
+		Labels:              defaultLabels,
+		CommentLabels:       label2comments,
+		OverridableContexts: defaultOverridableContexts,
+	}
+)
+
+func withoutCommentLabel(label2comments map[string]string, label string) map[string]string {
+	m := maps.Clone(label2comments)
+	delete(m, label)
+	return m
+}
+
+// branchPolicy returns the policy for PRs against the base branch.
+func branchPolicy(cfg *PRLabelerConfig, branch string) *BranchPolicy {
+	policies := builtinBranchPolicies
+	if len(cfg.Spec.BranchPolicies) > 0 {
+		policies = cfg.Spec.BranchPolicies
+	}
+	for i := range policies {
+		if matchAnyGlob(policies[i].Branches, branch) {
+			return &policies[i]
+		}
+	}
+	if cfg.Spec.DefaultBranchPolicy != nil {
+		return cfg.Spec.DefaultBranchPolicy
+	}
+	return &builtinDefaultBranchPolicy
+}
+
+func (p *BranchPolicy) overridable(context string) bool {
+	return slices.Contains(p.OverridableContexts, context) && !slices.Contains(p.RequiredContexts, context)
+}
+
+// Context of the Prow merge status, pending until the PR merges
+const tideContext = "tide"
+
+// gating tells whether the context decides if a PR is green. When the policy
+// lists required contexts only those do, tide never does.
+func (p *BranchPolicy) gating(context string) bool {
+	if context == tideContext {
+		return false
+	}
+	return len(p.RequiredContexts) == 0 || slices.Contains(p.RequiredContexts, context)
+}
+
+// missingRequiredContexts lists required contexts without a success status.
+func (p *BranchPolicy) missingRequiredContexts(traces []statusTrace) []string {
+	states := make(map[string]string)
+	for _, trace := range traces {
+		states[trace.Context] = trace.State
+	}
+	missing := []string{}
+	for _, context := range p.RequiredContexts {
+		if states[context] != "success" {
+			missing = append(missing, context)
+		}
+	}
+	return missing
+}
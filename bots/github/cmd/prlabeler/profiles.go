@@ -0,0 +1,317 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	renovateAuthor   = "renovate[bot]"
+	dependabotAuthor = "dependabot[bot]"
+)
+
+// dependencyUpdate is a single dependency change announced by a bot.
+type dependencyUpdate struct {
+	Package string
+	// Dependency type as reported by the bot (e.g. require, indirect, stage)
+	Type string
+	// Update type (e.g. major, minor, patch, digest, pin)
+	UpdateType string
+	From       string
+	To         string
+}
+
+// prMetadata is the structured information a bot embeds in a PR title and
+// body.
+type prMetadata struct {
+	Profile string
+	// Dependency named in the title (e.g. "konflux references", "k8s.io/api")
+	Dependency string
+	// Go module update ("update module ..." titles)
+	Module bool
+	// Only a digest is updated ("... digest to ..." titles)
+	Digest     bool
+	NewVersion string
+	Updates    []dependencyUpdate
+}
+
+// botProfile describes conventions of a dependency bot.
+type botProfile struct {
+	Name    string
+	Authors []string
+	// Globs of files the bot is expected to touch
+	AllowedFiles []string
+	ParseTitle   func(title string, metadata *prMetadata) bool
+	ParseBody    func(body string) []dependencyUpdate
+}
+
+var renovateFiles = []string{
+	".tekton/**",
+	"Dockerfile*",
+	"*.Dockerfile",
+	"bundle.Dockerfile",
+	"images/**",
+	"go.mod",
+	"go.sum",
+	"vendor/**",
+	".github/workflows/**",
+}
+
+var botProfiles = []*botProfile{
+	{
+		// MintMaker is the Renovate based dependency bot of Konflux
+		Name:         "mintmaker",
+		Authors:      []string{konfluxAuthor},
+		AllowedFiles: renovateFiles,
+		ParseTitle:   parseRenovateTitle,
+		ParseBody:    parseRenovateBody,
+	},
+	{
+		Name:         "renovate",
+		Authors:      []string{renovateAuthor},
+		AllowedFiles: renovateFiles,
+		ParseTitle:   parseRenovateTitle,
+		ParseBody:    parseRenovateBody,
+	},
+	{
+		Name:    "dependabot",
+		Authors: []string{dependabotAuthor},
+		AllowedFiles: []string{
+			"go.mod",
+			"go.sum",
+			"vendor/**",
+			"Dockerfile*",
+			"*.Dockerfile",
+			".github/workflows/**",
+		},
+		ParseTitle: parseDependabotTitle,
+		ParseBody:  parseDependabotBody,
+	},
+}
+
+func getBotProfile(name string) *botProfile {
+	for _, profile := range botProfiles {
+		if profile.Name == name {
+			return profile
+		}
+	}
+	return nil
+}
+
+// profileForAuthor returns the enabled profile of a bot author.
+func profileForAuthor(author string, enabled []string) *botProfile {
+	for _, name := range enabled {
+		profile := getBotProfile(name)
+		if profile == nil {
+			continue
+		}
+		for _, profileAuthor := range profile.Authors {
+			if profileAuthor == author {
+				return profile
+			}
+		}
+	}
+	return nil
+}
+
+func (p *botProfile) parseMetadata(title, body string) *prMetadata {
+	metadata := &prMetadata{Profile: p.Name}
+	p.ParseTitle(title, metadata)
+	metadata.Updates = p.ParseBody(body)
+	return metadata
+}
+
+// disallowedFile returns the first file the bot is not expected to touch.
+func (p *botProfile) disallowedFile(files []string) string {
+	for _, file := range files {
+		if !matchAnyFileGlob(p.AllowedFiles, file) {
+			return file
+		}
+	}
+	return ""
+}
+
+// matchFileGlob matches a file path against a glob. A trailing "/**" matches
+// everything under the directory.
+func matchFileGlob(pattern, file string) bool {
+	if prefix, found := strings.CutSuffix(pattern, "/**"); found {
+		return strings.HasPrefix(file, prefix+"/")
+	}
+	matched, _ := path.Match(pattern, file)
+	return matched
+}
+
+func matchAnyFileGlob(patterns []string, file string) bool {
+	for _, pattern := range patterns {
+		if matchFileGlob(pattern, file) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	conventionalCommitPrefix = regexp.MustCompile(`^[a-z]+(\([^)]*\))?!?: `)
+	titleSuffix              = regexp.MustCompile(`(\s+(\[[^\]]*\]|\([^)]*\)))+$`)
+)
+
+// parseRenovateTitle parses titles like:
+//   - chore(deps): update konflux references
+//   - chore(deps): update module k8s.io/api to v0.31.2
+//   - chore(deps): update registry.access.redhat.com/ubi9/ubi-minimal:latest docker digest to 2f06ae0
+func parseRenovateTitle(title string, metadata *prMetadata) bool {
+	rest := conventionalCommitPrefix.ReplaceAllString(strings.TrimSpace(title), "")
+	rest, found := cutPrefixFold(rest, "update ")
+	if !found {
+		return false
+	}
+	// Drop suffixes like " [security]", " (major)" or " (release-4.18)"
+	rest = titleSuffix.ReplaceAllString(rest, "")
+	if module, found := strings.CutPrefix(rest, "module "); found {
+		metadata.Module = true
+		rest = module
+	}
+	if idx := strings.LastIndex(rest, " to "); idx >= 0 {
+		metadata.NewVersion = rest[idx+len(" to "):]
+		rest = rest[:idx]
+	}
+	if dependency, found := strings.CutSuffix(rest, " digest"); found {
+		metadata.Digest = true
+		rest = dependency
+	}
+	rest = strings.TrimSuffix(rest, " docker tag")
+	rest = strings.TrimSuffix(rest, " docker")
+	metadata.Dependency = strings.ToLower(rest)
+	return true
+}
+
+var dependabotTitle = regexp.MustCompile(`^[Bb]ump (\S+) from (\S+) to (\S+)`)
+
+// parseDependabotTitle parses titles like:
+//   - Bump golang.org/x/net from 0.23.0 to 0.33.0
+//   - chore(deps): bump golang.org/x/net from 0.23.0 to 0.33.0 in /hack/tools
+func parseDependabotTitle(title string, metadata *prMetadata) bool {
+	rest := conventionalCommitPrefix.ReplaceAllString(strings.TrimSpace(title), "")
+	match := dependabotTitle.FindStringSubmatch(rest)
+	if match == nil {
+		return false
+	}
+	metadata.Dependency = strings.ToLower(match[1])
+	metadata.NewVersion = match[3]
+	return true
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
+
+var markdownLink = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+
+func cleanMarkdownCell(cell string) string {
+	cell = markdownLink.ReplaceAllString(cell, "$1")
+	cell = strings.ReplaceAll(cell, "`", "")
+	return strings.TrimSpace(cell)
+}
+
+func splitMarkdownRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+	cells := strings.Split(line, "|")
+	for i := range cells {
+		cells[i] = cleanMarkdownCell(cells[i])
+	}
+	return cells
+}
+
+// parseRenovateBody parses the dependency table Renovate puts in PR bodies:
+//
+//	| Package | Type | Update | Change |
+//	|---|---|---|---|
+//	| [k8s.io/api](https://...) | require | patch | `v0.31.1` -> `v0.31.2` |
+func parseRenovateBody(body string) []dependencyUpdate {
+	updates := []dependencyUpdate{}
+	var columns map[string]int
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "|") {
+			columns = nil
+			continue
+		}
+		cells := splitMarkdownRow(trimmed)
+		if columns == nil {
+			columns = make(map[string]int)
+			for i, cell := range cells {
+				columns[strings.ToLower(cell)] = i
+			}
+			if _, exists := columns["package"]; !exists {
+				// Not a dependency table, skip it whole
+				columns = map[string]int{}
+			}
+			continue
+		}
+		if _, exists := columns["package"]; !exists || strings.HasPrefix(cells[0], "---") || strings.HasPrefix(cells[0], ":-") {
+			continue
+		}
+		cell := func(name string) string {
+			if idx, exists := columns[name]; exists && idx < len(cells) {
+				return cells[idx]
+			}
+			return ""
+		}
+		update := dependencyUpdate{
+			Package:    cell("package"),
+			Type:       cell("type"),
+			UpdateType: cell("update"),
+		}
+		change := strings.ReplaceAll(cell("change"), "→", "->")
+		if from, to, found := strings.Cut(change, "->"); found {
+			update.From, update.To = strings.TrimSpace(from), strings.TrimSpace(to)
+		}
+		updates = append(updates, update)
+	}
+	return updates
+}
+
+var dependabotBump = regexp.MustCompile(`Bumps \[([^\]]+)\]\([^)]*\) from (\S+) to (\S+?)\.?(?:\s|$)`)
+
+// parseDependabotBody parses "Bumps [pkg](url) from X to Y." sentences.
+func parseDependabotBody(body string) []dependencyUpdate {
+	updates := []dependencyUpdate{}
+	for _, match := range dependabotBump.FindAllStringSubmatch(body, -1) {
+		updates = append(updates, dependencyUpdate{
+			Package:    match[1],
+			From:       match[2],
+			To:         match[3],
+			UpdateType: classifyBump(withVPrefix(match[2]), withVPrefix(match[3])),
+		})
+	}
+	return updates
+}
+
+func withVPrefix(version string) string {
+	if strings.HasPrefix(version, "v") {
+		return version
+	}
+	return "v" + version
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRenovateTitle(t *testing.T) {
+	tests := []struct {
+		title    string
+		parsed   bool
+		metadata prMetadata
+	}{
+		{
+			title:    "chore(deps): update konflux references",
+			parsed:   true,
+			metadata: prMetadata{Dependency: "konflux references"},
+		},
+		{
+			title:    "chore(deps): update konflux references (release-4.18)",
+			parsed:   true,
+			metadata: prMetadata{Dependency: "konflux references"},
+		},
+		{
+			title:    "chore(deps): update module k8s.io/api to v0.31.2",
+			parsed:   true,
+			metadata: prMetadata{Dependency: "k8s.io/api", Module: true, NewVersion: "v0.31.2"},
+		},
+		{
+			title:    "fix(deps): update module golang.org/x/crypto to v0.31.0 [security]",
+			parsed:   true,
+			metadata: prMetadata{Dependency: "golang.org/x/crypto", Module: true, NewVersion: "v0.31.0"},
+		},
+		{
+			title:    "chore(deps): update registry.access.redhat.com/ubi9/ubi-minimal:latest docker digest to 2f06ae0",
+			parsed:   true,
+			metadata: prMetadata{Dependency: "registry.access.redhat.com/ubi9/ubi-minimal:latest", Digest: true, NewVersion: "2f06ae0"},
+		},
+		{
+			title: "Bump golang.org/x/net from 0.23.0 to 0.33.0",
+		},
+	}
+	for _, test := range tests {
+		metadata := prMetadata{}
+		if parsed := parseRenovateTitle(test.title, &metadata); parsed != test.parsed || !reflect.DeepEqual(metadata, test.metadata) {
+			t.Errorf("%q: expected %v %+v, got %v %+v", test.title, test.parsed, test.metadata, parsed, metadata)
+		}
+	}
+}
+
+func TestParseDependabotTitle(t *testing.T) {
+	tests := []struct {
+		title    string
+		parsed   bool
+		metadata prMetadata
+	}{
+		{
+			title:    "Bump golang.org/x/net from 0.23.0 to 0.33.0",
+			parsed:   true,
+			metadata: prMetadata{Dependency: "golang.org/x/net", NewVersion: "0.33.0"},
+		},
+		{
+			title:    "chore(deps): bump golang.org/x/net from 0.23.0 to 0.33.0 in /hack/tools",
+			parsed:   true,
+			metadata: prMetadata{Dependency: "golang.org/x/net", NewVersion: "0.33.0"},
+		},
+		{
+			title:    "build(deps): Bump github.com/onsi/Gomega from 1.34.2 to 1.36.0",
+			parsed:   true,
+			metadata: prMetadata{Dependency: "github.com/onsi/gomega", NewVersion: "1.36.0"},
+		},
+		{
+			title: "chore(deps): update konflux references",
+		},
+	}
+	for _, test := range tests {
+		metadata := prMetadata{}
+		if parsed := parseDependabotTitle(test.title, &metadata); parsed != test.parsed || !reflect.DeepEqual(metadata, test.metadata) {
+			t.Errorf("%q: expected %v %+v, got %v %+v", test.title, test.parsed, test.metadata, parsed, metadata)
+		}
+	}
+}
+
+func TestParseRenovateBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		updates []dependencyUpdate
+	}{
+		{
+			name: "go modules",
+			body: "This PR contains the following updates:\n\n" +
+				"| Package | Type | Update | Change |\n" +
+				"|---|---|---|---|\n" +
+				"| [k8s.io/api](https://redirect.github.com/kubernetes/api) | require | patch | `v0.31.1` -> `v0.31.2` |\n" +
+				"| [k8s.io/apimachinery](https://redirect.github.com/kubernetes/apimachinery) | indirect | minor | `v0.30.3` -> `v0.31.2` |\n" +
+				"\n---\n\n### Release Notes\n",
+			updates: []dependencyUpdate{
+				{Package: "k8s.io/api", Type: "require", UpdateType: "patch", From: "v0.31.1", To: "v0.31.2"},
+				{Package: "k8s.io/apimachinery", Type: "indirect", UpdateType: "minor", From: "v0.30.3", To: "v0.31.2"},
+			},
+		},
+		{
+			name: "docker digest",
+			body: "| Package | Type | Update | Change |\n" +
+				"|---|---|---|---|\n" +
+				"| registry.access.redhat.com/ubi9/ubi-minimal | stage | digest | `6aa1d4e` → `2f06ae0` |\n",
+			updates: []dependencyUpdate{
+				{Package: "registry.access.redhat.com/ubi9/ubi-minimal", Type: "stage", UpdateType: "digest", From: "6aa1d4e", To: "2f06ae0"},
+			},
+		},
+		{
+			name: "konflux references without type and update columns",
+			body: "| Package | Change |\n" +
+				"|---|---|\n" +
+				"| quay.io/konflux-ci/tekton-catalog/task-init | `f239f38` -> `08e18a4` |\n",
+			updates: []dependencyUpdate{
+				{Package: "quay.io/konflux-ci/tekton-catalog/task-init", From: "f239f38", To: "08e18a4"},
+			},
+		},
+		{
+			name: "other tables are skipped",
+			body: "| Datasource | Versions |\n" +
+				"|---|---|\n" +
+				"| go | 1 |\n",
+			updates: []dependencyUpdate{},
+		},
+	}
+	for _, test := range tests {
+		if updates := parseRenovateBody(test.body); !reflect.DeepEqual(updates, test.updates) {
+			t.Errorf("%v: expected %+v, got %+v", test.name, test.updates, updates)
+		}
+	}
+}
+
+func TestParseDependabotBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		updates []dependencyUpdate
+	}{
+		{
+			name: "minor",
+			body: "Bumps [golang.org/x/net](https://github.com/golang/net) from 0.23.0 to 0.33.0.\n" +
+				"<details>\n<summary>Commits</summary>\n</details>\n",
+			updates: []dependencyUpdate{{Package: "golang.org/x/net", UpdateType: "minor", From: "0.23.0", To: "0.33.0"}},
+		},
+		{
+			name:    "patch",
+			body:    "Bumps [github.com/onsi/gomega](https://github.com/onsi/gomega) from 1.34.2 to 1.34.3.",
+			updates: []dependencyUpdate{{Package: "github.com/onsi/gomega", UpdateType: "patch", From: "1.34.2", To: "1.34.3"}},
+		},
+		{
+			name:    "no bump",
+			body:    "Dependabot will resolve any conflicts with this PR as long as you don't alter it yourself.",
+			updates: []dependencyUpdate{},
+		},
+	}
+	for _, test := range tests {
+		if updates := parseDependabotBody(test.body); !reflect.DeepEqual(updates, test.updates) {
+			t.Errorf("%v: expected %+v, got %+v", test.name, test.updates, updates)
+		}
+	}
+}
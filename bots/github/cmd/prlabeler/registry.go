@@ -0,0 +1,435 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v76/github"
+)
+
+const (
+	dockerHubRegistry = "docker.io"
+	// Media type of cosign signature payloads
+	cosignPayloadMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// Manifests and signature payloads larger than this are truncated
+	maxRegistryObjectSize = 4 * 1024 * 1024
+)
+
+// Media types of manifests and image indexes accepted from registries
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Rules of PRs updating image digests
+var imageVerifiedRules = map[string]bool{
+	"tekton files update":      true,
+	"bundle.Dockerfile update": true,
+}
+
+// imageDigestReference matches image references pinned to a digest, with an
+// optional tag, e.g. quay.io/org/image:v1@sha256:... or busybox@sha256:...
+// A port is only recognized in front of a path, busybox:1@sha256:... is tagged.
+var imageDigestReference = regexp.MustCompile(`([a-zA-Z0-9][a-zA-Z0-9.\-]*(?:(?::[0-9]+)?(?:/[a-z0-9._\-]+)+)?)(?::[a-zA-Z0-9_][a-zA-Z0-9_.\-]{0,127})?@(sha256:[a-f0-9]{64})`)
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+type imageDigest struct {
+	Registry   string
+	Repository string
+	Digest     string
+}
+
+func (i imageDigest) String() string {
+	return i.Registry + "/" + i.Repository + "@" + i.Digest
+}
+
+// parseImageDigests returns all digest pinned image references in a line.
+// Names without a registry host refer to Docker Hub, single component names
+// of Docker Hub to its library, e.g. docker.io/library/busybox.
+func parseImageDigests(line string) []imageDigest {
+	images := []imageDigest{}
+	for _, match := range imageDigestReference.FindAllStringSubmatch(line, -1) {
+		registry, repository, found := strings.Cut(match[1], "/")
+		if !found || !strings.ContainsAny(registry, ".:") && registry != "localhost" {
+			registry, repository = dockerHubRegistry, match[1]
+		}
+		if registry == dockerHubRegistry && !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+		images = append(images, imageDigest{Registry: registry, Repository: repository, Digest: match[2]})
+	}
+	return images
+}
+
+// newImageDigests returns images added by the patches that were not
+// referenced before.
+func newImageDigests(changes []*github.CommitFile) []imageDigest {
+	removed := make(map[string]bool)
+	added := []imageDigest{}
+	for _, change := range changes {
+		for _, line := range strings.Split(change.GetPatch(), "\n") {
+			switch {
+			case strings.HasPrefix(line, "-"):
+				for _, image := range parseImageDigests(line) {
+					removed[image.String()] = true
+				}
+			case strings.HasPrefix(line, "+"):
+				added = append(added, parseImageDigests(line)...)
+			}
+		}
+	}
+
+	images := []imageDigest{}
+	seen := make(map[string]bool)
+	for _, image := range added {
+		if removed[image.String()] || seen[image.String()] {
+			continue
+		}
+		seen[image.String()] = true
+		images = append(images, image)
+	}
+	return images
+}
+
+// registryClient queries manifests through the OCI distribution API. It
+// authenticates with a static token, basic auth or the token service
+// announced by the registry, anonymously when no credentials are configured.
+type registryClient struct {
+	httpClient *http.Client
+	auth       map[string]RegistryAuth
+	// Authorization header values per registry/repository
+	authorizations map[string]string
+}
+
+func newRegistryClient(verification ImageVerification) *registryClient {
+	client := &registryClient{
+		httpClient: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: cassetteTransport(&retryTransport{base: http.DefaultTransport}),
+		},
+		auth:           make(map[string]RegistryAuth),
+		authorizations: make(map[string]string),
+	}
+	for _, registry := range verification.Registries {
+		client.auth[registry.Host] = registry
+	}
+	return client
+}
+
+func readSecret(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (c *registryClient) endpoint(registry string) string {
+	scheme := "https"
+	if c.auth[registry].Insecure {
+		scheme = "http"
+	}
+	if registry == dockerHubRegistry {
+		registry = "registry-1.docker.io"
+	}
+	return scheme + "://" + registry
+}
+
+func (c *registryClient) send(ctx context.Context, method, target, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return c.httpClient.Do(req)
+}
+
+// do sends the request and answers an authentication challenge once.
+func (c *registryClient) do(ctx context.Context, method, target, registry, repository string) (*http.Response, error) {
+	key := registry + "/" + repository
+	authorization := c.authorizations[key]
+	if tokenFile := c.auth[registry].TokenFile; authorization == "" && tokenFile != "" {
+		token, err := readSecret(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read token of %v: %v", registry, err)
+		}
+		authorization = "Bearer " + token
+	}
+
+	resp, err := c.send(ctx, method, target, authorization)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized || c.auth[registry].TokenFile != "" {
+		return resp, nil
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+
+	authorization, err = c.authorize(ctx, registry, repository, challenge)
+	if err != nil {
+		return nil, err
+	}
+	c.authorizations[key] = authorization
+	return c.send(ctx, method, target, authorization)
+}
+
+// authorize answers a WWW-Authenticate challenge with an Authorization
+// header value.
+func (c *registryClient) authorize(ctx context.Context, registry, repository, challenge string) (string, error) {
+	auth := c.auth[registry]
+	password := ""
+	if auth.Username != "" {
+		var err error
+		if password, err = readSecret(auth.PasswordFile); err != nil {
+			return "", fmt.Errorf("unable to read password of %v: %v", registry, err)
+		}
+	}
+
+	scheme, _, _ := strings.Cut(challenge, " ")
+	params := make(map[string]string)
+	for _, match := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if auth.Username == "" {
+			return "", fmt.Errorf("%v requires credentials", registry)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+password)), nil
+	case "bearer":
+		realm, err := url.Parse(params["realm"])
+		if err != nil || params["realm"] == "" {
+			return "", fmt.Errorf("%v announced an invalid token realm %q", registry, params["realm"])
+		}
+		query := realm.Query()
+		if service := params["service"]; service != "" {
+			query.Set("service", service)
+		}
+		scope := params["scope"]
+		if scope == "" {
+			scope = "repository:" + repository + ":pull"
+		}
+		query.Set("scope", scope)
+		realm.RawQuery = query.Encode()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		if auth.Username != "" {
+			req.SetBasicAuth(auth.Username, password)
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("error getting a token for %v: %v", registry, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("error getting a token for %v: %v", registry, resp.Status)
+		}
+		token := struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}{}
+		if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+			return "", fmt.Errorf("unable to decode token of %v: %v", registry, err)
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		if token.Token == "" {
+			return "", fmt.Errorf("token service of %v returned no token", registry)
+		}
+		return "Bearer " + token.Token, nil
+	}
+	return "", fmt.Errorf("%v sent an unsupported authentication challenge %q", registry, challenge)
+}
+
+// manifestDigest tells whether the repository has a manifest under the
+// reference (a digest or a tag) and returns its digest as reported by the
+// registry, if any.
+func (c *registryClient) manifestDigest(ctx context.Context, registry, repository, reference string) (string, bool, error) {
+	target := fmt.Sprintf("%v/v2/%v/manifests/%v", c.endpoint(registry), repository, reference)
+	resp, err := c.do(ctx, http.MethodHead, target, registry, repository)
+	if err != nil {
+		return "", false, fmt.Errorf("error querying %v/%v:%v: %v", registry, repository, reference, err)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Header.Get("Docker-Content-Digest"), true, nil
+	case http.StatusNotFound:
+		return "", false, nil
+	}
+	return "", false, fmt.Errorf("error querying %v/%v:%v: %v", registry, repository, reference, resp.Status)
+}
+
+// get fetches a manifest or a blob of the repository. Missing objects are
+// returned as nil.
+func (c *registryClient) get(ctx context.Context, registry, repository, kind, reference string) ([]byte, error) {
+	target := fmt.Sprintf("%v/v2/%v/%v/%v", c.endpoint(registry), repository, kind, reference)
+	resp, err := c.do(ctx, http.MethodGet, target, registry, repository)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %v/%v %v: %v", registry, repository, reference, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(io.LimitReader(resp.Body, maxRegistryObjectSize))
+	case http.StatusNotFound:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("error fetching %v/%v %v: %v", registry, repository, reference, resp.Status)
+}
+
+// signedDigests returns the digests signed by the cosign signatures of the
+// image. cosign stores signatures under the sha256-<digest>.sig tag, every
+// layer holds a payload naming the signed digest.
+func (c *registryClient) signedDigests(ctx context.Context, image imageDigest) ([]string, error) {
+	manifest, err := c.get(ctx, image.Registry, image.Repository, "manifests", strings.Replace(image.Digest, ":", "-", 1)+".sig")
+	if err != nil || manifest == nil {
+		return nil, err
+	}
+	signature := struct {
+		Layers []struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+		} `json:"layers"`
+	}{}
+	if err := json.Unmarshal(manifest, &signature); err != nil {
+		return nil, fmt.Errorf("unable to parse the signature of %v: %v", image, err)
+	}
+
+	digests := []string{}
+	for _, layer := range signature.Layers {
+		if layer.MediaType != cosignPayloadMediaType {
+			continue
+		}
+		blob, err := c.get(ctx, image.Registry, image.Repository, "blobs", layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if blob == nil {
+			continue
+		}
+		payload := struct {
+			Critical struct {
+				Image struct {
+					Digest string `json:"docker-manifest-digest"`
+				} `json:"image"`
+			} `json:"critical"`
+		}{}
+		if err := json.Unmarshal(blob, &payload); err != nil {
+			return nil, fmt.Errorf("unable to parse the signature payload of %v: %v", image, err)
+		}
+		digests = append(digests, payload.Critical.Image.Digest)
+	}
+	return digests, nil
+}
+
+// verifyImages returns reasons for refusing the images, i.e. images which do
+// not exist, whose manifest has a different digest, or which are not signed
+// with cosign.
+func (c *registryClient) verifyImages(ctx context.Context, images []imageDigest, requireSignature bool) ([]string, error) {
+	reasons := []string{}
+	for _, image := range images {
+		digest, exists, err := c.manifestDigest(ctx, image.Registry, image.Repository, image.Digest)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !exists:
+			reasons = append(reasons, fmt.Sprintf("%v does not exist", image))
+			continue
+		case digest != "" && digest != image.Digest:
+			reasons = append(reasons, fmt.Sprintf("%v resolves to %v", image, digest))
+			continue
+		}
+		if !requireSignature {
+			continue
+		}
+		signed, err := c.signedDigests(ctx, image)
+		switch {
+		case err != nil:
+			return nil, err
+		case len(signed) == 0:
+			reasons = append(reasons, fmt.Sprintf("%v is not signed", image))
+		case !slices.Contains(signed, image.Digest):
+			reasons = append(reasons, fmt.Sprintf("%v is not signed, its signature signs %v", image, strings.Join(signed, ", ")))
+		}
+	}
+	return reasons, nil
+}
+
+// verifyRuleImages checks images newly referenced by PRs matching the image
+// digest rules. The rules are invalidated when any of the images is refused.
+func verifyRuleImages(ctx context.Context, client *github.Client, pr *github.PullRequest, rules []ruleTrace, changes []*github.CommitFile) error {
+	verify := false
+	for _, rule := range rules {
+		if imageVerifiedRules[rule.Name] && rule.applies() {
+			verify = true
+		}
+	}
+	if !verify {
+		return nil
+	}
+
+	changes, err := withPatches(ctx, client, pr, changes)
+	if err != nil {
+		return err
+	}
+	images := newImageDigests(changes)
+	reasons, err := newRegistryClient(config.Spec.ImageVerification).verifyImages(ctx, images, config.Spec.ImageVerification.RequireSignature)
+	if err != nil {
+		return err
+	}
+	refused := len(reasons) > 0
+	if !refused {
+		reasons = append(reasons, fmt.Sprintf("%v new image(s) verified", len(images)))
+	}
+
+	for i := range rules {
+		if !imageVerifiedRules[rules[i].Name] || !rules[i].applies() {
+			continue
+		}
+		if refused {
+			rules[i].Valid = false
+		}
+		rules[i].Reasons = append(rules[i].Reasons, reasons...)
+	}
+	return nil
+}
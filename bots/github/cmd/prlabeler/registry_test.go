@@ -0,0 +1,168 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testRegistryToken = "registry-token"
+
+func registryDigest(n int) string {
+	return fmt.Sprintf("sha256:%064x", n)
+}
+
+// fakeRegistry serves manifests and blobs of a single repository through the
+// OCI distribution API, behind a bearer token service.
+type fakeRegistry struct {
+	// Digests reported for manifests by reference
+	manifests map[string]string
+	// Bodies of manifests (signatures) and blobs by reference
+	objects map[string]string
+}
+
+// sign adds a cosign signature of the image signing the digest.
+func (f *fakeRegistry) sign(image, signed string, n int) {
+	payload := registryDigest(n)
+	f.manifests[strings.Replace(image, ":", "-", 1)+".sig"] = registryDigest(n + 1)
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"layers": []map[string]string{{"mediaType": cosignPayloadMediaType, "digest": payload}},
+	})
+	f.objects[strings.Replace(image, ":", "-", 1)+".sig"] = string(manifest)
+	f.objects[payload] = fmt.Sprintf(`{"critical": {"identity": {"docker-reference": "org/image"}, "image": {"docker-manifest-digest": %q}}}`, signed)
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		if r.URL.Query().Get("scope") != "repository:org/image:pull" {
+			http.Error(w, "unexpected scope", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"token": %q}`, testRegistryToken)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+testRegistryToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%v/token",service="registry"`, r.Host))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	kind, reference, found := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v2/org/image/"), "/")
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	switch kind {
+	case "manifests":
+		digest, exists := f.manifests[reference]
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, f.objects[reference])
+		}
+	case "blobs":
+		blob, exists := f.objects[reference]
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, blob)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestVerifyImages(t *testing.T) {
+	signed, missing, mismatching, unsigned, wronglySigned := registryDigest(1), registryDigest(2), registryDigest(3), registryDigest(4), registryDigest(5)
+	registry := &fakeRegistry{
+		manifests: map[string]string{
+			signed:        signed,
+			mismatching:   registryDigest(6),
+			unsigned:      unsigned,
+			wronglySigned: wronglySigned,
+		},
+		objects: map[string]string{},
+	}
+	registry.sign(signed, signed, 10)
+	registry.sign(wronglySigned, signed, 20)
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	image := func(digest string) imageDigest {
+		return imageDigest{Registry: host, Repository: "org/image", Digest: digest}
+	}
+	tests := []struct {
+		name             string
+		digest           string
+		requireSignature bool
+		reasons          []string
+	}{
+		{"present", unsigned, false, []string{}},
+		{"present and signed", signed, true, []string{}},
+		{"missing", missing, false, []string{fmt.Sprintf("%v does not exist", image(missing))}},
+		{"mismatching digest", mismatching, false, []string{fmt.Sprintf("%v resolves to %v", image(mismatching), registryDigest(6))}},
+		{"missing signature", unsigned, true, []string{fmt.Sprintf("%v is not signed", image(unsigned))}},
+		{"mismatching signature", wronglySigned, true, []string{fmt.Sprintf("%v is not signed, its signature signs %v", image(wronglySigned), signed)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newRegistryClient(ImageVerification{Registries: []RegistryAuth{{Host: host, Insecure: true}}})
+			reasons, err := client.verifyImages(context.Background(), []imageDigest{image(test.digest)}, test.requireSignature)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(reasons, test.reasons) {
+				t.Errorf("expected %q, got %q", test.reasons, reasons)
+			}
+		})
+	}
+}
+
+func TestParseImageDigests(t *testing.T) {
+	digest := registryDigest(1)
+	tests := []struct {
+		line   string
+		images []string
+	}{
+		{"+  image: quay.io/org/image:v1@" + digest, []string{"quay.io/org/image@" + digest}},
+		{"+  image: localhost:5000/image@" + digest, []string{"localhost:5000/image@" + digest}},
+		{"+FROM busybox@" + digest, []string{"docker.io/library/busybox@" + digest}},
+		{"+FROM busybox:1@" + digest, []string{"docker.io/library/busybox@" + digest}},
+		{"+FROM docker.io/busybox:1.36@" + digest, []string{"docker.io/library/busybox@" + digest}},
+		{"+FROM org/image@" + digest + " AS builder", []string{"docker.io/org/image@" + digest}},
+		{"+  image: quay.io/org/image:v1", []string{}},
+	}
+	for _, test := range tests {
+		images := []string{}
+		for _, image := range parseImageDigests(test.line) {
+			images = append(images, image.String())
+		}
+		if !reflect.DeepEqual(images, test.images) {
+			t.Errorf("%q: expected %q, got %q", test.line, test.images, images)
+		}
+	}
+}
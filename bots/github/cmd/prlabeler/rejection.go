@@ -0,0 +1,213 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v76/github"
+	"k8s.io/klog/v2"
+)
+
+// Marker of the comment explaining a rejection. It records since when the
+// PR is rejected, markers of older prlabeler versions do not.
+var rejectionMarker = regexp.MustCompile(`<!-- prlabeler rejection(?: since=(\S+))? -->`)
+
+func rejectionMarkerFor(since time.Time) string {
+	return fmt.Sprintf("<!-- prlabeler rejection since=%v -->", since.UTC().Format(time.RFC3339))
+}
+
+// rejectionComment returns the comment explaining the rejection and since
+// when the PR is rejected according to it, nil when there is none.
+func rejectionComment(comments []*github.IssueComment) (*github.IssueComment, time.Time) {
+	for i := len(comments) - 1; i >= 0; i-- {
+		match := rejectionMarker.FindStringSubmatch(comments[i].GetBody())
+		if match == nil {
+			continue
+		}
+		if since, err := time.Parse(time.RFC3339, match[1]); err == nil {
+			return comments[i], since
+		}
+		return comments[i], comments[i].GetCreatedAt().Time
+	}
+	return nil, time.Time{}
+}
+
+// rejected tells whether a rule matches the PR but none applies.
+func rejected(decision *prDecision) bool {
+	matched := false
+	for _, rule := range decision.Rules {
+		if rule.applies() {
+			return false
+		}
+		matched = matched || rule.Matched
+	}
+	return matched
+}
+
+// rejectedFiles lists files violating the matched rules.
+func rejectedFiles(decision *prDecision) []string {
+	files := []string{}
+	for _, rule := range decision.Rules {
+		if !rule.Matched || rule.Valid {
+			continue
+		}
+		files = append(files, rule.OffendingFiles...)
+		if rule.OffendingFile != "" {
+			files = append(files, rule.OffendingFile)
+		}
+	}
+	sort.Strings(files)
+	return slices.Compact(files)
+}
+
+func rejectionMessage(decision *prDecision, rejectedSince, closeOn time.Time) string {
+	matched := []string{}
+	for _, rule := range decision.Rules {
+		if rule.Matched {
+			matched = append(matched, rule.Name)
+		}
+	}
+	message := fmt.Sprintf("This PR looks like a %v but fails validation, prlabeler does not reconcile it.", strings.Join(matched, " or "))
+	if files := rejectedFiles(decision); len(files) > 0 {
+		message += "\n\nOffending files:\n- " + strings.Join(files, "\n- ")
+	}
+	message += "\n\nReasons:\n- " + strings.Join(validationFailures(decision), "\n- ")
+	if !closeOn.IsZero() {
+		message += fmt.Sprintf("\n\nThe PR will be closed on %v unless it passes validation by then.", closeOn.Format(time.DateOnly))
+	}
+	return message + "\n\n" + rejectionMarkerFor(rejectedSince)
+}
+
+// clearRejection forgets the rejection of a PR passing validation again, so
+// a later rejection is timed from scratch. The rejection comment is edited to
+// drop its marker.
+func clearRejection(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest, decision *prDecision, actions prActions) error {
+	if state := decision.State; state != nil && !state.RejectedSince.IsZero() {
+		if err := updatePRState(ctx, client, pr, func(state *prState) { state.RejectedSince = time.Time{} }); err != nil {
+			return err
+		}
+	}
+	if !config.Spec.Rejection.Comment || !actions.Comment {
+		return nil
+	}
+	comments, err := getPRComments(ctx, client, owner, repo, pr.GetNumber())
+	if err != nil {
+		return err
+	}
+	existing, _ := rejectionComment(comments)
+	if existing == nil {
+		return nil
+	}
+	klog.InfoS("Clearing the rejection", "number", pr.GetNumber())
+	body := "This PR passes validation now, the rejection is cleared."
+	_, _, err = client.Issues.EditComment(ctx, owner, repo, existing.GetID(), &github.IssueComment{Body: github.String(body)})
+	return err
+}
+
+// rejectPR takes the configured actions on a bot PR failing validation. The
+// rejection comment is kept up to date as the PR changes, the PR is closed
+// once it has been rejected for long enough. Since when the PR is rejected is
+// kept in the PR state, or in the marker of the rejection comment.
+func rejectPR(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest, decision *prDecision, actions prActions) error {
+	cfg := config.Spec.Rejection
+	if cfg == nil {
+		return nil
+	}
+	if !rejected(decision) {
+		return clearRejection(ctx, client, owner, repo, pr, decision, actions)
+	}
+	prNum := pr.GetNumber()
+
+	if cfg.Label != "" && len(missingPRLabels(pr, []string{cfg.Label})) > 0 {
+		klog.InfoS("Labeling rejected PR", "number", prNum, "label", cfg.Label)
+		if _, _, err := client.Issues.AddLabelsToIssue(ctx, owner, repo, prNum, []string{cfg.Label}); err != nil {
+			return fmt.Errorf("error adding %v label: %v", cfg.Label, err)
+		}
+	}
+	notifications.notify(eventRejected, pr, pr.GetHead().GetSHA(), validationFailures(decision)...)
+
+	var existing *github.IssueComment
+	rejectedSince := runClock.Now()
+	if cfg.Comment {
+		comments, err := getPRComments(ctx, client, owner, repo, prNum)
+		if err != nil {
+			return err
+		}
+		var since time.Time
+		if existing, since = rejectionComment(comments); existing != nil {
+			rejectedSince = since
+		}
+	}
+	if state := decision.State; state != nil {
+		if state.RejectedSince.IsZero() {
+			if err := updatePRState(ctx, client, pr, func(state *prState) { state.RejectedSince = rejectedSince }); err != nil {
+				return err
+			}
+		} else {
+			rejectedSince = state.RejectedSince
+		}
+	}
+	var closeOn time.Time
+	if cfg.CloseAfter > 0 {
+		closeOn = rejectedSince.Add(cfg.CloseAfter)
+	}
+
+	if cfg.Comment && actions.Comment {
+		body := rejectionMessage(decision, rejectedSince, closeOn)
+		switch {
+		case existing == nil:
+			klog.InfoS("Explaining the rejection", "number", prNum)
+			if _, _, err := client.Issues.CreateComment(ctx, owner, repo, prNum, &github.IssueComment{Body: github.String(body)}); err != nil {
+				return err
+			}
+		case existing.GetBody() != body:
+			klog.InfoS("Updating the rejection", "number", prNum)
+			if _, _, err := client.Issues.EditComment(ctx, owner, repo, existing.GetID(), &github.IssueComment{Body: github.String(body)}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if closeOn.IsZero() || runClock.Now().Before(closeOn) {
+		return nil
+	}
+	if decision.Freeze != "" {
+		klog.InfoS("Change freeze in effect, not closing", "number", prNum, "freeze", decision.Freeze)
+		return nil
+	}
+	klog.InfoS("Closing rejected PR", "number", prNum, "rejectedSince", rejectedSince)
+	// Outside of active hours the PR is closed without the explanation
+	if actions.Comment {
+		message := fmt.Sprintf("Closing, the PR has failed validation since %v.", rejectedSince.Format(time.DateOnly))
+		if _, _, err := client.Issues.CreateComment(ctx, owner, repo, prNum, &github.IssueComment{Body: github.String(message)}); err != nil {
+			return err
+		}
+	}
+	if _, _, err := client.PullRequests.Edit(ctx, owner, repo, prNum, &github.PullRequest{State: github.String("closed")}); err != nil {
+		return fmt.Errorf("error closing PR: %v", err)
+	}
+	pr.State = github.String("closed")
+	notifications.notify(eventClosed, pr, "closed")
+	return nil
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v76/github"
+)
+
+var (
+	passingRule = ruleTrace{Name: "go.sum only", Validator: "files", Matched: true, Valid: true}
+	failingRule = ruleTrace{Name: "konflux references update", Validator: "validateKonfluxReferences", Matched: true, OffendingFile: ".tekton/images-mirror-set.yaml"}
+	reasonsRule = ruleTrace{Name: "go module update", Validator: "validateUpdateGoModules", Matched: true, OffendingFiles: []string{"main.go", "go.mod"}, Reasons: []string{"main.go is not allowed"}}
+	otherRule   = ruleTrace{Name: "bundle.Dockerfile update", Validator: "validateBundle"}
+)
+
+func TestRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []ruleTrace
+		rejected bool
+	}{
+		{"no rules", nil, false},
+		{"no rule matches", []ruleTrace{otherRule}, false},
+		{"a rule applies", []ruleTrace{failingRule, passingRule}, false},
+		{"matched rules fail", []ruleTrace{otherRule, failingRule, reasonsRule}, true},
+	}
+	for _, test := range tests {
+		if rejected := rejected(&prDecision{Rules: test.rules}); rejected != test.rejected {
+			t.Errorf("%v: expected rejected %v, got %v", test.name, test.rejected, rejected)
+		}
+	}
+}
+
+func TestRejectedFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []ruleTrace
+		files []string
+	}{
+		{"no rules", nil, []string{}},
+		{"valid and unmatched rules", []ruleTrace{passingRule, otherRule}, []string{}},
+		{"offending file", []ruleTrace{failingRule}, []string{".tekton/images-mirror-set.yaml"}},
+		{"sorted and compacted", []ruleTrace{reasonsRule, failingRule, {Matched: true, OffendingFile: "go.mod"}}, []string{".tekton/images-mirror-set.yaml", "go.mod", "main.go"}},
+	}
+	for _, test := range tests {
+		if files := rejectedFiles(&prDecision{Rules: test.rules}); !reflect.DeepEqual(files, test.files) {
+			t.Errorf("%v: expected %q, got %q", test.name, test.files, files)
+		}
+	}
+}
+
+// setRejection configures the rejection for the duration of the test.
+func setRejection(t *testing.T, rejection *Rejection) {
+	previous := config
+	config = &PRLabelerConfig{}
+	config.Spec.Rejection = rejection
+	t.Cleanup(func() { config = previous })
+}
+
+func TestRejectPRIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+	clock := setClock(t, start)
+	setRejection(t, &Rejection{Comment: true, Label: "needs-human-review", CloseAfter: 48 * time.Hour})
+	issues, client := newFakeIssues(t)
+	pr := testPR(1, "abc")
+	decision := &prDecision{Rules: []ruleTrace{failingRule}}
+	actions := prActions{Approve: true, Comment: true}
+	run := func() []string {
+		t.Helper()
+		if err := rejectPR(ctx, client, "org", "repo", pr, decision, actions); err != nil {
+			t.Fatal(err)
+		}
+		pr.Labels = nil
+		for _, label := range issues.labels[1] {
+			pr.Labels = append(pr.Labels, &github.Label{Name: github.String(label)})
+		}
+		return issues.takeWrites()
+	}
+
+	if writes := run(); len(writes) != 2 {
+		t.Fatalf("expected the PR to be labeled and the rejection explained, got %q", writes)
+	}
+	clock.now = start.Add(time.Hour)
+	if writes := run(); len(writes) != 0 {
+		t.Fatalf("expected no writes on the second run, got %q", writes)
+	}
+	if comments := issues.commentsOf(1); len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "closed on 2025-03-05") {
+		t.Fatalf("expected a single rejection comment announcing the close, got %v", comments)
+	}
+
+	// The PR passes again, the rejection is cleared
+	decision.Rules = []ruleTrace{passingRule}
+	clock.now = start.Add(24 * time.Hour)
+	if writes := run(); len(writes) != 1 || !strings.HasPrefix(writes[0], "PATCH /repos/org/repo/issues/comments/") {
+		t.Fatalf("expected the rejection comment to be cleared, got %q", writes)
+	}
+	if writes := run(); len(writes) != 0 {
+		t.Fatalf("expected the rejection to be cleared once, got %q", writes)
+	}
+
+	// A later rejection is timed from scratch and does not close the PR
+	decision.Rules = []ruleTrace{failingRule}
+	clock.now = start.Add(30 * 24 * time.Hour)
+	if writes := run(); len(writes) != 1 || writes[0] != "POST /repos/org/repo/issues/1/comments" {
+		t.Fatalf("expected a new rejection comment only, got %q", writes)
+	}
+	if issues.closed[1] {
+		t.Fatalf("the PR was closed without a warning")
+	}
+
+	clock.now = start.Add(32*24*time.Hour + time.Minute)
+	if writes := run(); len(writes) != 2 || !issues.closed[1] {
+		t.Fatalf("expected the PR to be closed with a comment, got %q", writes)
+	}
+}
+
+func TestRejectPRClosesWithoutComments(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+	clock := setClock(t, start)
+	setStateFile(t)
+	setRejection(t, &Rejection{CloseAfter: 48 * time.Hour})
+	issues, client := newFakeIssues(t)
+	pr := testPR(2, "abc")
+	// Outside of active hours
+	actions := prActions{Approve: true}
+	run := func() []string {
+		t.Helper()
+		state, err := loadPRState(ctx, client, pr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decision := &prDecision{Rules: []ruleTrace{failingRule}, State: state}
+		if err := rejectPR(ctx, client, "org", "repo", pr, decision, actions); err != nil {
+			t.Fatal(err)
+		}
+		return issues.takeWrites()
+	}
+
+	if writes := run(); len(writes) != 0 {
+		t.Fatalf("expected no writes, got %q", writes)
+	}
+	clock.now = start.Add(49 * time.Hour)
+	if writes := run(); len(writes) != 1 || writes[0] != "PATCH /repos/org/repo/pulls/2" {
+		t.Fatalf("expected the PR to be closed without a comment, got %q", writes)
+	}
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/go-github/v76/github"
+	"k8s.io/klog/v2"
+)
+
+// Statuses of PRs on the dashboard
+const (
+	dashboardReconciled = "reconciled"
+	dashboardWithheld   = "withheld"
+	dashboardFrozen     = "frozen"
+	dashboardRejected   = "rejected"
+	dashboardUnmatched  = "unmatched"
+)
+
+// Order of the dashboard sections, PRs that need humans first
+var dashboardStatuses = []string{dashboardRejected, dashboardWithheld, dashboardUnmatched, dashboardFrozen, dashboardReconciled}
+
+// dashboardEntry summarizes a PR of an allowed author.
+type dashboardEntry struct {
+	Repository string    `json:"repository"`
+	Number     int       `json:"number"`
+	Title      string    `json:"title"`
+	Author     string    `json:"author"`
+	URL        string    `json:"url"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Status     string    `json:"status"`
+	// Rule reconciling the PR, or the matched rules of a rejected one
+	Rules   []string `json:"rules,omitempty"`
+	Reasons []string `json:"reasons,omitempty"`
+	// PR head of the last approval
+	ApprovedSHA string `json:"approvedSHA,omitempty"`
+}
+
+type dashboard struct {
+	GeneratedAt time.Time        `json:"generatedAt"`
+	Entries     []dashboardEntry `json:"entries"`
+	Failures    []runFailure     `json:"failures,omitempty"`
+}
+
+// dashboardEntryFor summarizes the decision, the statuses are needed only
+// for PRs a rule applies to.
+func dashboardEntryFor(decision *prDecision, pr *github.PullRequest, statuses []statusTrace) dashboardEntry {
+	entry := dashboardEntry{
+		Repository:  decision.Organization + "/" + decision.Repository,
+		Number:      decision.Number,
+		Title:       decision.Title,
+		Author:      decision.Author,
+		URL:         pr.GetHTMLURL(),
+		CreatedAt:   pr.GetCreatedAt().Time,
+		ApprovedSHA: decision.ApprovedSHA,
+	}
+	for _, rule := range decision.Rules {
+		if rule.applies() {
+			entry.Rules = []string{rule.Name}
+			break
+		}
+		if rule.Matched {
+			entry.Rules = append(entry.Rules, rule.Name)
+		}
+	}
+	if state := decision.State; state != nil && entry.ApprovedSHA == "" {
+		entry.ApprovedSHA = state.ApprovedSHA
+	}
+
+	switch failures := validationFailures(decision); {
+	case len(decision.Files) == 0:
+		entry.Status, entry.Reasons = dashboardUnmatched, []string{"no changed files"}
+	case rejected(decision):
+		entry.Status, entry.Reasons = dashboardRejected, failures
+	case len(entry.Rules) == 0:
+		entry.Status, entry.Reasons = dashboardUnmatched, []string{"the PR does not match any rule"}
+	case decision.Freeze != "":
+		entry.Status, entry.Reasons = dashboardFrozen, []string{decision.Freeze}
+	default:
+		withheld := slices.Clone(decision.TrustViolations)
+		for _, context := range decision.Policy.missingRequiredContexts(statuses) {
+			withheld = append(withheld, fmt.Sprintf("required context %v has not succeeded", context))
+		}
+		entry.Status, entry.Reasons = dashboardReconciled, nil
+		if len(withheld) > 0 {
+			entry.Status, entry.Reasons = dashboardWithheld, withheld
+		}
+		for _, status := range statuses {
+			if status.Class == statusBaseBranchBroken {
+				entry.Reasons = append(entry.Reasons, status.Reason)
+			}
+		}
+	}
+	return entry
+}
+
+// buildDashboard evaluates open PRs of all the repositories. It only reads
+// from GitHub.
+func buildDashboard(ctx context.Context) *dashboard {
+	allowedAuthors := getAllowedAuthors()
+	board := &dashboard{GeneratedAt: runClock.Now()}
+	summary := &runSummary{}
+
+	for _, repo := range checkRepositoryAccess(ctx, summary) {
+		items := strings.Split(repo, "/")
+		client, err := newGitHubClient(ctx, items[0], items[1])
+		if err != nil {
+			summary.add(repo, 0, err)
+			continue
+		}
+		clear(loadedOwners)
+		clear(baseBranchStatuses)
+		prs, err := listOpenPRs(ctx, client, items[0], items[1])
+		if err != nil {
+			summary.add(repo, 0, err)
+			continue
+		}
+		for _, pr := range prs {
+			if !allowedAuthors[pr.GetUser().GetLogin()] {
+				continue
+			}
+			decision, err := evaluatePR(ctx, client, items[0], items[1], pr, allowedAuthors)
+			if err != nil {
+				summary.add(repo, pr.GetNumber(), err)
+				continue
+			}
+			var statuses []statusTrace
+			if slices.ContainsFunc(decision.Rules, ruleTrace.applies) {
+				if statuses, err = getStatusTraces(ctx, client, items[0], items[1], pr.GetNumber(), pr, decision.Policy); err != nil {
+					summary.add(repo, pr.GetNumber(), err)
+					continue
+				}
+			}
+			board.Entries = append(board.Entries, dashboardEntryFor(decision, pr, statuses))
+		}
+	}
+	board.Failures = summary.Failures
+	return board
+}
+
+func (d *dashboard) print(w io.Writer) {
+	fmt.Fprintf(w, "prlabeler dashboard, generated %v\n", d.GeneratedAt.Format(time.RFC3339))
+	for _, status := range dashboardStatuses {
+		entries := []dashboardEntry{}
+		for _, entry := range d.Entries {
+			if entry.Status == status {
+				entries = append(entries, entry)
+			}
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%v (%v):\n", strings.ToUpper(status[:1])+status[1:], len(entries))
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "  PR\tAUTHOR\tAGE\tRULES\tTITLE\n")
+		for _, entry := range entries {
+			age := d.GeneratedAt.Sub(entry.CreatedAt).Truncate(time.Hour)
+			fmt.Fprintf(tw, "  %v#%v\t%v\t%v\t%v\t%v\n", entry.Repository, entry.Number, entry.Author, age, strings.Join(entry.Rules, ", "), entry.Title)
+			for _, reason := range entry.Reasons {
+				fmt.Fprintf(tw, "  \t\t\t\t- %v\n", reason)
+			}
+		}
+		tw.Flush()
+	}
+	if len(d.Entries) == 0 {
+		fmt.Fprintf(w, "\nNo open PRs of allowed authors\n")
+	}
+	if len(d.Failures) > 0 {
+		summary := &runSummary{Failures: d.Failures}
+		fmt.Fprintf(w, "\n")
+		summary.report(w)
+	}
+}
+
+// reportMain prints the dashboard of open PRs of all the repositories.
+func reportMain(args []string) {
+	fs := commandFlagSet("report")
+	fs.StringSliceVar(&repositories, "repository", repositories, "List of repositories to process")
+	fs.StringSliceVar(&withAuthors, "with-author", withAuthors, "List of authors to reconcile (accepting PRs with `[auto]` string set in their summary)")
+	fs.StringSliceVar(&goModuleAllowlist, "go-module-allowlist", goModuleAllowlist, "List of go module path patterns whose bumps within the same minor version get approved")
+	fs.StringSliceVar(&botProfileNames, "bot-profile", botProfileNames, "List of dependency bot profiles to reconcile (mintmaker, renovate, dependabot)")
+	fs.BoolVar(&useGraphQL, "graphql", useGraphQL, "Fetch open PRs with their files, comments and statuses in bulk through GraphQL (REST is used as a fallback)")
+	fs.StringVar(&recordFilename, "record", recordFilename, "Record all GitHub API requests and responses to a cassette file (tokens are redacted)")
+	fs.StringVar(&replayFilename, "replay", replayFilename, "Replay GitHub API responses from a cassette file instead of accessing GitHub")
+	fs.StringVar(&tokenFilename, "token-file", tokenFilename, "File with the GitHub token, read again when it changes (GITHUB_TOKEN is used when not set). Credentials of the configuration file take precedence.")
+	fs.Parse(args)
+
+	applyGlobalFlags()
+	validateFlags()
+	applyConfig()
+
+	if config.Spec.State != nil {
+		store, err := newStateStore(config.Spec.State)
+		if err != nil {
+			klog.Error(err)
+			os.Exit(1)
+		}
+		states = readOnlyStore{store}
+	}
+
+	board := buildDashboard(context.Background())
+	writeOutput(board, board.print)
+	if len(board.Failures) > 0 {
+		os.Exit(1)
+	}
+}
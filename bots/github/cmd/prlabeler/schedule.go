@@ -0,0 +1,238 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"time"
+	// The image is built from scratch, without the time zone database
+	_ "time/tzdata"
+)
+
+const (
+	recurrenceWeekly  = "weekly"
+	recurrenceMonthly = "monthly"
+	recurrenceYearly  = "yearly"
+)
+
+var weekdays = map[string]time.Weekday{
+	"Sun": time.Sunday,
+	"Mon": time.Monday,
+	"Tue": time.Tuesday,
+	"Wed": time.Wednesday,
+	"Thu": time.Thursday,
+	"Fri": time.Friday,
+	"Sat": time.Saturday,
+}
+
+// clock provides the current time. Replace runClock to evaluate the
+// schedule at a different time.
+type clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+var runClock clock = realClock{}
+
+func (s Schedule) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(s.Timezone)
+}
+
+// parseScheduleTime parses either a date or an RFC3339 time. A date-only end
+// covers the whole day.
+func parseScheduleTime(value string, loc *time.Location, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// bounds returns the first occurrence of the window as [start, end).
+func (f FreezeWindow) bounds(loc *time.Location) (time.Time, time.Time, error) {
+	start, err := parseScheduleTime(f.Start, loc, false)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start %q: %v", f.Start, err)
+	}
+	end, err := parseScheduleTime(f.End, loc, true)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid end %q: %v", f.End, err)
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("start %q is not before end %q", f.Start, f.End)
+	}
+	return start, end, nil
+}
+
+// addMonths adds months keeping the wall clock, the day is clamped to the
+// last day of shorter months (Jan 31 is followed by Feb 28, not Mar 3).
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	hour, minute, second := t.Clock()
+	first := time.Date(year, month+time.Month(months), 1, hour, minute, second, t.Nanosecond(), t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return time.Date(first.Year(), first.Month(), day, hour, minute, second, t.Nanosecond(), t.Location())
+}
+
+func (f FreezeWindow) shift(t time.Time, n int) time.Time {
+	switch f.Recurrence {
+	case recurrenceWeekly:
+		return t.AddDate(0, 0, 7*n)
+	case recurrenceMonthly:
+		return addMonths(t, n)
+	case recurrenceYearly:
+		return addMonths(t, 12*n)
+	}
+	return t
+}
+
+// active tells whether the window covers the given time.
+func (f FreezeWindow) active(now time.Time, loc *time.Location) bool {
+	start, end, err := f.bounds(loc)
+	if err != nil {
+		return false
+	}
+	if f.Recurrence == "" {
+		return !now.Before(start) && now.Before(end)
+	}
+	if now.Before(start) {
+		return false
+	}
+
+	// Number of recurrences since the first occurrence. The occurrence
+	// before is checked as well as windows can span the period boundary,
+	// the one after as weeks across a DST change are an hour shorter.
+	n := 0
+	switch f.Recurrence {
+	case recurrenceWeekly:
+		n = int(now.Sub(start) / (7 * 24 * time.Hour))
+	case recurrenceMonthly:
+		n = (now.Year()-start.Year())*12 + int(now.Month()) - int(start.Month())
+	case recurrenceYearly:
+		n = now.Year() - start.Year()
+	}
+	for _, k := range []int{n + 1, n, n - 1} {
+		if k < 0 {
+			continue
+		}
+		if s, e := f.shift(start, k), f.shift(end, k); !now.Before(s) && now.Before(e) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAnyGlob(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (f FreezeWindow) covers(organization, repository, branch string) bool {
+	if len(f.Repositories) > 0 && !matchAnyGlob(f.Repositories, organization+"/"+repository) {
+		return false
+	}
+	if len(f.Branches) > 0 && !matchAnyGlob(f.Branches, branch) {
+		return false
+	}
+	return true
+}
+
+// activeFreeze returns the name of the freeze window covering the branch at
+// the given time, or an empty string.
+func (s Schedule) activeFreeze(organization, repository, branch string, now time.Time) string {
+	loc, err := s.location()
+	if err != nil {
+		return ""
+	}
+	for _, freeze := range s.Freezes {
+		if freeze.covers(organization, repository, branch) && freeze.active(now.In(loc), loc) {
+			return freeze.Name
+		}
+	}
+	return ""
+}
+
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: %v", value, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (h ActiveHours) bounds() (time.Duration, time.Duration, error) {
+	start, err := parseClock(h.Start)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(h.End)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// commentsAllowed tells whether comment generating actions can be performed
+// at the given time. An end before the start spans midnight.
+func (s Schedule) commentsAllowed(now time.Time) bool {
+	if s.ActiveHours == nil {
+		return true
+	}
+	loc, err := s.location()
+	if err != nil {
+		return false
+	}
+	now = now.In(loc)
+
+	if len(s.ActiveHours.Days) > 0 {
+		allowedDay := false
+		for _, day := range s.ActiveHours.Days {
+			if weekdays[day] == now.Weekday() {
+				allowedDay = true
+				break
+			}
+		}
+		if !allowedDay {
+			return false
+		}
+	}
+
+	start, end, err := s.ActiveHours.bounds()
+	if err != nil {
+		return false
+	}
+	sinceMidnight := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	if start <= end {
+		return sinceMidnight >= start && sinceMidnight < end
+	}
+	return sinceMidnight >= start || sinceMidnight < end
+}
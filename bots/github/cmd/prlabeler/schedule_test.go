@@ -0,0 +1,108 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActiveFreeze(t *testing.T) {
+	monthEnd := FreezeWindow{Name: "month end", Start: "2025-01-31", End: "2025-01-31", Recurrence: recurrenceMonthly}
+	leapDay := FreezeWindow{Name: "leap day", Start: "2024-02-29", End: "2024-02-29", Recurrence: recurrenceYearly}
+	// Europe/Prague switches to summer time on 2025-03-30
+	mondays := FreezeWindow{Name: "mondays", Start: "2025-03-24", End: "2025-03-24", Recurrence: recurrenceWeekly}
+	midMonth := FreezeWindow{Name: "mid month", Start: "2025-03-15", End: "2025-03-15", Recurrence: recurrenceMonthly}
+	holidays := FreezeWindow{Name: "holidays", Start: "2025-12-24", End: "2025-12-26"}
+
+	tests := []struct {
+		name     string
+		timezone string
+		freeze   FreezeWindow
+		now      string
+		active   bool
+	}{
+		{"month end in February", "", monthEnd, "2025-02-28T12:00:00Z", true},
+		{"no overflow into March", "", monthEnd, "2025-03-03T12:00:00Z", false},
+		{"month end in March", "", monthEnd, "2025-03-31T12:00:00Z", true},
+		{"month end in April", "", monthEnd, "2025-04-30T12:00:00Z", true},
+		{"day before month end in April", "", monthEnd, "2025-04-29T12:00:00Z", false},
+		{"month end after a short month", "", monthEnd, "2025-05-31T23:59:00Z", true},
+		{"leap day in a common year", "", leapDay, "2025-02-28T12:00:00Z", true},
+		{"no leap day overflow", "", leapDay, "2025-03-01T12:00:00Z", false},
+		{"leap day in a leap year", "", leapDay, "2028-02-29T12:00:00Z", true},
+		{"weekly before the DST change", "Europe/Prague", mondays, "2025-03-23T23:30:00Z", true},
+		{"weekly right after the DST change", "Europe/Prague", mondays, "2025-03-30T22:30:00Z", true},
+		{"weekly Sunday after the DST change", "Europe/Prague", mondays, "2025-03-30T21:30:00Z", false},
+		{"weekly Monday end after the DST change", "Europe/Prague", mondays, "2025-03-31T21:59:00Z", true},
+		{"weekly Tuesday after the DST change", "Europe/Prague", mondays, "2025-03-31T22:00:00Z", false},
+		{"monthly across the DST change", "Europe/Prague", midMonth, "2025-04-14T22:00:00Z", true},
+		{"monthly before the local day", "Europe/Prague", midMonth, "2025-04-14T21:59:00Z", false},
+		{"dates in the schedule timezone", "America/New_York", holidays, "2025-12-27T03:00:00Z", true},
+		{"before the local start", "America/New_York", holidays, "2025-12-24T04:00:00Z", false},
+		{"after the local end", "America/New_York", holidays, "2025-12-27T05:00:00Z", false},
+		{"dates in UTC by default", "", holidays, "2025-12-27T03:00:00Z", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now, err := time.Parse(time.RFC3339, test.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setClock(t, now)
+			schedule := Schedule{Timezone: test.timezone, Freezes: []FreezeWindow{test.freeze}}
+			freeze := schedule.activeFreeze("org", "repo", "main", runClock.Now())
+			if active := freeze != ""; active != test.active {
+				t.Errorf("expected the freeze to be active %v, got %v", test.active, active)
+			}
+		})
+	}
+}
+
+func TestCommentsAllowed(t *testing.T) {
+	workdays := &ActiveHours{Start: "08:00", End: "18:00", Days: []string{"Mon", "Tue", "Wed", "Thu", "Fri"}}
+	nights := &ActiveHours{Start: "22:00", End: "06:00"}
+
+	tests := []struct {
+		name     string
+		timezone string
+		hours    *ActiveHours
+		now      string
+		allowed  bool
+	}{
+		{"local morning", "America/New_York", workdays, "2025-07-01T13:00:00Z", true},
+		{"local evening", "America/New_York", workdays, "2025-07-01T23:00:00Z", false},
+		{"local Monday while Sunday in UTC", "Asia/Tokyo", workdays, "2025-07-06T23:30:00Z", true},
+		{"local Saturday while Friday in UTC", "Asia/Tokyo", workdays, "2025-07-04T23:30:00Z", false},
+		{"standard time shifts the hours", "America/New_York", workdays, "2025-01-06T12:30:00Z", false},
+		{"spanning midnight", "", nights, "2025-07-01T23:00:00Z", true},
+		{"after the end past midnight", "", nights, "2025-07-01T07:00:00Z", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now, err := time.Parse(time.RFC3339, test.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setClock(t, now)
+			schedule := Schedule{Timezone: test.timezone, ActiveHours: test.hours}
+			if allowed := schedule.commentsAllowed(runClock.Now()); allowed != test.allowed {
+				t.Errorf("expected comments allowed %v, got %v", test.allowed, allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	reconcileInterval = 10 * time.Minute
+	// Fraction of the interval randomly added to every wait
+	reconcileJitter = 0.2
+	// Readiness fails when the last successful reconcile is older
+	maxReconcileAge = time.Hour
+	listenAddress   = ":8080"
+
+	leaderElection          bool
+	leaderElectionNamespace string
+	leaderElectionName      = "prlabeler"
+	leaseDuration           = 15 * time.Second
+	renewDeadline           = 10 * time.Second
+	retryPeriod             = 2 * time.Second
+)
+
+// jittered returns the interval extended by a random fraction of up to
+// jitter.
+func jittered(interval time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Float64()*jitter*float64(interval))
+}
+
+// serveState tracks what the readiness probe reports.
+type serveState struct {
+	mutex sync.Mutex
+	// Since when reconciles are expected, i.e. the start or when the
+	// replica started leading
+	expectedSince time.Time
+	lastSuccess   time.Time
+	leading       bool
+
+	// Result of the last GitHub probe, probes are cached for a while
+	probeMutex sync.Mutex
+	probedAt   time.Time
+	probeError error
+}
+
+func (s *serveState) setLeading(leading bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if leading && !s.leading {
+		s.expectedSince = time.Now()
+	}
+	s.leading = leading
+}
+
+func (s *serveState) reconciled(summary *runSummary) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !summary.failed() {
+		s.lastSuccess = time.Now()
+	}
+}
+
+// probeGitHub checks the GitHub APIs of all the repositories are
+// reachable. The result is cached for half a minute so frequent probes do not
+// hammer GitHub.
+func (s *serveState) probeGitHub(ctx context.Context) error {
+	s.probeMutex.Lock()
+	defer s.probeMutex.Unlock()
+	if time.Since(s.probedAt) < 30*time.Second {
+		return s.probeError
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	s.probedAt = time.Now()
+	s.probeError = nil
+
+	probed := make(map[string]bool)
+	for _, repo := range repositories {
+		organization, repository, _ := strings.Cut(repo, "/")
+		server := serverFor(config, organization, repository)
+		if probed[server.apiURL()] {
+			continue
+		}
+		probed[server.apiURL()] = true
+		if err := probeServer(ctx, server); err != nil {
+			s.probeError = fmt.Errorf("GitHub is not reachable: %v", err)
+			return s.probeError
+		}
+	}
+	return nil
+}
+
+// probeServer requests the rate limit, which does not count against it.
+func probeServer(ctx context.Context, server *GitHubServer) error {
+	transport, err := server.transport()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.apiURL()+"rate_limit", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Transport: cassetteTransport(transport)}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("GET %v: %v", req.URL, resp.Status)
+	}
+	return nil
+}
+
+// ready fails when GitHub is not reachable or the leader has not reconciled
+// successfully for too long. Replicas that do not lead only need GitHub.
+func (s *serveState) ready(ctx context.Context) error {
+	if err := s.probeGitHub(ctx); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.leading {
+		return nil
+	}
+	last := s.lastSuccess
+	if last.Before(s.expectedSince) {
+		last = s.expectedSince
+	}
+	if age := time.Since(last); age > maxReconcileAge {
+		return fmt.Errorf("last successful reconcile is %v old", age.Round(time.Second))
+	}
+	return nil
+}
+
+func (s *serveState) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := s.ready(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// inClusterLeaderElector elects the leader through a Lease of the cluster
+// the pod runs in.
+func inClusterLeaderElector() (*leaderElector, error) {
+	client, err := inClusterKubeClient()
+	if err != nil {
+		return nil, err
+	}
+	namespace := leaderElectionNamespace
+	if namespace == "" {
+		if namespace, err = inClusterNamespace(); err != nil {
+			return nil, err
+		}
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine the leader identity: %v", err)
+	}
+	return newLeaderElector(client, namespace, leaderElectionName, identity), nil
+}
+
+func validateServeFlags() {
+	if replayFilename != "" {
+		klog.Error("--replay can not be used when serving")
+		os.Exit(1)
+	}
+	if reconcileInterval <= 0 || reconcileJitter < 0 {
+		klog.Error("--interval has to be positive and --jitter can not be negative")
+		os.Exit(1)
+	}
+	if maxReconcileAge <= reconcileInterval+time.Duration(reconcileJitter*float64(reconcileInterval)) {
+		klog.Error("--max-reconcile-age has to be longer than the interval with the jitter")
+		os.Exit(1)
+	}
+	if leaderElection && !(retryPeriod < renewDeadline && renewDeadline < leaseDuration) {
+		klog.Error("--retry-period has to be shorter than --renew-deadline, which has to be shorter than --lease-duration")
+		os.Exit(1)
+	}
+}
+
+// serveMain reconciles periodically until terminated, serving health probes
+// in the meantime.
+func serveMain(args []string) {
+	fs := commandFlagSet("serve")
+	addReconcileFlags(fs)
+	fs.DurationVar(&reconcileInterval, "interval", reconcileInterval, "Interval between reconciles")
+	fs.Float64Var(&reconcileJitter, "jitter", reconcileJitter, "Fraction of the interval randomly added to every wait")
+	fs.DurationVar(&maxReconcileAge, "max-reconcile-age", maxReconcileAge, "Readiness fails when the last successful reconcile is older")
+	fs.StringVar(&listenAddress, "listen-address", listenAddress, "Address serving /healthz and /readyz")
+	fs.BoolVar(&leaderElection, "leader-election", leaderElection, "Reconcile only while holding a Kubernetes Lease so replicas never act at once")
+	fs.StringVar(&leaderElectionNamespace, "leader-election-namespace", leaderElectionNamespace, "Namespace of the Lease (the pod namespace by default)")
+	fs.StringVar(&leaderElectionName, "leader-election-name", leaderElectionName, "Name of the Lease")
+	fs.DurationVar(&leaseDuration, "lease-duration", leaseDuration, "How long other replicas wait before taking over a Lease that is not renewed")
+	fs.DurationVar(&renewDeadline, "renew-deadline", renewDeadline, "How long the leader keeps leading when the Lease can not be renewed")
+	fs.DurationVar(&retryPeriod, "retry-period", retryPeriod, "Interval between attempts to acquire or renew the Lease")
+	fs.Parse(args)
+
+	applyGlobalFlags()
+	validateFlags()
+	validateServeFlags()
+	applyConfig()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	state := &serveState{expectedSince: time.Now()}
+	server := &http.Server{Addr: listenAddress, Handler: state.handler()}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.Error(err)
+			os.Exit(1)
+		}
+	}()
+
+	var elector *leaderElector
+	electorDone := make(chan struct{})
+	if leaderElection {
+		var err error
+		if elector, err = inClusterLeaderElector(); err != nil {
+			klog.Error(err)
+			os.Exit(1)
+		}
+		go func() {
+			elector.run(ctx)
+			close(electorDone)
+		}()
+	} else {
+		close(electorDone)
+	}
+
+	klog.InfoS("Serving", "address", listenAddress, "interval", reconcileInterval, "leaderElection", leaderElection)
+	for {
+		reconcileCtx, leading := ctx, true
+		if elector != nil {
+			reconcileCtx, leading = elector.leader()
+		}
+		state.setLeading(leading)
+
+		wait := retryPeriod
+		if leading {
+			started := time.Now()
+			summary := reconcile(reconcileCtx)
+			writeOutput(summary, summary.report)
+			state.reconciled(summary)
+			klog.InfoS("Reconciled", "duration", time.Since(started).Round(time.Second), "failed", summary.failed())
+			wait = jittered(reconcileInterval, reconcileJitter)
+		}
+
+		select {
+		case <-ctx.Done():
+			klog.Info("Shutting down")
+			// Wait for the Lease to be released
+			<-electorDone
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+			return
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/v76/github"
+)
+
+const githubAPIURL = "https://api.github.com/"
+
+// serverFor returns the server hosting the repository, nil for github.com.
+func serverFor(cfg *PRLabelerConfig, organization, repository string) *GitHubServer {
+	for i, server := range cfg.Spec.Servers {
+		if matchAnyGlob(server.Organizations, organization) || matchAnyGlob(server.Repositories, organization+"/"+repository) {
+			return &cfg.Spec.Servers[i]
+		}
+	}
+	return nil
+}
+
+// apiURL returns the REST API root with a trailing slash.
+func (s *GitHubServer) apiURL() string {
+	if s == nil {
+		return githubAPIURL
+	}
+	return strings.TrimSuffix(s.BaseURL, "/") + "/"
+}
+
+// transport returns a transport trusting the CA bundle of the server.
+func (s *GitHubServer) transport() (http.RoundTripper, error) {
+	if s == nil || s.CAFile == "" {
+		return http.DefaultTransport, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	bundle, err := os.ReadFile(s.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the CA bundle of %v: %v", s.BaseURL, err)
+	}
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("no certificates found in the CA bundle %v", s.CAFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return transport, nil
+}
+
+// newClient returns a client of the server. Unlike
+// github.Client.WithEnterpriseURLs the URLs are used as they are, so any
+// server (e.g. an httptest one) can be pointed at.
+func (s *GitHubServer) newClient(httpClient *http.Client) (*github.Client, error) {
+	client := github.NewClient(httpClient)
+	if s == nil {
+		return client, nil
+	}
+	baseURL, err := url.Parse(s.apiURL())
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL %q: %v", s.BaseURL, err)
+	}
+	uploadURL := baseURL
+	if s.UploadURL != "" {
+		if uploadURL, err = url.Parse(strings.TrimSuffix(s.UploadURL, "/") + "/"); err != nil {
+			return nil, fmt.Errorf("invalid upload URL %q: %v", s.UploadURL, err)
+		}
+	}
+	client.BaseURL, client.UploadURL = baseURL, uploadURL
+	return client, nil
+}
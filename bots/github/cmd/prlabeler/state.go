@@ -0,0 +1,640 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v76/github"
+	"k8s.io/klog/v2"
+)
+
+const (
+	stateBackendFile      = "file"
+	stateBackendConfigMap = "configmap"
+	stateBackendMarkers   = "markers"
+
+	// Version of the state schema, state of newer versions is refused
+	stateVersion = 1
+	// Failures and commands kept per PR, the oldest are dropped
+	maxStateRecords = 50
+)
+
+// prState is what prlabeler remembers about a PR between runs.
+type prState struct {
+	Version int `json:"version"`
+	// Retests posted per context
+	Retests map[string]int `json:"retests,omitempty"`
+	// Commands posted on the PR
+	Commands []postedCommand `json:"commands,omitempty"`
+	// PR head at the last approval
+	ApprovedSHA string `json:"approvedSHA,omitempty"`
+	// Approvals granted to the approved head
+	ApprovedLabels []string `json:"approvedLabels,omitempty"`
+	// PR head whose approval was revoked
+	RevokedSHA string `json:"revokedSHA,omitempty"`
+	// Since when the PR fails validation, zero when it passes
+	RejectedSince time.Time `json:"rejectedSince,omitzero"`
+	// Escalation stages fired
+	Escalations []string `json:"escalations,omitempty"`
+	// Failed contexts, the oldest first
+	Failures  []failureRecord `json:"failures,omitempty"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// posted tells whether the command was posted on the PR head.
+func (s *prState) posted(sha, command string) bool {
+	for _, posted := range s.Commands {
+		if posted.SHA == sha && posted.Command == command {
+			return true
+		}
+	}
+	return false
+}
+
+// lastPosted returns when the command was last posted, zero when never.
+func (s *prState) lastPosted(command string) time.Time {
+	var last time.Time
+	for _, posted := range s.Commands {
+		if posted.Command == command && posted.PostedAt.After(last) {
+			last = posted.PostedAt
+		}
+	}
+	return last
+}
+
+type postedCommand struct {
+	Command  string    `json:"command"`
+	SHA      string    `json:"sha"`
+	PostedAt time.Time `json:"postedAt"`
+}
+
+type failureRecord struct {
+	Context string    `json:"context"`
+	SHA     string    `json:"sha"`
+	State   string    `json:"state"`
+	Time    time.Time `json:"time"`
+}
+
+// stateMigrations upgrade state to the next version, indexed by the version
+// they upgrade from. Version 0 is state written before it was versioned.
+var stateMigrations = map[int]func(*prState){
+	0: func(*prState) {},
+}
+
+// decodeState decodes state of any known version and upgrades it to the
+// current one.
+func decodeState(data []byte) (*prState, error) {
+	state := &prState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Version > stateVersion {
+		return nil, fmt.Errorf("state version %v is newer than the supported version %v", state.Version, stateVersion)
+	}
+	for state.Version < stateVersion {
+		migrate, exists := stateMigrations[state.Version]
+		if !exists {
+			return nil, fmt.Errorf("no migration of state version %v", state.Version)
+		}
+		migrate(state)
+		state.Version++
+	}
+	return state, nil
+}
+
+// stateStore keeps PR state between runs. Saved state is persisted by Flush.
+type stateStore interface {
+	// Load returns the PR state, empty when there is none.
+	Load(ctx context.Context, client *github.Client, pr *github.PullRequest) (*prState, error)
+	Save(ctx context.Context, client *github.Client, pr *github.PullRequest, state *prState) error
+	// List returns PRs of the repository (organization/repository) with a
+	// state.
+	List(ctx context.Context, repository string) ([]int, error)
+	Delete(ctx context.Context, repository string, number int) error
+	Flush(ctx context.Context) error
+}
+
+// Store of the run, nil when prlabeler is stateless
+var states stateStore
+
+func newStateStore(cfg *StateStore) (stateStore, error) {
+	switch cfg.Backend {
+	case stateBackendFile:
+		return &documentStore{document: &fileDocument{filename: cfg.File}}, nil
+	case stateBackendConfigMap:
+		client, err := inClusterKubeClient()
+		if err != nil {
+			return nil, err
+		}
+		namespace := cfg.ConfigMap.Namespace
+		if namespace == "" {
+			if namespace, err = inClusterNamespace(); err != nil {
+				return nil, err
+			}
+		}
+		return &documentStore{document: &configMapDocument{client: client, namespace: namespace, name: cfg.ConfigMap.Name}}, nil
+	case stateBackendMarkers:
+		return &markerStore{pending: make(map[string]pendingMarker), comments: make(map[string]*github.IssueComment)}, nil
+	}
+	return nil, fmt.Errorf("unknown state backend %q", cfg.Backend)
+}
+
+// readOnlyStore serves state of the wrapped store and drops all the writes.
+// Commands that only inspect PRs (explain, report) use it so they never
+// change the state.
+type readOnlyStore struct {
+	stateStore
+}
+
+func (readOnlyStore) Save(context.Context, *github.Client, *github.PullRequest, *prState) error {
+	return nil
+}
+
+func (readOnlyStore) Delete(context.Context, string, int) error {
+	return nil
+}
+
+func (readOnlyStore) Flush(context.Context) error {
+	return nil
+}
+
+// stateKey identifies a PR in keyed documents. ConfigMap keys allow
+// [-._a-zA-Z0-9] only.
+func stateKey(repository string, number int) string {
+	return fmt.Sprintf("%v.%v", strings.ReplaceAll(repository, "/", "."), number)
+}
+
+func prRepository(pr *github.PullRequest) string {
+	return pr.GetBase().GetRepo().GetOwner().GetLogin() + "/" + pr.GetBase().GetRepo().GetName()
+}
+
+// stateDocument persists encoded states keyed by stateKey as a whole.
+type stateDocument interface {
+	read(ctx context.Context) (map[string]string, error)
+	// write applies the changes to the latest document, an empty value
+	// deletes the key.
+	write(ctx context.Context, changes map[string]string) error
+}
+
+// documentStore keeps states in a single document read once per store and
+// written on Flush.
+type documentStore struct {
+	document stateDocument
+	entries  map[string]string
+	changes  map[string]string
+}
+
+func (s *documentStore) load(ctx context.Context) error {
+	if s.entries != nil {
+		return nil
+	}
+	entries, err := s.document.read(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading state: %v", err)
+	}
+	s.entries = entries
+	s.changes = make(map[string]string)
+	return nil
+}
+
+func (s *documentStore) Load(ctx context.Context, _ *github.Client, pr *github.PullRequest) (*prState, error) {
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	key := stateKey(prRepository(pr), pr.GetNumber())
+	data, exists := s.entries[key]
+	if !exists {
+		return &prState{Version: stateVersion}, nil
+	}
+	state, err := decodeState([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding state of %v: %v", key, err)
+	}
+	return state, nil
+}
+
+func (s *documentStore) Save(ctx context.Context, _ *github.Client, pr *github.PullRequest, state *prState) error {
+	if err := s.load(ctx); err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	key := stateKey(prRepository(pr), pr.GetNumber())
+	if s.entries[key] != string(data) {
+		s.entries[key] = string(data)
+		s.changes[key] = string(data)
+	}
+	return nil
+}
+
+func (s *documentStore) List(ctx context.Context, repository string) ([]int, error) {
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	prefix := strings.ReplaceAll(repository, "/", ".") + "."
+	numbers := []int{}
+	for key := range s.entries {
+		if rest, found := strings.CutPrefix(key, prefix); found {
+			if number, err := strconv.Atoi(rest); err == nil {
+				numbers = append(numbers, number)
+			}
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+func (s *documentStore) Delete(ctx context.Context, repository string, number int) error {
+	if err := s.load(ctx); err != nil {
+		return err
+	}
+	key := stateKey(repository, number)
+	delete(s.entries, key)
+	s.changes[key] = ""
+	return nil
+}
+
+// Flush writes the changes. The document is read again by the next run as
+// other replicas may have changed it meanwhile.
+func (s *documentStore) Flush(ctx context.Context) error {
+	if len(s.changes) > 0 {
+		if err := s.document.write(ctx, s.changes); err != nil {
+			return fmt.Errorf("error writing state: %v", err)
+		}
+	}
+	s.entries, s.changes = nil, nil
+	return nil
+}
+
+// fileDocument is a local JSON file, written atomically.
+type fileDocument struct {
+	filename string
+}
+
+func (d *fileDocument) read(_ context.Context) (map[string]string, error) {
+	entries := make(map[string]string)
+	data, err := os.ReadFile(d.filename)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing %v: %v", d.filename, err)
+	}
+	for key, value := range raw {
+		entries[key] = string(value)
+	}
+	return entries, nil
+}
+
+func (d *fileDocument) write(ctx context.Context, changes map[string]string) error {
+	entries, err := d.read(ctx)
+	if err != nil {
+		return err
+	}
+	for key, value := range changes {
+		if value == "" {
+			delete(entries, key)
+		} else {
+			entries[key] = value
+		}
+	}
+	raw := make(map[string]json.RawMessage)
+	for key, value := range entries {
+		raw[key] = json.RawMessage(value)
+	}
+	data, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(d.filename), ".prlabeler-state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.filename)
+}
+
+// configMap is a v1 ConfigMap.
+type configMap struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   leaseMetadata     `json:"metadata"`
+	Data       map[string]string `json:"data,omitempty"`
+}
+
+// configMapDocument keeps states in a ConfigMap, one key per PR. ConfigMaps
+// are limited to 1MiB.
+type configMapDocument struct {
+	client    *kubeClient
+	namespace string
+	name      string
+}
+
+func (d *configMapDocument) path() string {
+	return fmt.Sprintf("/api/v1/namespaces/%v/configmaps/%v", d.namespace, d.name)
+}
+
+func (d *configMapDocument) get(ctx context.Context) (*configMap, error) {
+	current := &configMap{}
+	code, err := d.client.do(ctx, http.MethodGet, d.path(), nil, current)
+	if code == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+func (d *configMapDocument) read(ctx context.Context) (map[string]string, error) {
+	current, err := d.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]string)
+	if current != nil {
+		for key, value := range current.Data {
+			entries[key] = value
+		}
+	}
+	return entries, nil
+}
+
+// write applies the changes to the latest ConfigMap, retrying on conflicts.
+func (d *configMapDocument) write(ctx context.Context, changes map[string]string) error {
+	for attempt := 0; ; attempt++ {
+		current, err := d.get(ctx)
+		if err != nil {
+			return err
+		}
+		method, path := http.MethodPut, d.path()
+		if current == nil {
+			method, path = http.MethodPost, fmt.Sprintf("/api/v1/namespaces/%v/configmaps", d.namespace)
+			current = &configMap{Metadata: leaseMetadata{Name: d.name, Namespace: d.namespace}}
+		}
+		current.APIVersion, current.Kind = "v1", "ConfigMap"
+		if current.Data == nil {
+			current.Data = make(map[string]string)
+		}
+		for key, value := range changes {
+			if value == "" {
+				delete(current.Data, key)
+			} else {
+				current.Data[key] = value
+			}
+		}
+		code, err := d.client.do(ctx, method, path, current, nil)
+		// Creation races are conflicts too
+		if code == http.StatusConflict && attempt < 3 {
+			klog.InfoS("State ConfigMap changed meanwhile, retrying", "namespace", d.namespace, "name", d.name)
+			continue
+		}
+		return err
+	}
+}
+
+// Marker of the PR comment keeping the state
+var stateMarker = regexp.MustCompile(`<!-- prlabeler state: ([A-Za-z0-9+/=]+) -->`)
+
+type pendingMarker struct {
+	client *github.Client
+	pr     *github.PullRequest
+	state  *prState
+}
+
+// markerStore keeps the state in a hidden marker of a PR comment of the bot,
+// edited in place. The state goes away with the PR.
+type markerStore struct {
+	pending map[string]pendingMarker
+	// State comments by stateKey, nil when the PR has none
+	comments map[string]*github.IssueComment
+}
+
+func (s *markerStore) comment(ctx context.Context, client *github.Client, pr *github.PullRequest) (*github.IssueComment, error) {
+	key := stateKey(prRepository(pr), pr.GetNumber())
+	if comment, exists := s.comments[key]; exists {
+		return comment, nil
+	}
+	bot, err := getBotLogin(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := getPRComments(ctx, client, pr.GetBase().GetRepo().GetOwner().GetLogin(), pr.GetBase().GetRepo().GetName(), pr.GetNumber())
+	if err != nil {
+		return nil, err
+	}
+	var found *github.IssueComment
+	for _, comment := range comments {
+		// Only the bot's comments are trusted
+		if strings.EqualFold(comment.GetUser().GetLogin(), bot) && stateMarker.MatchString(comment.GetBody()) {
+			found = comment
+		}
+	}
+	s.comments[key] = found
+	return found, nil
+}
+
+func (s *markerStore) Load(ctx context.Context, client *github.Client, pr *github.PullRequest) (*prState, error) {
+	key := stateKey(prRepository(pr), pr.GetNumber())
+	if pending, exists := s.pending[key]; exists {
+		return pending.state, nil
+	}
+	comment, err := s.comment(ctx, client, pr)
+	if err != nil {
+		return nil, err
+	}
+	if comment == nil {
+		return &prState{Version: stateVersion}, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(stateMarker.FindStringSubmatch(comment.GetBody())[1])
+	if err != nil {
+		return nil, fmt.Errorf("error decoding state of %v: %v", key, err)
+	}
+	state, err := decodeState(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding state of %v: %v", key, err)
+	}
+	return state, nil
+}
+
+func (s *markerStore) Save(_ context.Context, client *github.Client, pr *github.PullRequest, state *prState) error {
+	s.pending[stateKey(prRepository(pr), pr.GetNumber())] = pendingMarker{client: client, pr: pr, state: state}
+	return nil
+}
+
+// List returns no PRs, markers are removed together with the PR.
+func (s *markerStore) List(context.Context, string) ([]int, error) {
+	return nil, nil
+}
+
+func (s *markerStore) Delete(context.Context, string, int) error {
+	return nil
+}
+
+func (s *markerStore) Flush(ctx context.Context) error {
+	errs := []error{}
+	for key, pending := range s.pending {
+		data, err := json.Marshal(pending.state)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		body := fmt.Sprintf("prlabeler keeps its state of this PR here, please do not edit.\n\n<!-- prlabeler state: %v -->", base64.StdEncoding.EncodeToString(data))
+		comment, err := s.comment(ctx, pending.client, pending.pr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		owner, repo, prNum := pending.pr.GetBase().GetRepo().GetOwner().GetLogin(), pending.pr.GetBase().GetRepo().GetName(), pending.pr.GetNumber()
+		switch {
+		case comment == nil:
+			comment, _, err = pending.client.Issues.CreateComment(ctx, owner, repo, prNum, &github.IssueComment{Body: github.String(body)})
+		case comment.GetBody() != body:
+			comment, _, err = pending.client.Issues.EditComment(ctx, owner, repo, comment.GetID(), &github.IssueComment{Body: github.String(body)})
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error writing state of %v: %v", key, err))
+			continue
+		}
+		delete(s.pending, key)
+	}
+	clear(s.comments)
+	return errors.Join(errs...)
+}
+
+// loadPRState returns the PR state, nil for stateless runs.
+func loadPRState(ctx context.Context, client *github.Client, pr *github.PullRequest) (*prState, error) {
+	if states == nil {
+		return nil, nil
+	}
+	return states.Load(ctx, client, pr)
+}
+
+// updatePRState applies the change to the PR state. It is a no-op for
+// stateless runs. State is saved only when the change changed it, so PR
+// markers are not edited every run.
+func updatePRState(ctx context.Context, client *github.Client, pr *github.PullRequest, change func(*prState)) error {
+	if states == nil {
+		return nil
+	}
+	state, err := states.Load(ctx, client, pr)
+	if err != nil {
+		return err
+	}
+	before, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	change(state)
+	state.Version = stateVersion
+	after, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if string(before) == string(after) {
+		return nil
+	}
+	state.UpdatedAt = runClock.Now()
+	return states.Save(ctx, client, pr, state)
+}
+
+// recordFailures appends failed contexts of the PR head not recorded yet.
+func recordFailures(state *prState, sha string, traces []statusTrace) {
+	for _, trace := range traces {
+		if trace.State != "failure" && trace.State != "error" {
+			continue
+		}
+		recorded := false
+		for _, failure := range state.Failures {
+			if failure.Context == trace.Context && failure.SHA == sha && failure.Time.Equal(trace.UpdatedAt) {
+				recorded = true
+				break
+			}
+		}
+		if !recorded {
+			state.Failures = append(state.Failures, failureRecord{Context: trace.Context, SHA: sha, State: trace.State, Time: trace.UpdatedAt})
+		}
+	}
+	if len(state.Failures) > maxStateRecords {
+		state.Failures = state.Failures[len(state.Failures)-maxStateRecords:]
+	}
+}
+
+// recordCommand records a command posted on the PR head.
+func recordCommand(state *prState, sha, command string) {
+	state.Commands = append(state.Commands, postedCommand{Command: command, SHA: sha, PostedAt: runClock.Now()})
+	if len(state.Commands) > maxStateRecords {
+		state.Commands = state.Commands[len(state.Commands)-maxStateRecords:]
+	}
+}
+
+// collectPRStates drops state of PRs of the repository that are no longer
+// open. PRs missing from the open ones are checked first, the PR listing is
+// not paginated.
+func collectPRStates(ctx context.Context, client *github.Client, organization, repository string, open map[int]bool) error {
+	if states == nil {
+		return nil
+	}
+	fullName := organization + "/" + repository
+	numbers, err := states.List(ctx, fullName)
+	if err != nil {
+		return err
+	}
+	for _, number := range numbers {
+		if open[number] {
+			continue
+		}
+		pr, _, err := client.PullRequests.Get(ctx, organization, repository, number)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		if err == nil && pr.GetState() != "closed" {
+			continue
+		}
+		klog.InfoS("Dropping state of a closed PR", "repository", fullName, "number", number)
+		if err := states.Delete(ctx, fullName, number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v76/github"
+)
+
+func testPR(number int, sha string) *github.PullRequest {
+	return &github.PullRequest{
+		Number: github.Int(number),
+		Base: &github.PullRequestBranch{
+			Ref:  github.String("main"),
+			Repo: &github.Repository{Name: github.String("repo"), Owner: &github.User{Login: github.String("org")}},
+		},
+		Head: &github.PullRequestBranch{SHA: github.String(sha)},
+	}
+}
+
+// setStateFile makes the run stateful with a file store for the duration of
+// the test.
+func setStateFile(t *testing.T) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "state.json")
+	previous := states
+	states = &documentStore{document: &fileDocument{filename: filename}}
+	t.Cleanup(func() { states = previous })
+	return filename
+}
+
+func TestUpdatePRStateSkipsUnchangedState(t *testing.T) {
+	ctx := context.Background()
+	clock := setClock(t, time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC))
+	filename := setStateFile(t)
+	pr := testPR(1, "abc")
+
+	if err := updatePRState(ctx, nil, pr, func(state *prState) { recordCommand(state, "abc", "/retest") }); err != nil {
+		t.Fatal(err)
+	}
+	if err := states.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The next run changes nothing but the time
+	clock.now = clock.now.Add(time.Hour)
+	if err := updatePRState(ctx, nil, pr, func(state *prState) { state.ApprovedSHA = "" }); err != nil {
+		t.Fatal(err)
+	}
+	if changes := states.(*documentStore).changes; len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+	if err := states.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := os.ReadFile(filename); string(current) != string(written) {
+		t.Errorf("state file rewritten:\n%s\nwas:\n%s", current, written)
+	}
+
+	state, err := loadPRState(ctx, nil, pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.posted("abc", "/retest") || state.posted("def", "/retest") {
+		t.Errorf("unexpected posted commands %+v", state.Commands)
+	}
+	if last := state.lastPosted("/retest"); !last.Equal(time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected last retest %v", last)
+	}
+	if !state.UpdatedAt.Equal(time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("updatedAt moved to %v without a change", state.UpdatedAt)
+	}
+}
+
+func TestRetestsDecidedFromState(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	setClock(t, now.Add(-time.Hour))
+	setStateFile(t)
+	pr := testPR(2, "abc")
+	if err := updatePRState(ctx, nil, pr, func(state *prState) { recordCommand(state, "abc", "/retest") }); err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadPRState(ctx, nil, pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending := []*github.RepoStatus{{
+		Context:     github.String("Red Hat Konflux / operator-on-pull-request"),
+		State:       github.String("pending"),
+		Description: github.String("Job Red Hat Konflux / operator-on-pull-request is running"),
+		UpdatedAt:   &github.Timestamp{Time: now.Add(-5 * time.Hour)},
+	}}
+	traces := classifyStatuses(pending, state.lastPosted("/retest"), now, &builtinDefaultBranchPolicy)
+	if traces[0].Class != statusRecentlyRetested {
+		t.Errorf("expected %v, got %v (%v)", statusRecentlyRetested, traces[0].Class, traces[0].Reason)
+	}
+	traces = classifyStatuses(pending, state.lastPosted("/retest"), now.Add(4*time.Hour), &builtinDefaultBranchPolicy)
+	if traces[0].Class != statusPendingTooLong {
+		t.Errorf("expected %v, got %v (%v)", statusPendingTooLong, traces[0].Class, traces[0].Reason)
+	}
+}
+
+func TestDecodeState(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+		want    *prState
+	}{
+		{
+			name: "current version",
+			data: `{"version": 1, "approvedSHA": "abc"}`,
+			want: &prState{Version: 1, ApprovedSHA: "abc"},
+		},
+		{
+			name: "unversioned state is migrated",
+			data: `{"approvedSHA": "abc", "escalations": ["label"]}`,
+			want: &prState{Version: stateVersion, ApprovedSHA: "abc", Escalations: []string{"label"}},
+		},
+		{
+			name:    "newer version is refused",
+			data:    fmt.Sprintf(`{"version": %v, "approvedSHA": "abc"}`, stateVersion+1),
+			wantErr: true,
+		},
+		{
+			name:    "malformed state",
+			data:    `{"version": "1"}`,
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, err := decodeState([]byte(test.data))
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", state)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state.Version != test.want.Version || state.ApprovedSHA != test.want.ApprovedSHA || len(state.Escalations) != len(test.want.Escalations) {
+				t.Errorf("expected %+v, got %+v", test.want, state)
+			}
+		})
+	}
+}
+
+func TestCollectPRStates(t *testing.T) {
+	ctx := context.Background()
+	setClock(t, time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC))
+	setStateFile(t)
+	for _, number := range []int{1, 2, 3, 4} {
+		if err := updatePRState(ctx, nil, testPR(number, "abc"), func(state *prState) { state.ApprovedSHA = "abc" }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// PR 1 is on the listed page of open PRs, PR 2 is closed, PR 3 is gone
+	// and PR 4 is open beyond the listed page
+	requested := []int{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/org/repo/pulls/{number}", func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, pathNumber(r))
+		switch pathNumber(r) {
+		case 2:
+			fmt.Fprint(w, `{"number": 2, "state": "closed"}`)
+		case 4:
+			fmt.Fprint(w, `{"number": 4, "state": "open"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	client := testGitHubClient(t, mux)
+
+	if err := collectPRStates(ctx, client, "org", "repo", map[int]bool{1: true}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(requested) != "[2 3 4]" {
+		t.Errorf("expected PRs 2, 3 and 4 to be checked, got %v", requested)
+	}
+	numbers, err := states.List(ctx, "org/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(numbers) != "[1 4]" {
+		t.Errorf("expected state of PRs 1 and 4 to be kept, got %v", numbers)
+	}
+}
+
+func TestReadOnlyStoreDropsWrites(t *testing.T) {
+	ctx := context.Background()
+	setClock(t, time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC))
+	filename := setStateFile(t)
+	pr := testPR(1, "abc")
+	if err := updatePRState(ctx, nil, pr, func(state *prState) { state.ApprovedSHA = "abc" }); err != nil {
+		t.Fatal(err)
+	}
+	if err := states.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	states = readOnlyStore{&documentStore{document: &fileDocument{filename: filename}}}
+	if err := updatePRState(ctx, nil, pr, func(state *prState) { state.RevokedSHA = "abc" }); err != nil {
+		t.Fatal(err)
+	}
+	if err := states.Delete(ctx, "org/repo", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := states.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := os.ReadFile(filename); string(current) != string(written) {
+		t.Errorf("state file written through a read-only store:\n%s\nwas:\n%s", current, written)
+	}
+	state, err := loadPRState(ctx, nil, pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ApprovedSHA != "abc" || state.RevokedSHA != "" {
+		t.Errorf("unexpected state %+v", state)
+	}
+}
@@ -0,0 +1,401 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v76/github"
+)
+
+// verdict is the outcome of a validator.
+type verdict struct {
+	Valid bool
+	// Files violating the validator
+	OffendingFiles []string
+	Reasons        []string
+}
+
+// validationInput is the PR validators judge. Patches are fetched on demand.
+type validationInput struct {
+	client  *github.Client
+	pr      *github.PullRequest
+	changes []*github.CommitFile
+}
+
+func (in *validationInput) files() []string {
+	files := []string{}
+	for _, change := range in.changes {
+		files = append(files, change.GetFilename())
+	}
+	return files
+}
+
+// patchedChanges returns the changes with their patches.
+func (in *validationInput) patchedChanges(ctx context.Context) ([]*github.CommitFile, error) {
+	changes, err := withPatches(ctx, in.client, in.pr, in.changes)
+	if err != nil {
+		return nil, err
+	}
+	in.changes = changes
+	return changes, nil
+}
+
+// validator decides whether a PR is the kind of change a rule expects.
+type validator interface {
+	Name() string
+	Validate(ctx context.Context, input *validationInput) (verdict, error)
+}
+
+// fileGlobs requires every changed file to match an allowed glob and no
+// denied one.
+type fileGlobs struct {
+	allowed []string
+	denied  []string
+}
+
+func (v fileGlobs) Name() string { return "files" }
+
+func (v fileGlobs) Validate(_ context.Context, input *validationInput) (verdict, error) {
+	result := verdict{Valid: true}
+	for _, file := range input.files() {
+		switch {
+		case !matchAnyFileGlob(v.allowed, file):
+			result.OffendingFiles = append(result.OffendingFiles, file)
+			result.Reasons = append(result.Reasons, fmt.Sprintf("%v does not match any of %v", file, strings.Join(v.allowed, ", ")))
+		case matchAnyFileGlob(v.denied, file):
+			result.OffendingFiles = append(result.OffendingFiles, file)
+			result.Reasons = append(result.Reasons, fmt.Sprintf("%v is denied", file))
+		}
+	}
+	result.Valid = len(result.OffendingFiles) == 0
+	return result, nil
+}
+
+// tektonFiles requires every changed file to be a YAML file under .tekton
+// other than the images mirror set.
+type tektonFiles struct{}
+
+func (v tektonFiles) Name() string { return "tekton-files" }
+
+func (v tektonFiles) Validate(_ context.Context, input *validationInput) (verdict, error) {
+	result := verdict{Valid: true}
+	for _, file := range input.files() {
+		switch {
+		case !strings.HasPrefix(file, ".tekton") || !strings.HasSuffix(file, ".yaml"):
+			result.OffendingFiles = append(result.OffendingFiles, file)
+			result.Reasons = append(result.Reasons, fmt.Sprintf("%v is not a .tekton YAML file", file))
+		case strings.HasSuffix(file, "images-mirror-set.yaml"):
+			result.OffendingFiles = append(result.OffendingFiles, file)
+			result.Reasons = append(result.Reasons, fmt.Sprintf("%v is denied", file))
+		}
+	}
+	result.Valid = len(result.OffendingFiles) == 0
+	return result, nil
+}
+
+// maxFilesChanged limits the number of changed files.
+type maxFilesChanged int
+
+func (v maxFilesChanged) Name() string { return "max-files" }
+
+func (v maxFilesChanged) Validate(_ context.Context, input *validationInput) (verdict, error) {
+	if changed := len(input.changes); changed > int(v) {
+		return verdict{Reasons: []string{fmt.Sprintf("%v files changed, at most %v allowed", changed, int(v))}}, nil
+	}
+	return verdict{Valid: true}, nil
+}
+
+// maxLinesChanged limits the number of added and deleted lines.
+type maxLinesChanged int
+
+func (v maxLinesChanged) Name() string { return "max-lines" }
+
+func (v maxLinesChanged) Validate(_ context.Context, input *validationInput) (verdict, error) {
+	changed := 0
+	for _, change := range input.changes {
+		changed += change.GetAdditions() + change.GetDeletions()
+	}
+	if changed > int(v) {
+		return verdict{Reasons: []string{fmt.Sprintf("%v lines changed, at most %v allowed", changed, int(v))}}, nil
+	}
+	return verdict{Valid: true}, nil
+}
+
+// patchChanges returns the removed and added lines of a unified diff.
+func patchChanges(patch string) ([]string, []string) {
+	removed, added := []string{}, []string{}
+	for _, line := range strings.Split(patch, "\n") {
+		switch {
+		case strings.HasPrefix(line, "---") || strings.HasPrefix(line, "+++"):
+		case strings.HasPrefix(line, "-"):
+			removed = append(removed, line[1:])
+		case strings.HasPrefix(line, "+"):
+			added = append(added, line[1:])
+		}
+	}
+	return removed, added
+}
+
+var digestPattern = regexp.MustCompile(`sha256:[0-9a-f]{64}`)
+
+// onlyDigests requires the changed lines to differ in image digests only.
+type onlyDigests struct{}
+
+func (v onlyDigests) Name() string { return "only-digests" }
+
+func (v onlyDigests) Validate(ctx context.Context, input *validationInput) (verdict, error) {
+	changes, err := input.patchedChanges(ctx)
+	if err != nil {
+		return verdict{}, err
+	}
+	normalize := func(lines []string) []string {
+		normalized := []string{}
+		for _, line := range lines {
+			normalized = append(normalized, digestPattern.ReplaceAllString(line, "sha256:DIGEST"))
+		}
+		sort.Strings(normalized)
+		return normalized
+	}
+	result := verdict{Valid: true}
+	for _, change := range changes {
+		if change.GetPatch() == "" {
+			result.OffendingFiles = append(result.OffendingFiles, change.GetFilename())
+			result.Reasons = append(result.Reasons, fmt.Sprintf("%v has no diff to check", change.GetFilename()))
+			continue
+		}
+		removed, added := patchChanges(change.GetPatch())
+		if !slices.Equal(normalize(removed), normalize(added)) {
+			result.OffendingFiles = append(result.OffendingFiles, change.GetFilename())
+			result.Reasons = append(result.Reasons, fmt.Sprintf("%v changes more than image digests", change.GetFilename()))
+		}
+	}
+	result.Valid = len(result.OffendingFiles) == 0
+	return result, nil
+}
+
+// Tekton task bundle reference pinned by a digest, e.g.
+// value: quay.io/konflux-ci/tekton-catalog/task-init:0.2@sha256:...
+var tektonBundleRef = regexp.MustCompile(`^\s*(-\s+)?value:\s*\S+@sha256:[0-9a-f]{64}\s*$`)
+
+// tektonBundleRefs requires changes of Tekton files to touch task bundle
+// references only.
+type tektonBundleRefs struct{}
+
+func (v tektonBundleRefs) Name() string { return "tekton-bundle-refs" }
+
+func (v tektonBundleRefs) Validate(ctx context.Context, input *validationInput) (verdict, error) {
+	changes, err := input.patchedChanges(ctx)
+	if err != nil {
+		return verdict{}, err
+	}
+	result := verdict{Valid: true}
+	for _, change := range changes {
+		if change.GetPatch() == "" {
+			result.OffendingFiles = append(result.OffendingFiles, change.GetFilename())
+			result.Reasons = append(result.Reasons, fmt.Sprintf("%v has no diff to check", change.GetFilename()))
+			continue
+		}
+		removed, added := patchChanges(change.GetPatch())
+		for _, line := range slices.Concat(removed, added) {
+			if !tektonBundleRef.MatchString(line) {
+				result.OffendingFiles = append(result.OffendingFiles, change.GetFilename())
+				result.Reasons = append(result.Reasons, fmt.Sprintf("%v changes more than task bundle references: %q", change.GetFilename(), strings.TrimSpace(line)))
+				break
+			}
+		}
+	}
+	result.Valid = len(result.OffendingFiles) == 0
+	return result, nil
+}
+
+// commitAuthorship requires all commits to be authored and pushed by the PR
+// author with verified signatures.
+type commitAuthorship struct{}
+
+func (v commitAuthorship) Name() string { return "commit-authorship" }
+
+func (v commitAuthorship) Validate(ctx context.Context, input *validationInput) (verdict, error) {
+	pr := input.pr
+	commits, err := getPRCommits(ctx, input.client, pr.GetBase().GetRepo().GetOwner().GetLogin(), pr.GetBase().GetRepo().GetName(), pr.GetNumber())
+	if err != nil {
+		return verdict{}, err
+	}
+	violations := commitAuthorshipViolations(pr, commits, pr.GetUser().GetLogin())
+	return verdict{Valid: len(violations) == 0, Reasons: violations}, nil
+}
+
+// requiredStatuses requires the contexts to have succeeded on the PR head.
+type requiredStatuses []string
+
+func (v requiredStatuses) Name() string { return "required-status" }
+
+func (v requiredStatuses) Validate(ctx context.Context, input *validationInput) (verdict, error) {
+	pr := input.pr
+	statuses, err := getCommitStatuses(ctx, input.client, pr.GetBase().GetRepo().GetOwner().GetLogin(), pr.GetBase().GetRepo().GetName(), pr.GetNumber(), pr.GetHead().GetSHA())
+	if err != nil {
+		return verdict{}, err
+	}
+	latest := make(map[string]*github.RepoStatus)
+	for _, status := range statuses {
+		if current, exists := latest[status.GetContext()]; !exists || status.GetUpdatedAt().After(current.GetUpdatedAt().Time) {
+			latest[status.GetContext()] = status
+		}
+	}
+	result := verdict{Valid: true}
+	for _, contextName := range v {
+		switch status, exists := latest[contextName]; {
+		case !exists:
+			result.Reasons = append(result.Reasons, fmt.Sprintf("%v has not reported", contextName))
+		case status.GetState() != "success":
+			result.Reasons = append(result.Reasons, fmt.Sprintf("%v is %v", contextName, status.GetState()))
+		}
+	}
+	result.Valid = len(result.Reasons) == 0
+	return result, nil
+}
+
+func validatorNames(validators []validator) string {
+	names := []string{}
+	for _, v := range validators {
+		names = append(names, v.Name())
+	}
+	return strings.Join(names, ", ")
+}
+
+// allOf requires all the validators to pass.
+type allOf []validator
+
+func (v allOf) Name() string { return "all(" + validatorNames(v) + ")" }
+
+func (v allOf) Validate(ctx context.Context, input *validationInput) (verdict, error) {
+	result := verdict{Valid: true}
+	for _, child := range v {
+		childResult, err := child.Validate(ctx, input)
+		if err != nil {
+			return verdict{}, fmt.Errorf("%v: %v", child.Name(), err)
+		}
+		if !childResult.Valid {
+			result.Valid = false
+			result.OffendingFiles = append(result.OffendingFiles, childResult.OffendingFiles...)
+			result.Reasons = append(result.Reasons, childResult.Reasons...)
+		}
+	}
+	sort.Strings(result.OffendingFiles)
+	result.OffendingFiles = slices.Compact(result.OffendingFiles)
+	return result, nil
+}
+
+// anyOf requires at least one of the validators to pass.
+type anyOf []validator
+
+func (v anyOf) Name() string { return "any(" + validatorNames(v) + ")" }
+
+func (v anyOf) Validate(ctx context.Context, input *validationInput) (verdict, error) {
+	result := verdict{}
+	for _, child := range v {
+		childResult, err := child.Validate(ctx, input)
+		if err != nil {
+			return verdict{}, fmt.Errorf("%v: %v", child.Name(), err)
+		}
+		if childResult.Valid {
+			return childResult, nil
+		}
+		result.OffendingFiles = append(result.OffendingFiles, childResult.OffendingFiles...)
+		result.Reasons = append(result.Reasons, childResult.Reasons...)
+	}
+	sort.Strings(result.OffendingFiles)
+	result.OffendingFiles = slices.Compact(result.OffendingFiles)
+	return result, nil
+}
+
+// Validators of the built-in dependency rules
+var (
+	konfluxReferencesValidator    = tektonFiles{}
+	bundleImageShasValidator      = fileGlobs{allowed: []string{"bundle.Dockerfile"}}
+	ubi9MinimalBaseImageValidator = fileGlobs{allowed: []string{"bundle.Dockerfile", "Dockerfile"}}
+)
+
+// build returns the validator the spec declares.
+func (s *ValidatorSpec) build() (validator, error) {
+	validators := []validator{}
+	if len(s.All) > 0 {
+		children, err := buildValidators(s.All)
+		if err != nil {
+			return nil, err
+		}
+		validators = append(validators, allOf(children))
+	}
+	if len(s.Any) > 0 {
+		children, err := buildValidators(s.Any)
+		if err != nil {
+			return nil, err
+		}
+		validators = append(validators, anyOf(children))
+	}
+	if s.Files != nil {
+		if len(s.Files.Allowed) == 0 {
+			return nil, fmt.Errorf("files: at least one allowed glob has to be specified")
+		}
+		for _, pattern := range slices.Concat(s.Files.Allowed, s.Files.Denied) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("files: invalid glob %q", pattern)
+			}
+		}
+		validators = append(validators, fileGlobs{allowed: s.Files.Allowed, denied: s.Files.Denied})
+	}
+	if s.MaxFiles > 0 {
+		validators = append(validators, maxFilesChanged(s.MaxFiles))
+	}
+	if s.MaxLines > 0 {
+		validators = append(validators, maxLinesChanged(s.MaxLines))
+	}
+	if s.OnlyDigests {
+		validators = append(validators, onlyDigests{})
+	}
+	if s.TektonBundleRefs {
+		validators = append(validators, tektonBundleRefs{})
+	}
+	if s.CommitAuthorship {
+		validators = append(validators, commitAuthorship{})
+	}
+	if len(s.RequiredStatuses) > 0 {
+		validators = append(validators, requiredStatuses(s.RequiredStatuses))
+	}
+	if len(validators) != 1 {
+		return nil, fmt.Errorf("exactly one validator has to be specified, got %v", len(validators))
+	}
+	return validators[0], nil
+}
+
+func buildValidators(specs []ValidatorSpec) ([]validator, error) {
+	validators := []validator{}
+	for i := range specs {
+		v, err := specs[i].build()
+		if err != nil {
+			return nil, err
+		}
+		validators = append(validators, v)
+	}
+	return validators, nil
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2025 The Gearhouse Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-github/v76/github"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// commitFile returns a change as listed through REST, GitHub leaves out
+// patches of large and binary diffs.
+func commitFile(name, patch string) *github.CommitFile {
+	return &github.CommitFile{Filename: github.String(name), Patch: github.String(patch)}
+}
+
+func TestBuiltinValidators(t *testing.T) {
+	bundleRef := "@@ -1 +1 @@\n-  value: quay.io/konflux-ci/task-init:0.2@" + testDigest + "\n+  value: quay.io/konflux-ci/task-init:0.2@" + testDigest + "\n"
+	tests := []struct {
+		name      string
+		validator validator
+		changes   []*github.CommitFile
+		valid     bool
+	}{
+		{"tekton files", konfluxReferencesValidator, []*github.CommitFile{commitFile(".tekton/operator-push.yaml", "")}, true},
+		{"nested tekton files", konfluxReferencesValidator, []*github.CommitFile{commitFile(".tekton/pipelines/build.yaml", "")}, true},
+		{"images mirror set", konfluxReferencesValidator, []*github.CommitFile{commitFile(".tekton/images-mirror-set.yaml", "")}, false},
+		{"not yaml", konfluxReferencesValidator, []*github.CommitFile{commitFile(".tekton/README.md", "")}, false},
+		{"outside of tekton", konfluxReferencesValidator, []*github.CommitFile{commitFile("Makefile", "")}, false},
+		{"bundle refs", tektonBundleRefs{}, []*github.CommitFile{commitFile(".tekton/push.yaml", bundleRef)}, true},
+		{"other tekton change", tektonBundleRefs{}, []*github.CommitFile{commitFile(".tekton/push.yaml", "@@ -1 +1 @@\n-  timeout: 1h\n+  timeout: 2h\n")}, false},
+		{"bundle refs without a patch", tektonBundleRefs{}, []*github.CommitFile{commitFile(".tekton/push.yaml", "")}, false},
+		{"digests without a patch", onlyDigests{}, []*github.CommitFile{commitFile("bundle.Dockerfile", "")}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input := &validationInput{pr: testPR(1, "abc"), changes: test.changes}
+			result, err := test.validator.Validate(context.Background(), input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Valid != test.valid {
+				t.Errorf("expected valid=%v, got %+v", test.valid, result)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ require (
 	github.com/google/go-github/v76 v76.0.0
 	github.com/spf13/pflag v1.0.10
 	golang.org/x/oauth2 v0.32.0
+	gopkg.in/yaml.v3 v3.0.1
 	k8s.io/klog/v2 v2.130.1
 )
 